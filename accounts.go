@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keySize is the number of random bytes in an API key.
+const keySize = 32
+
+// maxNameLength is the longest name a player or bot can have.
+const maxNameLength = 32
+
+var (
+	ErrInvalidBotName = errors.New("Bot names must be 1 to 32 characters " +
+		"long and contain no colons or whitespace")
+	ErrInvalidName = errors.New("Names must be 1 to 32 characters long and " +
+		"contain no colons or whitespace")
+	ErrBotNameTaken = errors.New("Bot name already taken")
+	ErrNameTaken    = errors.New("Name already taken")
+	ErrUnknownKey   = errors.New("Unknown API key")
+)
+
+// validName reports whether `name` can be played under. Colons are kept for
+// the `bot:` namespace, so people can't pass themselves off as bots (and
+// change their ratings) and bots can't pass themselves off as each other.
+func validName(name string) bool {
+	return name != "" && len(name) <= maxNameLength &&
+		!strings.ContainsAny(name, ": \t\r\n")
+}
+
+// Account is a registered bot or player. Only a hash of its API key is
+// stored.
+type Account struct {
+	Name    string    `json:"name"`
+	KeyHash string    `json:"key_hash"`
+	Created time.Time `json:"created"`
+}
+
+// Accounts is a store of registered bots or players, who prove who they are
+// with the API key they were given when they registered. Accounts are
+// appended (as JSON lines) to a file so they survive restarts.
+type Accounts struct {
+	Mutex    sync.RWMutex
+	Accounts map[string]Account // by key hash
+	file     *os.File
+
+	// bots is set for bots' accounts, whose names are in the `bot:`
+	// namespace
+	bots bool
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// OpenBotAccounts loads any previously registered bots from `path` and opens
+// it for appending.
+func OpenBotAccounts(path string) (*Accounts, error) {
+	return openAccounts(path, true)
+}
+
+// OpenPlayerAccounts loads any previously registered players from `path` and
+// opens it for appending.
+func OpenPlayerAccounts(path string) (*Accounts, error) {
+	return openAccounts(path, false)
+}
+
+func openAccounts(path string, bots bool) (*Accounts, error) {
+	accounts := &Accounts{Accounts: map[string]Account{}, bots: bots}
+	file, err := openJSONLines(path, func(data []byte) error {
+		var account Account
+		if err := json.Unmarshal(data, &account); err != nil {
+			return err
+		}
+		accounts.Accounts[account.KeyHash] = account
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	accounts.file = file
+	return accounts, nil
+}
+
+// Register creates an account and returns its API key. The key can't be
+// recovered later, so it must be handed to the caller now. Bot names share
+// the `bot:` namespace with the server-side bots, so they can't be confused
+// with human players; players can't register as anonymousName.
+func (a *Accounts) Register(name string) (string, error) {
+	invalid, taken := ErrInvalidName, ErrNameTaken
+	if a.bots {
+		invalid, taken = ErrInvalidBotName, ErrBotNameTaken
+	}
+	if !validName(name) {
+		return "", invalid
+	}
+	if a.bots {
+		if _, err := ParseBotLevel(name); err == nil {
+			return "", taken
+		}
+		name = "bot:" + name
+	} else if name == anonymousName {
+		return "", taken
+	}
+
+	a.Mutex.Lock()
+	defer a.Mutex.Unlock()
+	if a.registered(name) {
+		return "", taken
+	}
+
+	data := make([]byte, keySize)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(data)
+	account := Account{
+		Name:    name,
+		KeyHash: hashKey(key),
+		Created: time.Now(),
+	}
+	if a.file != nil {
+		if err := appendJSONLine(a.file, account); err != nil {
+			return "", err
+		}
+	}
+	a.Accounts[account.KeyHash] = account
+	return key, nil
+}
+
+// Registered reports whether an account has the name.
+func (a *Accounts) Registered(name string) bool {
+	a.Mutex.RLock()
+	defer a.Mutex.RUnlock()
+	return a.registered(name)
+}
+
+// registered assumes the mutex is already locked.
+func (a *Accounts) registered(name string) bool {
+	for _, account := range a.Accounts {
+		if account.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate returns the account the API key belongs to.
+func (a *Accounts) Authenticate(key string) (Account, bool) {
+	a.Mutex.RLock()
+	defer a.Mutex.RUnlock()
+	account, found := a.Accounts[hashKey(key)]
+	return account, found
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestAccountsRegister(t *testing.T) {
+	bots := &Accounts{Accounts: map[string]Account{}, bots: true}
+	players := &Accounts{Accounts: map[string]Account{}}
+	for _, testCase := range []struct {
+		name     string
+		accounts *Accounts
+		register string
+		wantName string
+		wantErr  error
+	}{{
+		name:     "bot",
+		accounts: bots,
+		register: "alice",
+		wantName: "bot:alice",
+	}, {
+		name:     "bot-taken",
+		accounts: bots,
+		register: "alice",
+		wantErr:  ErrBotNameTaken,
+	}, {
+		name:     "bot-level",
+		accounts: bots,
+		register: "optimal",
+		wantErr:  ErrBotNameTaken,
+	}, {
+		name:     "bot-invalid",
+		accounts: bots,
+		register: "bot:alice",
+		wantErr:  ErrInvalidBotName,
+	}, {
+		name:     "player",
+		accounts: players,
+		register: "alice",
+		wantName: "alice",
+	}, {
+		name:     "player-taken",
+		accounts: players,
+		register: "alice",
+		wantErr:  ErrNameTaken,
+	}, {
+		name:     "player-anonymous",
+		accounts: players,
+		register: anonymousName,
+		wantErr:  ErrNameTaken,
+	}, {
+		name:     "player-invalid",
+		accounts: players,
+		register: "al ice",
+		wantErr:  ErrInvalidName,
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			key, err := testCase.accounts.Register(testCase.register)
+			if err != testCase.wantErr {
+				t.Fatalf("Wanted error %v; got %v", testCase.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			account, found := testCase.accounts.Authenticate(key)
+			if !found || account.Name != testCase.wantName {
+				t.Fatalf("Wanted the key to be %s's", testCase.wantName)
+			}
+			if !testCase.accounts.Registered(testCase.wantName) {
+				t.Fatalf("Wanted %s registered", testCase.wantName)
+			}
+		})
+	}
+	if _, found := players.Authenticate("not-a-key"); found {
+		t.Fatal("Wanted an unknown key not to authenticate")
+	}
+}
@@ -1,12 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
-	"io"
 	"math/rand"
 	"strings"
+	"sync"
 
 	"github.com/itchyny/maze"
 )
@@ -25,8 +24,32 @@ const (
 	Down
 )
 
+var dirNames = map[Dir]string{
+	Left:  "left",
+	Right: "right",
+	Up:    "up",
+	Down:  "down",
+}
+
+func (d Dir) String() string {
+	if name, found := dirNames[d]; found {
+		return name
+	}
+	return fmt.Sprintf("Dir(%d)", int(d))
+}
+
+func ParseDir(s string) (Dir, error) {
+	for d, name := range dirNames {
+		if name == s {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown direction: %s", s)
+}
+
 type Point struct {
-	X, Y int
+	X int `json:"x"`
+	Y int `json:"y"`
 }
 
 func (p Point) Translate(d Dir) Point {
@@ -91,6 +114,7 @@ type Board struct {
 	Rows  [][]rune
 	Start Point
 	End   Point
+	Items map[Point]Item // items' tiles are spaces in Rows
 }
 
 func (b *Board) Width() int {
@@ -112,6 +136,20 @@ func (b *Board) IsPath(p Point) bool {
 		b.Rows[p.Y][p.X] != tileWall
 }
 
+// SetTile returns a copy of the board with the tile at `p` replaced. Only the
+// row that changes is copied; the rest are shared with the original board,
+// which is left as it was.
+func (b Board) SetTile(p Point, tile rune) Board {
+	rows := make([][]rune, len(b.Rows))
+	copy(rows, b.Rows)
+	row := make([]rune, len(rows[p.Y]))
+	copy(row, rows[p.Y])
+	row[p.X] = tile
+	rows[p.Y] = row
+	b.Rows = rows
+	return b
+}
+
 func (b *Board) Slice(r Rect) [][]rune {
 	rows := b.Rows[r.TopLeft.Y : r.BottomRight.Y+1]
 	outrows := make([][]rune, len(rows))
@@ -148,6 +186,15 @@ func windowCopy(window [][]rune) [][]rune {
 	return copy
 }
 
+// Text writes the board out in the format ParseBoard reads.
+func (b *Board) Text() string {
+	rows := windowCopy(b.Rows)
+	for p, item := range b.Items {
+		rows[p.Y][p.X] = rune(item)
+	}
+	return windowToString(rows)
+}
+
 func windowToString(window [][]rune) string {
 	out := ""
 	for _, row := range window {
@@ -156,83 +203,21 @@ func windowToString(window [][]rune) string {
 	return out
 }
 
-func ParseBoard(r io.Reader) (Board, error) {
-	var start *Point
-	var end *Point
-
-	scanner := bufio.NewScanner(r)
-	var rows [][]rune
-	for scanner.Scan() {
-		rows = append(rows, []rune(scanner.Text()))
-	}
-	if err := scanner.Err(); err != nil {
-		return Board{}, nil
-	}
-
-	w := len(rows[0])
-	for y, row := range rows {
-		if len(row) != w {
-			return Board{}, fmt.Errorf(
-				"Wanted row size %d; got %d",
-				w,
-				len(row),
-			)
-		}
-		for x, r := range row {
-			if r == 'E' {
-				if end != nil {
-					return Board{}, fmt.Errorf(
-						"Start position already exists at (%d, %d), but "+
-							"encountered a second end token at (%d, %d)",
-						end.X,
-						end.Y,
-						x,
-						y,
-					)
-				}
-				end = &Point{X: x, Y: y}
-				continue
-			}
-			if r == 'S' {
-				if start != nil {
-					return Board{}, fmt.Errorf(
-						"Start position already exists at (%d, %d), but "+
-							"encountered a second start token at (%d, %d)",
-						start.X,
-						start.Y,
-						x,
-						y,
-					)
-				}
-				start = &Point{X: x, Y: y}
-				continue
-			}
-			if r != '#' && r != ' ' {
-				return Board{}, fmt.Errorf(
-					"Illegal character at (%d, %d): %#v",
-					x,
-					y,
-					r,
-				)
-			}
-		}
-	}
-
-	if start == nil {
-		return Board{}, fmt.Errorf("Start position not found")
-	}
-	if end == nil {
-		return Board{}, fmt.Errorf("End position not found")
-	}
-
-	return Board{Rows: rows, Start: *start, End: *end}, nil
-}
+// generateLock serializes GenerateBoard. The maze library draws from
+// math/rand's global source, so the same seed only gives the same board if
+// nothing else seeds or draws from it between seeding and generating.
+var generateLock sync.Mutex
 
+// GenerateBoard generates a w x h cell board with the maze library. The same
+// seed always gives the same board, which relies on rand.Seed still seeding
+// the global source (see the go:debug directive in main.go).
 func GenerateBoard(seed int64, w, h int) Board {
-	rand.Seed(seed)
 	buf := bytes.NewBuffer([]byte{})
 	m := maze.NewMaze(h, w)
+	generateLock.Lock()
+	rand.Seed(seed)
 	m.Generate()
+	generateLock.Unlock()
 	m.Print(buf, &maze.Format{
 		Path:      " ",
 		Wall:      "#",
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestGenerateBoardSeed(t *testing.T) {
+	// Boards generated at the same time still come out the same for the
+	// same seed, so matches can be replayed from their seed.
+	want := map[int64]Board{
+		1: GenerateBoard(1, 8, 8),
+		2: GenerateBoard(2, 8, 8),
+	}
+	if reflect.DeepEqual(want[1], want[2]) {
+		t.Fatal("Wanted different seeds to give different boards")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		seed := int64(i%2 + 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if board := GenerateBoard(seed, 8, 8); !reflect.DeepEqual(
+				board,
+				want[seed],
+			) {
+				t.Errorf("Wanted seed %d to give the same board", seed)
+			}
+		}()
+	}
+	wg.Wait()
+}
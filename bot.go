@@ -0,0 +1,539 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type BotLevel int
+
+const (
+	BotRandom BotLevel = iota
+	BotWallFollower
+	BotTremaux
+	BotOptimal
+)
+
+// botMoveInterval is how long a bot waits between moves, which keeps them
+// roughly at human speed.
+const botMoveInterval = 150 * time.Millisecond
+
+var botLevelNames = map[BotLevel]string{
+	BotRandom:       "random",
+	BotWallFollower: "wall-follower",
+	BotTremaux:      "tremaux",
+	BotOptimal:      "optimal",
+}
+
+func (level BotLevel) String() string {
+	if name, found := botLevelNames[level]; found {
+		return name
+	}
+	panic(fmt.Sprint("Invalid bot level:", int(level)))
+}
+
+func ParseBotLevel(s string) (BotLevel, error) {
+	for level, name := range botLevelNames {
+		if name == s {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown bot level: %s", s)
+}
+
+// Bot is a server-side player. Like a UserSession, it is notified of each
+// GameState and moves through its PlayerSession, so it only knows what it can
+// see in its window.
+type Bot struct {
+	Level         BotLevel
+	lock          sync.Mutex
+	state         *GameState
+	acted         *GameState // the last state the bot moved on
+	playerSession *PlayerSession
+	brain         botBrain
+	stop          chan struct{}
+	stopOnce      sync.Once
+	logger        Logger
+	logs          chan<- interface{}
+}
+
+// NewBot creates a bot and starts its move loop; the bot must be released with
+// Stop. Whatever the bot logs is sent on `logs` (see logSerializer) once it
+// stops, unless `logs` is nil.
+func NewBot(level BotLevel, logs chan<- interface{}) *Bot {
+	bot := &Bot{Level: level, stop: make(chan struct{}), logs: logs}
+	go bot.run()
+	return bot
+}
+
+// isBot reports whether the participant is a program rather than a person;
+// bots are ranked separately from humans.
+func isBot(p Participant) bool {
+	switch p.(type) {
+	case *Bot, *BotSession:
+		return true
+	default:
+		return false
+	}
+}
+
+// Bots are rated (and ranked) per level rather than individually.
+func (bot *Bot) Name() string { return "bot:" + bot.Level.String() }
+
+func (bot *Bot) Logf(format string, v ...interface{}) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.logger.Logf(bot.Name()+": "+format, v...)
+}
+
+func (bot *Bot) GameStart(playerSession PlayerSession) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.playerSession = &playerSession
+	bot.state = nil
+	bot.brain = newBotBrain(bot.Level)
+}
+
+func (bot *Bot) ClearGame() {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.playerSession = nil
+	bot.state = nil
+}
+
+// NotifyUserState is called with the game session locked, so it only records
+// the state for the move loop to act on.
+func (bot *Bot) NotifyUserState(userState UserState) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	if userState.GameState != nil {
+		bot.state = userState.GameState
+	}
+}
+
+func (bot *Bot) Stop() {
+	bot.stopOnce.Do(func() {
+		close(bot.stop)
+		bot.lock.Lock()
+		defer bot.lock.Unlock()
+		if bot.logs != nil && len(bot.logger.data) > 0 {
+			bot.logs <- bot.logger.data
+		}
+	})
+}
+
+func (bot *Bot) run() {
+	t := time.NewTicker(botMoveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-bot.stop:
+			return
+		case <-t.C:
+			bot.step()
+		}
+	}
+}
+
+func (bot *Bot) step() {
+	// Each state is only acted on once; in a tick-based game it can take a
+	// while for a move to show up.
+	bot.lock.Lock()
+	playerSession, state, brain := bot.playerSession, bot.state, bot.brain
+	if playerSession == nil || state == nil || state == bot.acted {
+		bot.lock.Unlock()
+		return
+	}
+	bot.acted = state
+	bot.lock.Unlock()
+	_, solved := state.SolvedTimes[string(state.Token)]
+	if solved || state.Over {
+		return
+	}
+	if dir, ok := brain.Next(newBotView(state)); ok {
+		// Moving broadcasts, which calls back into NotifyUserState, so the
+		// lock must not be held here.
+		playerSession.Move(dir)
+	}
+}
+
+// botView is a bot's observation: its window positioned on the board.
+type botView struct {
+	Window [][]rune
+	Origin Point // board position of the window's top-left tile
+	Pos    Point
+	Keys   int
+}
+
+func newBotView(state *GameState) botView {
+	return botView{
+		Window: state.Tiles,
+		Origin: state.Origin,
+		Pos:    state.Position,
+		Keys:   state.Keys,
+	}
+}
+
+// Tile returns the tile at board position `p` and whether it's in view.
+func (v botView) Tile(p Point) (rune, bool) {
+	rel := p.Rel(v.Origin)
+	if rel.Y < 0 || rel.Y >= len(v.Window) ||
+		rel.X < 0 || rel.X >= len(v.Window[rel.Y]) {
+		return 0, false
+	}
+	return v.Window[rel.Y][rel.X], true
+}
+
+// passable reports whether the bot could walk onto `tile`: anything but a
+// wall, or a locked door when it has no key.
+func passable(tile rune, keys int) bool {
+	return tile != tileWall && (tile != rune(ItemDoor) || keys > 0)
+}
+
+// Open returns the directions the bot can move in right now.
+func (v botView) Open() []Dir {
+	var open []Dir
+	for _, d := range dirs {
+		tile, ok := v.Tile(v.Pos.Translate(d))
+		if ok && passable(tile, v.Keys) {
+			open = append(open, d)
+		}
+	}
+	return open
+}
+
+type botBrain interface {
+	Next(v botView) (Dir, bool)
+}
+
+func newBotBrain(level BotLevel) botBrain {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	switch level {
+	case BotRandom:
+		return &randomBrain{rng: rng}
+	case BotWallFollower:
+		return &wallFollowerBrain{rng: rng, facing: Right}
+	case BotTremaux:
+		return &tremauxBrain{
+			rng:     rng,
+			marks:   map[[2]Point]int{},
+			visited: map[Point]bool{},
+		}
+	case BotOptimal:
+		return &optimalBrain{
+			known: map[Point]rune{},
+			fresh: map[Point]bool{},
+		}
+	default:
+		panic(fmt.Sprint("Invalid bot level:", int(level)))
+	}
+}
+
+// randomBrain wanders aimlessly.
+type randomBrain struct {
+	rng *rand.Rand
+}
+
+func (b *randomBrain) Next(v botView) (Dir, bool) {
+	open := v.Open()
+	if len(open) < 1 {
+		return 0, false
+	}
+	return open[b.rng.Intn(len(open))], true
+}
+
+func turnRight(d Dir) Dir {
+	return map[Dir]Dir{Up: Right, Right: Down, Down: Left, Left: Up}[d]
+}
+
+func turnLeft(d Dir) Dir {
+	return map[Dir]Dir{Up: Left, Left: Down, Down: Right, Right: Up}[d]
+}
+
+func reverse(d Dir) Dir {
+	return turnRight(turnRight(d))
+}
+
+// wallFollowerBrain keeps its right hand on the wall, which solves any maze
+// without loops around the exit. Teleporters can make such loops, so it
+// faces a random direction whenever it has been teleported.
+type wallFollowerBrain struct {
+	rng    *rand.Rand
+	facing Dir
+	last   *Point
+}
+
+func (b *wallFollowerBrain) Next(v botView) (Dir, bool) {
+	if b.last != nil {
+		// Speed and phasing can carry it two tiles, but no further.
+		one := b.last.Translate(b.facing)
+		if v.Pos != *b.last && v.Pos != one && v.Pos != one.Translate(b.facing) {
+			b.facing = dirs[b.rng.Intn(len(dirs))]
+		}
+	}
+	pos := v.Pos
+	b.last = &pos
+
+	open := map[Dir]bool{}
+	for _, d := range v.Open() {
+		open[d] = true
+	}
+	for _, d := range []Dir{
+		turnRight(b.facing),
+		b.facing,
+		turnLeft(b.facing),
+		reverse(b.facing),
+	} {
+		if open[d] {
+			b.facing = d
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func edge(a, b Point) [2]Point {
+	if a.X < b.X || (a.X == b.X && a.Y < b.Y) {
+		return [2]Point{a, b}
+	}
+	return [2]Point{b, a}
+}
+
+// tremauxBrain marks each passage as it walks it and never walks a passage
+// more than twice, which guarantees it finds the exit.
+type tremauxBrain struct {
+	rng     *rand.Rand
+	marks   map[[2]Point]int
+	visited map[Point]bool
+	last    *Point
+	keys    int
+}
+
+func (b *tremauxBrain) Next(v botView) (Dir, bool) {
+	pos := v.Pos
+	defer func() { b.last = &pos }()
+
+	// Picking up a key can open passages that were walked as dead ends, so
+	// start over.
+	if v.Keys != b.keys {
+		b.keys = v.Keys
+		b.marks = map[[2]Point]int{}
+		b.visited = map[Point]bool{}
+		b.last = nil
+	}
+
+	// Marks are only made once the move is known to have succeeded, and
+	// only for single steps; items can carry the bot further than that.
+	var back *Dir
+	if b.last != nil {
+		for _, d := range dirs {
+			if v.Pos.Translate(d) == *b.last {
+				d := d
+				back = &d
+			}
+		}
+	}
+	if back != nil {
+		e := edge(*b.last, v.Pos)
+		b.marks[e]++
+
+		// Walking a fresh passage into a junction we've already visited
+		// means we've closed a loop, so turn around.
+		if b.visited[v.Pos] && b.marks[e] == 1 {
+			return *back, true
+		}
+	}
+	b.visited[v.Pos] = true
+
+	var fresh []Dir
+	var best *Dir
+	bestMarks := 0
+	for _, d := range v.Open() {
+		marks := b.marks[edge(v.Pos, v.Pos.Translate(d))]
+		if marks == 0 && (back == nil || d != *back) {
+			fresh = append(fresh, d)
+		}
+		if marks < 2 && (best == nil || marks < bestMarks) {
+			d := d
+			best, bestMarks = &d, marks
+		}
+	}
+	if len(fresh) > 0 {
+		return fresh[b.rng.Intn(len(fresh))], true
+	}
+	if back != nil && b.marks[edge(v.Pos, v.Pos.Translate(*back))] < 2 {
+		return *back, true
+	}
+	if best != nil {
+		return *best, true
+	}
+
+	// Items can break the rules the marks rely on, which can leave the bot
+	// with nowhere to go; start over rather than giving up.
+	if len(b.marks) > 0 {
+		b.marks = map[[2]Point]int{}
+		b.visited = map[Point]bool{}
+		b.last = nil
+		return b.Next(v)
+	}
+	return 0, false
+}
+
+// optimalBrain remembers everything it has seen. It heads for the nearest
+// checkpoint, coin or hill it knows of (and stays on the hill), then straight
+// for the exit once it has seen it (and can get there), picks up any keys it
+// knows of, and otherwise explores the nearest unseen area, so it plays as
+// well as possible given its limited vision.
+type optimalBrain struct {
+	known map[Point]rune
+	fresh map[Point]bool // seen since the bot last started exploring
+	keys  int
+
+	// early is set when the bot has reached the exit without finishing, so
+	// the objective must need something else first. It's cleared once the
+	// bot reaches a checkpoint or coin.
+	early bool
+}
+
+func (b *optimalBrain) Next(v botView) (Dir, bool) {
+	b.see(v)
+	b.keys = v.Keys
+	switch b.known[v.Pos] {
+	case markHill:
+		return 0, false
+	case markCheckpoint, markCoin:
+		b.early = false
+	case 'E':
+		b.early = true
+	}
+	if d, ok := b.next(v.Pos); ok {
+		return d, true
+	}
+
+	// Goals can turn up where the bot has already been (e.g. the next
+	// checkpoint in a sequence), so once it has nothing left to do it
+	// explores the board all over again.
+	b.fresh = map[Point]bool{}
+	b.see(v)
+	return b.next(v.Pos)
+}
+
+// see records the tiles in the bot's window.
+func (b *optimalBrain) see(v botView) {
+	for y, row := range v.Window {
+		for x, tile := range row {
+			// Players (and the ghost) hide whatever they're standing on,
+			// which is at least open.
+			p := v.Origin.Offset(Point{x, y})
+			b.fresh[p] = true
+			if tile != tileWall && tile != tileSpace && tile != 'S' &&
+				tile != 'E' && !isItem(tile) && markNames[tile] == "" {
+				if _, seen := b.known[p]; seen {
+					continue
+				}
+				tile = tileSpace
+			}
+			b.known[p] = tile
+		}
+	}
+}
+
+// next returns the first move towards the most important goal the bot knows
+// how to reach.
+func (b *optimalBrain) next(pos Point) (Dir, bool) {
+	for _, goal := range []func(Point) bool{
+		func(p Point) bool { return markNames[b.known[p]] != "" },
+		func(p Point) bool { return b.known[p] == 'E' && !b.early },
+		func(p Point) bool { return b.known[p] == rune(ItemKey) },
+		b.isFrontier,
+		func(p Point) bool { return b.known[p] == 'E' },
+	} {
+		if d, ok := b.firstStep(pos, goal); ok {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// isFrontier reports whether `p` is known to be open and borders an open or
+// unknown tile the bot hasn't seen lately, or is a teleporter whose other end
+// it hasn't seen. The exit is on the edge of the board, and negative
+// coordinates are off it, so neither leads anywhere new.
+func (b *optimalBrain) isFrontier(p Point) bool {
+	tile := b.known[p]
+	if tile == 0 || tile == 'E' || !passable(tile, b.keys) {
+		return false
+	}
+	if Item(tile).IsTeleporter() {
+		_, found := b.partner(p)
+		return !found
+	}
+	for _, d := range dirs {
+		n := p.Translate(d)
+		if !b.fresh[n] && b.known[n] != tileWall && n.X >= 0 && n.Y >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// partner returns the other end of the teleporter at `p`, if it's been seen.
+func (b *optimalBrain) partner(p Point) (Point, bool) {
+	for q, tile := range b.known {
+		if tile == b.known[p] && q != p {
+			return q, true
+		}
+	}
+	return Point{}, false
+}
+
+// firstStep returns the first move along the shortest known path from `from`
+// to the nearest point satisfying `goal`.
+func (b *optimalBrain) firstStep(from Point, goal func(Point) bool) (
+	Dir,
+	bool,
+) {
+	first := map[Point]Dir{}
+	queue := []Point{from}
+	seen := map[Point]bool{from: true}
+	unexplored := map[Point]bool{}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if p != from && goal(p) {
+			return first[p], true
+		}
+		if unexplored[p] {
+			continue
+		}
+		for _, d := range dirs {
+			n := p.Translate(d)
+			tile, known := b.known[n]
+			if !known || !passable(tile, b.keys) {
+				continue
+			}
+			// Stepping onto a teleporter lands the bot on the other end;
+			// if that hasn't been seen yet, the path can't go any further.
+			if Item(tile).IsTeleporter() {
+				if other, found := b.partner(n); found {
+					n = other
+				} else {
+					unexplored[n] = true
+				}
+			}
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			if p == from {
+				first[n] = d
+			} else {
+				first[n] = first[p]
+			}
+			queue = append(queue, n)
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Commands a bot can send over the bot socket.
+const (
+	botCommandMove  = "move"
+	botCommandStart = "start"
+	botCommandRTMM  = "rtmm"
+	botCommandSolo  = "solo"
+
+	botCommandRematch = "rematch"
+)
+
+// Messages the server sends over the bot socket.
+const (
+	botMessageLobby       = "lobby"
+	botMessageObservation = "observation"
+	botMessageAck         = "ack"
+	botMessageError       = "error"
+)
+
+// BotCommand is a single message from a bot. `Turn` and `Dir` are only used
+// by moves, `Seed` only by solo games and `Same` and `Rules` only by rematch
+// votes.
+type BotCommand struct {
+	Type  string `json:"type"`
+	Turn  int    `json:"turn,omitempty"`
+	Dir   string `json:"dir,omitempty"`
+	Seed  *int64 `json:"seed,omitempty"`
+	Same  bool   `json:"same,omitempty"`
+	Rules string `json:"rules,omitempty"`
+}
+
+// BotMessage is a single message to a bot; exactly one of the payloads is set
+// according to `Type`.
+type BotMessage struct {
+	Type        string       `json:"type"`
+	Lobby       *LobbyState  `json:"lobby,omitempty"`
+	Observation *Observation `json:"observation,omitempty"`
+	Ack         *BotAck      `json:"ack,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// Observation is what a bot can see of the game. It carries the same
+// information as a player's window, but in board coordinates.
+type Observation struct {
+	Token    string `json:"token"`
+	Turn     int    `json:"turn"` // moves the bot has made so far
+	Position Point  `json:"position"`
+
+	// Walls covers the visible part of the board; Walls[y][x] is the tile
+	// at Origin + (x, y).
+	Origin Point    `json:"origin"`
+	Walls  [][]bool `json:"walls"`
+
+	Open        []string                 `json:"open"`
+	Players     map[string]Point         `json:"players"` // visible only
+	Exit        *Point                   `json:"exit,omitempty"`
+	Solved      bool                     `json:"solved"`
+	Winner      string                   `json:"winner,omitempty"`
+	SolvedTimes map[string]time.Duration `json:"solved_times,omitempty"`
+	GameStart   time.Time                `json:"game_start"`
+	Tick        int                      `json:"tick,omitempty"`
+	Conflicts   []Conflict               `json:"conflicts,omitempty"`
+
+	// Items lists the items (and objective marks) in view; Keys and Effects
+	// are the bot's own inventory and the moves left on each of its effects.
+	Items   []ObservedItem `json:"items,omitempty"`
+	Keys    int            `json:"keys,omitempty"`
+	Effects Effects        `json:"effects"`
+
+	Objective ObjectiveRule `json:"objective"`
+	Progress  Progress      `json:"progress"`
+
+	Shift   time.Duration `json:"shift,omitempty"`
+	Changes []TileChange  `json:"changes,omitempty"`
+
+	Team      int              `json:"team,omitempty"`
+	Teammates map[string]Point `json:"teammates,omitempty"`
+	Teams     []TeamResult     `json:"teams,omitempty"`
+
+	TimeLimit time.Duration `json:"time_limit,omitempty"`
+	Grace     time.Duration `json:"grace,omitempty"`
+	Ends      time.Duration `json:"ends,omitempty"`
+	Over      bool          `json:"over,omitempty"`
+	Standings []Standing    `json:"standings,omitempty"`
+}
+
+type ObservedItem struct {
+	Kind string `json:"kind"`
+	At   Point  `json:"at"`
+}
+
+// BotAck acknowledges a move. A move into a wall is still a turn, but
+// `Moved` is false. In a tick-based game moves are `Queued` for a later tick
+// instead of being played immediately.
+type BotAck struct {
+	Turn     int   `json:"turn"`
+	Moved    bool  `json:"moved"`
+	Queued   bool  `json:"queued,omitempty"`
+	Position Point `json:"position"`
+}
+
+func newObservation(state *GameState, turn int) *Observation {
+	view := newBotView(state)
+	_, solved := state.SolvedTimes[string(state.Token)]
+	obs := &Observation{
+		Token:       string(state.Token),
+		Turn:        turn,
+		Position:    state.Position,
+		Origin:      view.Origin,
+		Walls:       make([][]bool, len(view.Window)),
+		Players:     map[string]Point{},
+		Solved:      solved,
+		Winner:      state.Winner,
+		SolvedTimes: state.SolvedTimes,
+		GameStart:   state.GameStart,
+		Tick:        state.Tick,
+		Conflicts:   state.Conflicts,
+		Keys:        state.Keys,
+		Effects:     state.Effects,
+		Objective:   state.Objective,
+		Progress:    state.Progress,
+		Shift:       state.Shift,
+		Changes:     state.Changes,
+		Team:        state.Team,
+		Teammates:   state.Teammates,
+		Teams:       state.Teams,
+		TimeLimit:   state.TimeLimit,
+		Grace:       state.Grace,
+		Ends:        state.Ends,
+		Over:        state.Over,
+		Standings:   state.Standings,
+	}
+	for _, d := range view.Open() {
+		obs.Open = append(obs.Open, d.String())
+	}
+	for y, row := range view.Window {
+		obs.Walls[y] = make([]bool, len(row))
+		for x, tile := range row {
+			p := view.Origin.Offset(Point{x, y})
+			obs.Walls[y][x] = tile == tileWall
+			switch {
+			case tile == tileWall, tile == tileSpace, tile == ghostToken,
+				tile == 'S':
+			case tile == 'E':
+				obs.Exit = &p
+			case isItem(tile):
+				obs.Items = append(obs.Items, ObservedItem{
+					Kind: Item(tile).String(),
+					At:   p,
+				})
+			case markNames[tile] != "":
+				obs.Items = append(obs.Items, ObservedItem{
+					Kind: markNames[tile],
+					At:   p,
+				})
+			default:
+				obs.Players[string(tile)] = p
+			}
+		}
+	}
+	return obs
+}
+
+// BotSession is a user-written agent playing over the bot socket. It is
+// matched and rated like any other player, but it is told what it can see as
+// structured observations and each of its moves is acknowledged.
+type BotSession struct {
+	account       Account
+	writeLock     sync.Mutex
+	transport     BotTransport
+	lock          sync.Mutex
+	playerSession *PlayerSession
+	turn          int
+	gameManager   *GameManager
+	logger        *Logger
+	rules         Rules
+}
+
+func NewBotSession(
+	gm *GameManager,
+	transport BotTransport,
+	account Account,
+	rules Rules,
+	logger *Logger,
+) *BotSession {
+	return &BotSession{
+		account:     account,
+		transport:   transport,
+		gameManager: gm,
+		logger:      logger,
+		rules:       rules,
+	}
+}
+
+func (bot *BotSession) Name() string { return bot.account.Name }
+
+func (bot *BotSession) Logf(format string, v ...interface{}) {
+	bot.logger.Logf(format, v...)
+}
+
+func (bot *BotSession) GameStart(playerSession PlayerSession) {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.playerSession = &playerSession
+	bot.turn = 0
+}
+
+func (bot *BotSession) ClearGame() {
+	bot.lock.Lock()
+	defer bot.lock.Unlock()
+	bot.playerSession = nil
+}
+
+func (bot *BotSession) NotifyUserState(userState UserState) {
+	msg := BotMessage{Type: botMessageLobby, Lobby: userState.LobbyState}
+	if userState.GameState != nil {
+		bot.lock.Lock()
+		turn := bot.turn
+		bot.lock.Unlock()
+		msg = BotMessage{
+			Type:        botMessageObservation,
+			Observation: newObservation(userState.GameState, turn),
+		}
+	}
+	bot.send(msg)
+}
+
+// send writes a message to the bot. This is called with the game or lobby
+// locked, so a failed write just closes the transport; the read loop then
+// fails and drops the bot.
+func (bot *BotSession) send(msg BotMessage) {
+	bot.writeLock.Lock()
+	defer bot.writeLock.Unlock()
+	if err := bot.transport.SendBot(msg); err != nil {
+		bot.logger.Logf("Error sending BotMessage (terminating): %v", err)
+		bot.transport.Close("")
+	}
+}
+
+func (bot *BotSession) sendError(format string, v ...interface{}) {
+	bot.send(BotMessage{
+		Type:  botMessageError,
+		Error: fmt.Sprintf(format, v...),
+	})
+}
+
+// move plays a move command. Each move must carry the next turn number, so a
+// bot never acts on a stale observation by accident.
+func (bot *BotSession) move(cmd BotCommand) {
+	dir, err := ParseDir(cmd.Dir)
+	if err != nil {
+		bot.sendError("%v", err)
+		return
+	}
+
+	bot.lock.Lock()
+	playerSession := bot.playerSession
+	if playerSession == nil {
+		bot.lock.Unlock()
+		bot.sendError("Not in a game")
+		return
+	}
+	if cmd.Turn != bot.turn+1 {
+		turn := bot.turn
+		bot.lock.Unlock()
+		bot.sendError("Wanted turn %d; got %d", turn+1, cmd.Turn)
+		return
+	}
+	bot.turn++
+	bot.lock.Unlock()
+
+	// Moving broadcasts, which calls back into NotifyUserState, so the lock
+	// must not be held here.
+	moved := playerSession.Move(dir)
+	player, _ := playerSession.GameSession.Player(playerSession.Token)
+	bot.send(BotMessage{
+		Type: botMessageAck,
+		Ack: &BotAck{
+			Turn:     cmd.Turn,
+			Moved:    moved,
+			Queued:   playerSession.GameSession.Ticked(),
+			Position: player.Pos,
+		},
+	})
+}
+
+func (bot *BotSession) Run() error {
+	gm := bot.gameManager
+	lobby := gm.Join(bot, bot.rules)
+	lobby.Broadcast()
+	for {
+		data, err := bot.transport.Receive()
+		if err != nil {
+			gm.Drop(bot)
+			return err
+		}
+
+		var cmd BotCommand
+		if err := json.Unmarshal([]byte(data), &cmd); err != nil {
+			bot.sendError("Invalid command: %v", err)
+			continue
+		}
+
+		switch cmd.Type {
+		case botCommandMove:
+			bot.move(cmd)
+		case botCommandStart:
+			lobby.Vote(bot)
+		case botCommandRTMM:
+			gm.Drop(bot)
+			lobby = gm.Join(bot, bot.rules)
+			lobby.Broadcast()
+		case botCommandRematch:
+			rematch, err := botRematch(cmd, lobby.CurrentRules())
+			if err != nil {
+				bot.sendError("%v", err)
+				continue
+			}
+			lobby.VoteRematch(bot, rematch)
+		case botCommandSolo:
+			seed := time.Now().UnixNano()
+			if cmd.Seed != nil {
+				seed = *cmd.Seed
+			}
+			gm.Drop(bot)
+			lobby = gm.JoinSolo(
+				bot,
+				DefaultBoardSpec(seed),
+				false,
+				bot.rules,
+			)
+			lobby.Broadcast()
+		default:
+			bot.sendError("Unknown command: %s", cmd.Type)
+		}
+	}
+}
+
+// botRematch returns the rematch a bot voted for. Its rules are given as
+// query parameters (see queryRules); if there are none, the `current` rules
+// carry over.
+func botRematch(cmd BotCommand, current Rules) (Rematch, error) {
+	rematch := Rematch{SameSeed: cmd.Same, Rules: current}
+	if cmd.Rules == "" {
+		return rematch, nil
+	}
+	query, err := url.ParseQuery(cmd.Rules)
+	if err != nil {
+		return Rematch{}, err
+	}
+	rematch.Rules, err = parseRules(query)
+	return rematch, err
+}
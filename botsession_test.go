@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testBot plays as a BotSession over a MemoryTransport.
+type testBot struct {
+	t         *testing.T
+	transport *MemoryTransport
+	done      chan error
+}
+
+func newTestBot(t *testing.T, gm *GameManager, name string) *testBot {
+	bot := &testBot{
+		t:         t,
+		transport: NewMemoryTransport(),
+		done:      make(chan error, 1),
+	}
+	session := NewBotSession(
+		gm,
+		bot.transport,
+		Account{Name: name},
+		Rules{},
+		&Logger{},
+	)
+	go func() { bot.done <- session.Run() }()
+	return bot
+}
+
+func (bot *testBot) send(command string) {
+	bot.t.Helper()
+	select {
+	case bot.transport.Commands <- command:
+	case <-time.After(testTimeout):
+		bot.t.Fatalf("Timed out sending %s", command)
+	}
+}
+
+// await skips messages until one of type `kind`, which it returns.
+func (bot *testBot) await(kind string) BotMessage {
+	bot.t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-bot.transport.BotMessages:
+			if msg.Type == kind {
+				return msg
+			}
+		case <-timeout:
+			bot.t.Fatalf("Timed out waiting for a %s message", kind)
+		}
+	}
+}
+
+func TestBotSession(t *testing.T) {
+	gm := &GameManager{}
+	bot := newTestBot(t, gm, "robot")
+	if msg := bot.await(botMessageLobby); msg.Lobby == nil {
+		t.Fatalf("Wanted the lobby; got %#v", msg)
+	}
+
+	bot.send(`{"type": "solo", "seed": 42}`)
+	obs := bot.await(botMessageObservation).Observation
+	board := DefaultBoardSpec(42).Generate()
+	if obs.Position != board.Start {
+		t.Fatalf("Wanted to start at %v; got %v", board.Start, obs.Position)
+	}
+	for y, row := range obs.Walls {
+		for x, wall := range row {
+			p := obs.Origin.Offset(Point{x, y})
+			if wall != (board.Rows[p.Y][p.X] == tileWall) {
+				t.Fatalf("Wanted walls to match the board at %v", p)
+			}
+		}
+	}
+	if len(obs.Open) < 1 {
+		t.Fatal("Wanted a way out of the start")
+	}
+
+	bot.send(`{"type": "move", "turn": 2, "dir": "` + obs.Open[0] + `"}`)
+	if msg := bot.await(botMessageError); msg.Error != "Wanted turn 1; got 2" {
+		t.Fatalf("Wanted a stale turn error; got %q", msg.Error)
+	}
+	bot.send(`{"type": "move", "turn": 1, "dir": "` + obs.Open[0] + `"}`)
+	ack := bot.await(botMessageAck).Ack
+	if ack.Turn != 1 || !ack.Moved || ack.Position == board.Start {
+		t.Fatalf("Wanted to move on turn 1; got %#v", ack)
+	}
+
+	close(bot.transport.Commands)
+	select {
+	case <-bot.done:
+	case <-time.After(testTimeout):
+		t.Fatal("Timed out waiting for the session to end")
+	}
+}
+
+func TestBotSessionSendError(t *testing.T) {
+	// A bot whose transport fails is dropped once its session notices.
+	gm := &GameManager{}
+	bot := newTestBot(t, gm, "robot")
+	bot.transport.Close("")
+	select {
+	case <-bot.done:
+	case <-time.After(testTimeout):
+		t.Fatal("Timed out waiting for the session to end")
+	}
+	gm.Mutex.RLock()
+	defer gm.Mutex.RUnlock()
+	if len(gm.Lobbies) != 0 {
+		t.Fatalf("Wanted the bot dropped; got %d lobbies", len(gm.Lobbies))
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ANSI escape sequences the terminal client draws with
+const (
+	ansiClear      = "\x1b[H\x1b[2J"
+	ansiReset      = "\x1b[0m"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiWall       = "\x1b[90m"
+	ansiSelf       = "\x1b[1;33m"
+	ansiTeammate   = "\x1b[1;32m"
+	ansiOpponent   = "\x1b[1;36m"
+	ansiStart      = "\x1b[34m"
+	ansiEnd        = "\x1b[1;32m"
+	ansiItem       = "\x1b[35m"
+	ansiGhost      = "\x1b[2m"
+)
+
+// Keys the terminal client reads that aren't printable characters. The arrow
+// keys come as escape sequences, so they're given codes past any byte.
+const (
+	keyCtrlC = 3
+	keyEnter = '\r'
+)
+
+const (
+	keyUp = 0x100 + iota
+	keyDown
+	keyRight
+	keyLeft
+)
+
+// arrowKeys maps the last byte of the escape sequences terminals send for the
+// arrow keys (ESC [ A and so on) to the keys.
+var arrowKeys = map[byte]rune{
+	'A': keyUp,
+	'B': keyDown,
+	'C': keyRight,
+	'D': keyLeft,
+}
+
+// clientMoves maps keys to moves: arrows, WASD and vi's hjkl.
+var clientMoves = map[rune]string{
+	keyUp: "up", keyDown: "down", keyLeft: "left", keyRight: "right",
+	'w': "up", 's': "down", 'a': "left", 'd': "right",
+	'k': "up", 'j': "down", 'h': "left", 'l': "right",
+}
+
+// clientCommands maps keys to the other messages the client sends; most only
+// mean something in some modes, and the server ignores them otherwise.
+var clientCommands = map[rune]string{
+	keyEnter: "start",
+	' ':      "start",
+	'b':      "bot",
+	'B':      "bots",
+	'r':      "rematch",
+	'R':      "rematch same",
+	'm':      "rtmm",
+}
+
+// clientState is a UserState as the client reads it.
+type clientState struct {
+	LobbyState *clientLobby `json:"lobby_state"`
+	GameState  *GameState   `json:"game_state"`
+}
+
+// clientLobby is a LobbyState whose rules are left undecoded; they're written
+// for people to read rather than to be read back.
+type clientLobby struct {
+	LobbyState
+	Rules json.RawMessage `json:"rules"`
+}
+
+// Screen draws lobbies and games for a terminal, in colour unless told not
+// to. The terminal is taken to be in raw mode, so every line ends in CRLF.
+type Screen struct {
+	Out   io.Writer
+	Color bool
+}
+
+// Client plays over the user socket in a terminal, drawing the player's
+// window in colour and reading moves from the keyboard.
+type Client struct {
+	Screen
+	Conn *websocket.Conn
+
+	state clientState
+}
+
+// playCommand connects to a server's user socket and plays in the terminal.
+func playCommand(args []string) error {
+	flags := flag.NewFlagSet("play", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: maze play [flags]")
+		fmt.Fprintln(os.Stderr, "Plays in the terminal. Move with the arrow "+
+			"keys, WASD or hjkl; q quits.")
+		flags.PrintDefaults()
+	}
+	server := flags.String("server", "ws://localhost:8080", "server to play on")
+	name := flags.String("name", os.Getenv("USER"), "name to play as")
+	rules := flags.String(
+		"rules",
+		"",
+		"rules to play by, as query parameters (e.g. tick=200ms&items=true)",
+	)
+	solo := flags.String(
+		"solo",
+		"",
+		"play alone: `daily` for the daily challenge or a seed",
+	)
+	ghost := flags.Bool("ghost", false, "race your personal best when solo")
+	color := flags.Bool("color", true, "draw in colour")
+	flags.Parse(args)
+
+	query, err := url.ParseQuery(*rules)
+	if err != nil {
+		return err
+	}
+	if *name != "" {
+		query.Set("name", *name)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(
+		strings.TrimSuffix(*server, "/")+"/user-socket/?"+query.Encode(),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if *solo != "" {
+		msg := "solo " + *solo
+		if *ghost {
+			msg += " ghost"
+		}
+		err := conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Keys are read as they're pressed, not a line at a time, unless
+	// they're being piped in.
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		old, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer terminal.Restore(fd, old)
+	}
+	client := Client{Screen: Screen{Out: os.Stdout, Color: *color}, Conn: conn}
+	fmt.Fprint(client.Out, ansiHideCursor)
+	defer fmt.Fprint(client.Out, ansiReset+ansiShowCursor+"\r\n")
+	return client.Run(os.Stdin)
+}
+
+// errClientQuit is returned by Client.Run when the player quits.
+var errClientQuit = errors.New("quit")
+
+// Run plays until the player quits or the connection is lost, redrawing the
+// screen whenever the server sends a new state.
+func (c *Client) Run(keyboard io.Reader) error {
+	states := make(chan clientState)
+	errs := make(chan error, 2)
+	go func() {
+		for {
+			var state clientState
+			if err := c.Conn.ReadJSON(&state); err != nil {
+				errs <- err
+				return
+			}
+			states <- state
+		}
+	}()
+	keys := make(chan rune)
+	go readKeys(keyboard, keys, errs)
+
+	for {
+		select {
+		case state := <-states:
+			c.state = state
+			if err := c.draw(); err != nil {
+				return err
+			}
+		case key := <-keys:
+			if err := c.press(key); err == errClientQuit {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		case err := <-errs:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// press sends whatever the key means to the server. Moves are only sent in
+// the middle of a game.
+func (c *Client) press(key rune) error {
+	if key == 'q' || key == keyCtrlC {
+		return errClientQuit
+	}
+	msg, found := clientCommands[key]
+	if move, isMove := clientMoves[key]; isMove {
+		msg, found = move, c.state.GameState != nil
+	}
+	if !found {
+		return nil
+	}
+	return c.Conn.WriteMessage(websocket.TextMessage, []byte(msg))
+}
+
+// readKeys sends each key read from `r` to `keys`, turning the escape
+// sequences sent for the arrow keys into single keys.
+func readKeys(r io.Reader, keys chan<- rune, errs chan<- error) {
+	buf := make([]byte, 16)
+	for {
+		n, err := r.Read(buf)
+		for i := 0; i < n; i++ {
+			if buf[i] == 0x1b && i+2 < n && buf[i+1] == '[' {
+				if key, found := arrowKeys[buf[i+2]]; found {
+					keys <- key
+				}
+				i += 2
+				continue
+			}
+			if buf[i] == '\n' {
+				buf[i] = keyEnter
+			}
+			keys <- rune(buf[i])
+		}
+		if err != nil {
+			errs <- err
+			return
+		}
+	}
+}
+
+// draw redraws the screen with the latest state.
+func (c *Client) draw() error {
+	var lobby *LobbyState
+	if c.state.LobbyState != nil {
+		lobby = &c.state.LobbyState.LobbyState
+	}
+	return c.Draw(lobby, c.state.GameState)
+}
+
+// Draw redraws the whole screen: the game, if one's being played, or else the
+// lobby.
+func (s Screen) Draw(lobby *LobbyState, game *GameState) error {
+	var lines []string
+	switch {
+	case game != nil:
+		lines = s.gameLines(game)
+	case lobby != nil:
+		lines = s.lobbyLines(lobby)
+	}
+	_, err := io.WriteString(
+		s.Out,
+		ansiClear+strings.Join(lines, "\r\n")+"\r\n",
+	)
+	return err
+}
+
+// lobbyLines describes the lobby: who's in it while it fills up, or the
+// final standings once its match is over.
+func (s Screen) lobbyLines(lobby *LobbyState) []string {
+	if lobby.Over {
+		lines := []string{"Match over", ""}
+		lines = append(lines, standingLines(lobby.Standings)...)
+		return append(lines,
+			"",
+			fmt.Sprintf(
+				"%d of %d voted for a rematch",
+				lobby.Rematches,
+				lobby.Players-lobby.Bots,
+			),
+			"[r] rematch  [R] rematch on the same board  "+
+				"[m] back to matchmaking  [q] quit",
+		)
+	}
+	status := fmt.Sprintf(
+		"Waiting for players: %d of %d (%d bots), %d voted to start",
+		lobby.Players,
+		lobby.Total,
+		lobby.Bots,
+		lobby.Votes,
+	)
+	if lobby.ETA > 0 {
+		status += fmt.Sprintf(
+			", full in about %v",
+			lobby.ETA.Round(time.Second),
+		)
+	}
+	return []string{
+		status,
+		"",
+		"[enter] start now  [b] add a bot  [B] fill with bots  [q] quit",
+	}
+}
+
+// standingLines lists the final standings of a match.
+func standingLines(standings []Standing) []string {
+	var lines []string
+	for _, s := range standings {
+		result := "did not finish"
+		if s.Finished {
+			result = s.Time.Round(time.Millisecond).String()
+		}
+		if s.Won {
+			result += " (won)"
+		}
+		lines = append(lines, fmt.Sprintf(
+			"%2d. %s %s: %s",
+			s.Rank,
+			s.Token,
+			s.Name,
+			result,
+		))
+	}
+	return lines
+}
+
+// gameLines draws the player's window and how the game is going.
+func (s Screen) gameLines(game *GameState) []string {
+	status := fmt.Sprintf("You are %c", game.Token)
+	if game.Team > 0 {
+		status += fmt.Sprintf(" on team %d", game.Team)
+	}
+	if game.Daily != "" {
+		status += fmt.Sprintf(
+			", daily challenge %s attempt %d",
+			game.Daily,
+			game.Attempt,
+		)
+	}
+	if game.Keys > 0 {
+		status += fmt.Sprintf(", %d keys", game.Keys)
+	}
+	if game.PersonalBest > 0 {
+		status += fmt.Sprintf(
+			", personal best %v",
+			game.PersonalBest.Round(time.Millisecond),
+		)
+	}
+	lines := []string{status, ""}
+	window := strings.TrimSuffix(game.Window, "\n")
+	for _, row := range strings.Split(window, "\n") {
+		lines = append(lines, s.colorRow(row, game))
+	}
+	lines = append(lines, "")
+
+	if game.Winner != "" {
+		lines = append(lines, "Winner: "+game.Winner)
+	}
+	if len(game.SolvedTimes) > 0 {
+		lines = append(lines, "Finished:")
+		tokens := make([]string, 0, len(game.SolvedTimes))
+		for token := range game.SolvedTimes {
+			tokens = append(tokens, token)
+		}
+		sort.Slice(tokens, func(i, j int) bool {
+			return game.SolvedTimes[tokens[i]] < game.SolvedTimes[tokens[j]]
+		})
+		for _, token := range tokens {
+			lines = append(lines, fmt.Sprintf(
+				"  %s %v",
+				token,
+				game.SolvedTimes[token].Round(time.Millisecond),
+			))
+		}
+	}
+	if game.Over {
+		lines = append(lines, "", "Match over")
+		lines = append(lines, standingLines(game.Standings)...)
+	}
+	return append(lines,
+		"",
+		"[arrows/wasd/hjkl] move  [m] back to matchmaking  [q] quit",
+	)
+}
+
+// colorRow colours a row of the window: the player, their teammates and
+// opponents, walls, the start and end, and items.
+func (s Screen) colorRow(row string, game *GameState) string {
+	if !s.Color {
+		return row
+	}
+	players := map[rune]bool{}
+	for _, token := range game.Players {
+		players[token] = true
+	}
+	var out []string
+	for _, r := range row {
+		color := ""
+		switch {
+		case r == game.Token:
+			color = ansiSelf
+		case players[r]:
+			color = ansiOpponent
+			if _, found := game.Teammates[string(r)]; found {
+				color = ansiTeammate
+			}
+		case r == tileWall:
+			color = ansiWall
+		case r == 'S':
+			color = ansiStart
+		case r == 'E':
+			color = ansiEnd
+		case r == ghostToken:
+			color = ansiGhost
+		case isItem(r):
+			color = ansiItem
+		}
+		if color == "" {
+			out = append(out, string(r))
+			continue
+		}
+		out = append(out, color+string(r)+ansiReset)
+	}
+	return strings.Join(out, "")
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commands are the tools the binary runs instead of the server, by name:
+// `maze <command> [flags] [args]`. Each parses its own flags.
+var commands = map[string]func(args []string) error{
+	"generate": generateCommand,
+	"solve":    solveCommand,
+	"validate": validateCommand,
+	"render":   renderCommand,
+	"replay":   replayCommand,
+	"play":     playCommand,
+	"telnet":   telnetCommand,
+}
+
+// runCommand runs the command named by the first argument, if there is one,
+// and exits. Otherwise it returns so the server can start.
+func runCommand(args []string) {
+	if len(args) < 1 {
+		return
+	}
+	command, found := commands[args[0]]
+	if !found {
+		return
+	}
+	if err := command(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// openInput opens the named file for reading, or stdin if the name is empty
+// or `-`.
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+// createOutput creates the named file for writing, or returns stdout if the
+// name is empty or `-`.
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+// readBoard reads a board written in `format` from the named file, or stdin
+// (see openInput). Text is parsed leniently.
+func readBoard(path string, format BoardFormat) (Board, error) {
+	input, err := openInput(path)
+	if err != nil {
+		return Board{}, err
+	}
+	defer input.Close()
+	return DecodeBoard(input, format, ParseOptions{Lenient: true})
+}
+
+// writeOutput calls `write` with the named file, or stdout (see
+// createOutput), and closes it.
+func writeOutput(path string, write func(w io.Writer) error) error {
+	out, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	if err := write(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// boardFormatFlag adds a flag naming a board format to `flags`.
+func boardFormatFlag(flags *flag.FlagSet, name, usage string) *string {
+	return flags.String(
+		name,
+		FormatText.String(),
+		usage+": text, json, png or bitmask",
+	)
+}
+
+// readReplay loads the replay of a recorded match from the results at
+// `resultsPath`, looking up its maze, if it was played on a stored one, in the
+// library at `mazesPath`.
+func readReplay(resultsPath, mazesPath, matchID string) (Replay, error) {
+	results, err := ReadResults(resultsPath)
+	if err != nil {
+		return Replay{}, err
+	}
+	mazes, err := readMazes(mazesPath)
+	if err != nil {
+		return Replay{}, err
+	}
+	return NewReplay(results.Match(matchID), mazes)
+}
+
+// readMazes loads the maze library at `path` for looking mazes up. A missing
+// library is empty.
+func readMazes(path string) (*MazeLibrary, error) {
+	mazes, err := ReadMazeLibrary(path)
+	if os.IsNotExist(err) {
+		return &MazeLibrary{Mazes: map[string]StoredMaze{}}, nil
+	}
+	return mazes, err
+}
+
+// renderCommand draws a recorded match, or a board read from a file, as an
+// image (see Replay.Render).
+func renderCommand(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: maze render [flags] [board-file]")
+		fmt.Fprintln(os.Stderr, "Renders the recorded match given by -match "+
+			"or else the board in board-file (or stdin).")
+		flags.PrintDefaults()
+	}
+	matchID := flags.String("match", "", "ID of the recorded match to render")
+	resultsPath := flags.String(
+		"results",
+		"./results.jsonl",
+		"results to find the match in",
+	)
+	mazesPath := flags.String(
+		"mazes",
+		"./mazes.jsonl",
+		"maze library to find the match's maze in",
+	)
+	player := flags.String("player", "", "only draw this player's path")
+	format := flags.String("format", "png", "image format: svg, png or gif")
+	scale := flags.Int("scale", defaultRenderScale, "pixels per tile")
+	in := boardFormatFlag(flags, "in", "format of the board file")
+	output := flags.String("o", "", "file to write to (default stdout)")
+	flags.Parse(args)
+
+	f, n, err := renderOptions(*format, strconv.Itoa(*scale))
+	if err != nil {
+		return err
+	}
+	inFormat, err := ParseBoardFormat(*in)
+	if err != nil {
+		return err
+	}
+	var replay Replay
+	if *matchID != "" {
+		replay, err = readReplay(*resultsPath, *mazesPath, *matchID)
+		if err != nil {
+			return err
+		}
+	} else {
+		board, err := readBoard(flags.Arg(0), inFormat)
+		if err != nil {
+			return err
+		}
+		replay = Replay{Board: board}
+	}
+	if *player != "" {
+		var found bool
+		if replay, found = replay.Only(*player); !found {
+			return fmt.Errorf("Player not in match: %s", *player)
+		}
+	}
+
+	return writeOutput(*output, func(w io.Writer) error {
+		return replay.Render(w, f, n)
+	})
+}
+
+// generateCommand generates a board, as the server would for a lobby.
+func generateCommand(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	seed := flags.Int64(
+		"seed",
+		0,
+		"seed to generate the board from (default the current time)",
+	)
+	width := flags.Int("width", boardWidth, "width in cells")
+	height := flags.Int("height", boardHeight, "height in cells")
+	generator := flags.String(
+		"generator",
+		defaultGenerator,
+		"generator to use: "+strings.Join(generatorNames(), ", "),
+	)
+	items := flags.Bool("items", false, "scatter items over the board")
+	format := boardFormatFlag(flags, "format", "format to write the board in")
+	output := flags.String("o", "", "file to write to (default stdout)")
+	flags.Parse(args)
+
+	seeded := false
+	flags.Visit(func(f *flag.Flag) { seeded = seeded || f.Name == "seed" })
+	if !seeded {
+		*seed = time.Now().UnixNano()
+		fmt.Fprintln(os.Stderr, "Seed:", *seed)
+	}
+	spec := BoardSpec{
+		Seed:      *seed,
+		Width:     *width,
+		Height:    *height,
+		Generator: *generator,
+		Items:     *items,
+	}
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	f, err := ParseBoardFormat(*format)
+	if err != nil {
+		return err
+	}
+	board := spec.Generate()
+	return writeOutput(*output, func(w io.Writer) error {
+		return EncodeBoard(w, board, f, map[string]string{
+			"seed":      strconv.FormatInt(spec.Seed, 10),
+			"generator": spec.Generator,
+		})
+	})
+}
+
+// generatorNames lists the generators by name.
+func generatorNames() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Solution is a shortest path through a board, as written by the `solve`
+// command. Items are ignored, as they are for the shortest path solves are
+// measured against.
+type Solution struct {
+	Length int      `json:"length"` // in moves
+	Path   []Point  `json:"path"`
+	Moves  []string `json:"moves"`
+}
+
+// newSolution describes the path, which runs along adjacent tiles.
+func newSolution(path []Point) Solution {
+	solution := Solution{Length: len(path) - 1, Path: path}
+	for i := 1; i < len(path); i++ {
+		for _, d := range dirs {
+			if path[i-1].Translate(d) == path[i] {
+				solution.Moves = append(solution.Moves, d.String())
+			}
+		}
+	}
+	return solution
+}
+
+// Ways the `solve` command can write a solution
+const (
+	solutionBoard = "board" // the board with the path drawn on it
+	solutionMoves = "moves" // a direction per line
+	solutionJSON  = "json"  // a Solution
+)
+
+// pathTile marks the path on boards written by the `solve` command.
+const pathTile = '.'
+
+// solveCommand finds a shortest path from a board's start to its end.
+func solveCommand(args []string) error {
+	flags := flag.NewFlagSet("solve", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: maze solve [flags] [board-file]")
+		fmt.Fprintln(os.Stderr, "Finds a shortest path through the board in "+
+			"board-file (or stdin), ignoring items.")
+		flags.PrintDefaults()
+	}
+	in := boardFormatFlag(flags, "in", "format of the board file")
+	format := flags.String(
+		"format",
+		solutionBoard,
+		"how to write the solution: board (with the path drawn in '.'), "+
+			"moves (a direction per line) or json",
+	)
+	output := flags.String("o", "", "file to write to (default stdout)")
+	flags.Parse(args)
+
+	inFormat, err := ParseBoardFormat(*in)
+	if err != nil {
+		return err
+	}
+	board, err := readBoard(flags.Arg(0), inFormat)
+	if err != nil {
+		return err
+	}
+	path := board.ShortestPath(board.Start, board.End)
+	if path == nil {
+		return errors.New("The end can't be reached from the start")
+	}
+	solution := newSolution(path)
+
+	var write func(w io.Writer) error
+	switch *format {
+	case solutionBoard:
+		write = func(w io.Writer) error {
+			rows := windowCopy(board.Rows)
+			for _, p := range path {
+				if rows[p.Y][p.X] == tileSpace {
+					rows[p.Y][p.X] = pathTile
+				}
+			}
+			for p, item := range board.Items {
+				rows[p.Y][p.X] = rune(item)
+			}
+			_, err := io.WriteString(w, windowToString(rows))
+			return err
+		}
+	case solutionMoves:
+		write = func(w io.Writer) error {
+			for _, move := range solution.Moves {
+				if _, err := fmt.Fprintln(w, move); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case solutionJSON:
+		write = func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(solution)
+		}
+	default:
+		return fmt.Errorf("Unknown solution format: %s", *format)
+	}
+	return writeOutput(*output, write)
+}
+
+// validateCommand checks boards, reporting every problem with each, and
+// fails if any is invalid.
+func validateCommand(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: maze validate [flags] [board-file...]")
+		fmt.Fprintln(os.Stderr, "Checks that each board-file (or stdin) is a "+
+			"board that can be solved.")
+		flags.PrintDefaults()
+	}
+	in := boardFormatFlag(flags, "in", "format of the board files")
+	strict := flags.Bool(
+		"strict",
+		false,
+		"don't allow trailing whitespace, blank lines or short rows in text",
+	)
+	upload := flags.Bool(
+		"upload",
+		false,
+		"also check the size limits on mazes uploaded to the library",
+	)
+	flags.Parse(args)
+
+	format, err := ParseBoardFormat(*in)
+	if err != nil {
+		return err
+	}
+	paths := flags.Args()
+	if len(paths) < 1 {
+		paths = []string{"-"}
+	}
+	invalid := 0
+	for _, path := range paths {
+		board, err := validateBoard(path, format, *strict, *upload)
+		if err == nil {
+			fmt.Printf(
+				"%s: ok, %dx%d tiles, shortest path %d\n",
+				path,
+				board.Width(),
+				board.Height(),
+				board.ShortestPathLength(),
+			)
+			continue
+		}
+		invalid++
+		if invalid, ok := err.(InvalidMazeError); ok {
+			err = invalid.Err
+		}
+		if errs, ok := err.(ParseErrors); ok {
+			for _, err := range errs {
+				if err.Line < 1 {
+					fmt.Printf("%s: %s\n", path, err.Message)
+					continue
+				}
+				fmt.Printf(
+					"%s:%d:%d: %s\n",
+					path,
+					err.Line,
+					err.Column,
+					err.Message,
+				)
+			}
+			continue
+		}
+		fmt.Printf("%s: %v\n", path, err)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d boards invalid", invalid, len(paths))
+	}
+	return nil
+}
+
+// validateBoard reads and checks a single board for validateCommand.
+func validateBoard(
+	path string,
+	format BoardFormat,
+	strict bool,
+	upload bool,
+) (Board, error) {
+	input, err := openInput(path)
+	if err != nil {
+		return Board{}, err
+	}
+	defer input.Close()
+	if upload {
+		data, err := ioutil.ReadAll(input)
+		if err != nil {
+			return Board{}, err
+		}
+		return ValidateMaze(data, format)
+	}
+	board, err := DecodeBoard(input, format, ParseOptions{Lenient: !strict})
+	if err != nil {
+		return Board{}, err
+	}
+	if !board.Solvable() {
+		return Board{}, errors.New("The end can't be reached from the start")
+	}
+	return board, nil
+}
+
+// replayCommand replays a recorded match through the game's rules, reporting
+// any moves that went differently (see Replay.Play).
+func replayCommand(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	matchID := flags.String("match", "", "ID of the recorded match to replay")
+	resultsPath := flags.String(
+		"results",
+		"./results.jsonl",
+		"results to find the match in",
+	)
+	mazesPath := flags.String(
+		"mazes",
+		"./mazes.jsonl",
+		"maze library to find the match's maze in",
+	)
+	player := flags.String("player", "", "only replay this player's moves")
+	frames := flags.Bool("frames", false, "draw the board after every move")
+	output := flags.String("o", "", "file to write to (default stdout)")
+	flags.Parse(args)
+
+	if *matchID == "" {
+		return errors.New("-match is required")
+	}
+	replay, err := readReplay(*resultsPath, *mazesPath, *matchID)
+	if err != nil {
+		return err
+	}
+	if *player != "" {
+		var found bool
+		if replay, found = replay.Only(*player); !found {
+			return fmt.Errorf("Player not in match: %s", *player)
+		}
+	}
+
+	return writeOutput(*output, func(w io.Writer) error {
+		fmt.Fprintf(
+			w,
+			"Match %s: %dx%d board; %d finished; shifts: %d\n",
+			replay.MatchID,
+			replay.Board.Width(),
+			replay.Board.Height(),
+			len(replay.Players),
+			len(replay.Shifts),
+		)
+		for _, p := range replay.Players {
+			fmt.Fprintf(
+				w,
+				"%c %s: finished in %v with %d moves\n",
+				p.Token,
+				p.Name,
+				p.Duration,
+				len(p.Path),
+			)
+		}
+		moves, diverged := 0, 0
+		replay.Play(func(step ReplayStep) {
+			moves++
+			if *frames {
+				fmt.Fprintf(
+					w,
+					"\n%v: %c %s\n%s",
+					step.Move.Elapsed,
+					step.Move.Player,
+					step.Move.Dir,
+					step.Game.PlayerWindow(step.Move.Player),
+				)
+			}
+			if step.Diverged() {
+				diverged++
+				fmt.Fprintf(
+					w,
+					"%v: %c moved %s to (%d, %d), not (%d, %d) as recorded\n",
+					step.Move.Elapsed,
+					step.Move.Player,
+					step.Move.Dir,
+					step.Landed.X,
+					step.Landed.Y,
+					step.Move.To.X,
+					step.Move.To.Y,
+				)
+			}
+		})
+		_, err := fmt.Fprintf(
+			w,
+			"Replayed %d moves, %d diverged\n",
+			moves,
+			diverged,
+		)
+		return err
+	})
+}
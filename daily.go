@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// DailySeed derives the board seed for the UTC day containing `t`, so every
+// player gets the same board on the same day.
+func DailySeed(t time.Time) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(t.UTC().Format(dateFormat)))
+	return int64(h.Sum64())
+}
+
+// DailyConfig controls the board size and generator used for new daily
+// challenges. Changing it doesn't affect a day that has already been played.
+type DailyConfig struct {
+	Width     int
+	Height    int
+	Generator string
+}
+
+// DailyAttempt is the record of a player starting the daily challenge.
+type DailyAttempt struct {
+	Day     string    `json:"day"`
+	Player  string    `json:"player"`
+	Spec    BoardSpec `json:"spec"`
+	Started time.Time `json:"started"`
+}
+
+// DailyChallenge summarizes a single day's challenge for the archive.
+type DailyChallenge struct {
+	Day      string            `json:"day"`
+	Spec     BoardSpec         `json:"spec"`
+	Attempts int               `json:"attempts"`
+	Players  int               `json:"players"`
+	Best     *LeaderboardEntry `json:"best,omitempty"`
+}
+
+// Daily tracks attempts at the daily challenge. Attempts are appended (as JSON
+// lines) to a file so they survive restarts.
+type Daily struct {
+	Mutex    sync.Mutex
+	Config   DailyConfig
+	Attempts []DailyAttempt
+	file     *os.File
+}
+
+// OpenDaily loads any previously stored attempts from `path` and opens it for
+// appending.
+func OpenDaily(path string, config DailyConfig) (*Daily, error) {
+	daily := &Daily{Config: config}
+	file, err := openJSONLines(path, func(data []byte) error {
+		var attempt DailyAttempt
+		if err := json.Unmarshal(data, &attempt); err != nil {
+			return err
+		}
+		daily.Attempts = append(daily.Attempts, attempt)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	daily.file = file
+	return daily, nil
+}
+
+// Today returns the day and board spec for the current daily challenge.
+func (d *Daily) Today() (string, BoardSpec) {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+	now := time.Now()
+	return now.UTC().Format(dateFormat), d.spec(now)
+}
+
+// spec returns the board spec for the day containing `t`. Once a day has been
+// attempted its spec is fixed, even if the config changes. This assumes the
+// mutex is already locked.
+func (d *Daily) spec(t time.Time) BoardSpec {
+	day := t.UTC().Format(dateFormat)
+	for _, attempt := range d.Attempts {
+		if attempt.Day == day {
+			return attempt.Spec
+		}
+	}
+	return BoardSpec{
+		Seed:      DailySeed(t),
+		Width:     d.Config.Width,
+		Height:    d.Config.Height,
+		Generator: d.Config.Generator,
+	}
+}
+
+// Attempt records that the player has started today's challenge. It returns
+// the day, the board spec and how many times the player has attempted it
+// (including this attempt). The attempt is counted even if persisting it
+// fails.
+func (d *Daily) Attempt(player string) (string, BoardSpec, int, error) {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+	now := time.Now()
+	attempt := DailyAttempt{
+		Day:     now.UTC().Format(dateFormat),
+		Player:  player,
+		Spec:    d.spec(now),
+		Started: now,
+	}
+	d.Attempts = append(d.Attempts, attempt)
+
+	count := 0
+	for _, a := range d.Attempts {
+		if a.Day == attempt.Day && a.Player == player {
+			count++
+		}
+	}
+
+	var err error
+	if d.file != nil {
+		err = appendJSONLine(d.file, attempt)
+	}
+	return attempt.Day, attempt.Spec, count, err
+}
+
+// Archive summarizes every day that has been attempted, most recent first.
+func (d *Daily) Archive(results *Results) []DailyChallenge {
+	d.Mutex.Lock()
+	defer d.Mutex.Unlock()
+
+	days := map[string]*DailyChallenge{}
+	players := map[string]map[string]struct{}{}
+	for _, attempt := range d.Attempts {
+		challenge, found := days[attempt.Day]
+		if !found {
+			challenge = &DailyChallenge{Day: attempt.Day, Spec: attempt.Spec}
+			days[attempt.Day] = challenge
+			players[attempt.Day] = map[string]struct{}{}
+		}
+		challenge.Attempts++
+		players[attempt.Day][attempt.Player] = struct{}{}
+	}
+
+	archive := make([]DailyChallenge, 0, len(days))
+	for day, challenge := range days {
+		challenge.Players = len(players[day])
+		if results != nil {
+			page, err := results.Leaderboard(dailyBoard(day), 0, 1)
+			if err == nil && len(page.Entries) > 0 {
+				challenge.Best = &page.Entries[0]
+			}
+		}
+		archive = append(archive, *challenge)
+	}
+	sort.Slice(archive, func(i, j int) bool {
+		return archive[i].Day > archive[j].Day
+	})
+	return archive
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Bounds on the time limit and the grace period players can ask for
+const (
+	minTimeLimit = 10 * time.Second
+	maxTimeLimit = time.Hour
+	minGrace     = time.Second
+	maxGrace     = 5 * time.Minute
+)
+
+// clockInterval is how often a real-time game with a time limit or a grace
+// period checks whether it's time to end.
+const clockInterval = 100 * time.Millisecond
+
+// Standing is a player's place in the final standings of a match. Players who
+// didn't finish share the last place.
+type Standing struct {
+	Rank     int           `json:"rank"`
+	Token    string        `json:"token"`
+	Name     string        `json:"name"`
+	Team     int           `json:"team,omitempty"`
+	Finished bool          `json:"finished"`
+	Time     time.Duration `json:"time,omitempty"`
+	Won      bool          `json:"won,omitempty"`
+}
+
+// Ends returns how far into the game it will end because of its time limit
+// or, once somebody has finished, its grace period. The `bool` is false if
+// neither applies (yet).
+func (g Game) Ends() (time.Duration, bool) {
+	var ends time.Duration
+	if g.TimeLimit > 0 {
+		ends = g.TimeLimit
+	}
+	if first, found := g.firstFinish(); found && g.Grace > 0 {
+		if grace := first + g.Grace; ends == 0 || grace < ends {
+			ends = grace
+		}
+	}
+	return ends, ends > 0
+}
+
+// firstFinish returns how far into the game the first player finished.
+func (g Game) firstFinish() (time.Duration, bool) {
+	var first time.Duration
+	found := false
+	for _, duration := range g.SolvedTimes {
+		if !found || duration < first {
+			first, found = duration, true
+		}
+	}
+	return first, found
+}
+
+// due reports whether the game should end: because time is up, or because
+// nobody is left playing.
+func (g Game) due() bool {
+	if ends, found := g.Ends(); found && g.Elapsed() >= ends {
+		return true
+	}
+	if len(g.Players) < 1 {
+		return false
+	}
+	for _, p := range g.Players {
+		if g.active(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// End ends the game; nobody can move once it's over. A team game that is
+// still undecided goes to the best of the teams that have finished.
+func (g Game) End() Game {
+	g.Over = true
+	if g.teamGame() {
+		g = g.decideTeams()
+	}
+	return g
+}
+
+// standings ranks the players by when they finished, ahead of those who
+// didn't. Players who have left are included. This assumes the mutex is
+// already locked.
+func (gs *GameSession) standings() []Standing {
+	var standings []Standing
+	for pid, name := range gs.names {
+		player, _ := gs.Game.Player(pid)
+		duration, finished := gs.Game.SolvedTimes[pid]
+		standings = append(standings, Standing{
+			Token:    string(pid),
+			Name:     name,
+			Team:     player.Team,
+			Finished: finished,
+			Time:     duration,
+			Won:      gs.Game.won(pid),
+		})
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		l, r := standings[i], standings[j]
+		if l.Finished != r.Finished {
+			return l.Finished
+		}
+		if l.Time != r.Time {
+			return l.Time < r.Time
+		}
+		return l.Token < r.Token
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && !standings[i].Finished && !standings[i-1].Finished {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGameEnds(t *testing.T) {
+	for _, testCase := range []struct {
+		name      string
+		limit     time.Duration
+		grace     time.Duration
+		finishes  []time.Duration
+		wantEnds  time.Duration
+		wantFound bool
+	}{{
+		name: "no-limits",
+	}, {
+		name:      "time-limit",
+		limit:     10 * time.Second,
+		wantEnds:  10 * time.Second,
+		wantFound: true,
+	}, {
+		name:  "grace-before-anyone-finishes",
+		grace: 5 * time.Second,
+	}, {
+		name:      "grace-after-first-finish",
+		grace:     5 * time.Second,
+		finishes:  []time.Duration{4 * time.Second, 3 * time.Second},
+		wantEnds:  8 * time.Second,
+		wantFound: true,
+	}, {
+		name:      "grace-sooner",
+		limit:     10 * time.Second,
+		grace:     5 * time.Second,
+		finishes:  []time.Duration{3 * time.Second},
+		wantEnds:  8 * time.Second,
+		wantFound: true,
+	}, {
+		name:      "limit-sooner",
+		limit:     6 * time.Second,
+		grace:     5 * time.Second,
+		finishes:  []time.Duration{3 * time.Second},
+		wantEnds:  6 * time.Second,
+		wantFound: true,
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			g := Game{
+				TimeLimit:   testCase.limit,
+				Grace:       testCase.grace,
+				SolvedTimes: map[rune]time.Duration{},
+			}
+			for i, finish := range testCase.finishes {
+				g.SolvedTimes[playerTokens[i]] = finish
+			}
+			ends, found := g.Ends()
+			if ends != testCase.wantEnds || found != testCase.wantFound {
+				t.Fatalf(
+					"Wanted %v, %t; got %v, %t",
+					testCase.wantEnds,
+					testCase.wantFound,
+					ends,
+					found,
+				)
+			}
+		})
+	}
+}
+
+func TestGameDue(t *testing.T) {
+	// Tick-based games are due to end on the tick that reaches the time
+	// limit, or the end of the grace period, or once nobody is left playing.
+	for _, testCase := range []struct {
+		name    string
+		limit   time.Duration
+		grace   time.Duration
+		moves   []map[rune]Dir // a tick's worth each
+		wantDue []bool         // after each tick
+	}{{
+		name:    "time-limit",
+		limit:   300 * time.Millisecond,
+		moves:   []map[rune]Dir{{}, {}, {}},
+		wantDue: []bool{false, false, true},
+	}, {
+		name:  "grace",
+		grace: 200 * time.Millisecond,
+		moves: []map[rune]Dir{
+			{'@': Right}, // '@' finishes on the first tick
+			{},
+			{},
+		},
+		wantDue: []bool{false, false, true},
+	}, {
+		name: "everybody-finished",
+		moves: []map[rune]Dir{
+			{'@': Right},
+			{'$': Down},
+		},
+		wantDue: []bool{false, true},
+	}, {
+		name:    "no-limits",
+		moves:   []map[rune]Dir{{}, {}, {}},
+		wantDue: []bool{false, false, false},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			g := newTestGame(
+				t,
+				testBoard,
+				CollisionNone,
+				map[rune]Point{'@': {4, 2}, '$': {5, 1}},
+			)
+			g.TimeLimit = testCase.limit
+			g.Grace = testCase.grace
+			for i, moves := range testCase.moves {
+				g = g.Step(moves)
+				if due := g.due(); due != testCase.wantDue[i] {
+					t.Fatalf(
+						"Wanted due %t after tick %d; got %t",
+						testCase.wantDue[i],
+						g.Tick,
+						due,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestStandings(t *testing.T) {
+	gs := &GameSession{
+		Game: Game{
+			Players: []Player{
+				{ID: '@'},
+				{ID: '$'},
+				{ID: '&'},
+				{ID: '%'},
+			},
+			SolvedTimes: map[rune]time.Duration{
+				'@': 5 * time.Second,
+				'$': 3 * time.Second,
+			},
+			Winner: '$',
+			Over:   true,
+		},
+		names: map[rune]string{
+			'@': "alice",
+			'$': "bob",
+			'&': "carol",
+			'%': "dave",
+		},
+	}
+	want := []Standing{
+		{
+			Rank:     1,
+			Token:    "$",
+			Name:     "bob",
+			Finished: true,
+			Time:     3 * time.Second,
+			Won:      true,
+		},
+		{
+			Rank:     2,
+			Token:    "@",
+			Name:     "alice",
+			Finished: true,
+			Time:     5 * time.Second,
+		},
+		{Rank: 3, Token: "%", Name: "dave"},
+		{Rank: 3, Token: "&", Name: "carol"},
+	}
+	if got := gs.standings(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Wanted %#v; got %#v", want, got)
+	}
+}
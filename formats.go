@@ -0,0 +1,627 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BoardFormat is a way of writing a board down, for trading mazes with other
+// tools.
+type BoardFormat int
+
+const (
+	// FormatText is the `#`/space drawing ParseBoard reads
+	FormatText BoardFormat = iota
+
+	// FormatJSON spells out the board's size, tiles, start, end and items,
+	// along with any metadata (see BoardJSON)
+	FormatJSON
+
+	// FormatPNG is an image with a pixel per tile (see tileColors)
+	FormatPNG
+
+	// FormatBitmask lists the walls around each cell of a board laid out as
+	// a grid of cells (see EncodeBitmask)
+	FormatBitmask
+)
+
+var boardFormatNames = map[BoardFormat]string{
+	FormatText:    "text",
+	FormatJSON:    "json",
+	FormatPNG:     "png",
+	FormatBitmask: "bitmask",
+}
+
+var boardFormatContentTypes = map[BoardFormat]string{
+	FormatText:    "text/plain; charset=utf-8",
+	FormatJSON:    "application/json",
+	FormatPNG:     "image/png",
+	FormatBitmask: "text/plain; charset=utf-8",
+}
+
+func (f BoardFormat) String() string {
+	if name, found := boardFormatNames[f]; found {
+		return name
+	}
+	return fmt.Sprintf("BoardFormat(%d)", int(f))
+}
+
+func (f BoardFormat) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.String())
+}
+
+// ContentType is the media type of boards written in the format.
+func (f BoardFormat) ContentType() string {
+	return boardFormatContentTypes[f]
+}
+
+func ParseBoardFormat(s string) (BoardFormat, error) {
+	for format, name := range boardFormatNames {
+		if name == s {
+			return format, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown board format: %s", s)
+}
+
+// DecodeBoard reads a board written in any format. Text is parsed with
+// `opts`; the other formats are exact, so the options don't apply. Whatever
+// the format, problems with the board itself are reported as ParseErrors,
+// with lines and columns counting tile rows and columns from one.
+func DecodeBoard(
+	r io.Reader,
+	format BoardFormat,
+	opts ParseOptions,
+) (Board, error) {
+	switch format {
+	case FormatText:
+		return ParseBoardOptions(r, opts)
+	case FormatJSON:
+		board, _, err := DecodeBoardJSON(r)
+		return board, err
+	case FormatPNG:
+		return DecodePNG(r)
+	case FormatBitmask:
+		return DecodeBitmask(r)
+	default:
+		return Board{}, fmt.Errorf("Unknown board format: %v", format)
+	}
+}
+
+// EncodeBoard writes the board in any format. Only JSON has room for
+// metadata, which the other formats ignore. Boards that aren't a grid of
+// cells can't be written as bitmasks (see EncodeBitmask).
+func EncodeBoard(
+	w io.Writer,
+	b Board,
+	format BoardFormat,
+	metadata map[string]string,
+) error {
+	switch format {
+	case FormatText:
+		_, err := io.WriteString(w, b.Text())
+		return err
+	case FormatJSON:
+		return EncodeBoardJSON(w, b, metadata)
+	case FormatPNG:
+		return EncodePNG(w, b)
+	case FormatBitmask:
+		return EncodeBitmask(w, b)
+	default:
+		return fmt.Errorf("Unknown board format: %v", format)
+	}
+}
+
+// parseRows checks tiles that were decoded from another format by parsing
+// them as text, which also picks out the start, end and items.
+func parseRows(rows [][]rune) (Board, error) {
+	return ParseBoard(strings.NewReader(windowToString(rows)))
+}
+
+// BoardJSON is a board in JSON. Tiles holds a string per row, of walls (`#`)
+// and open tiles (spaces) only; the start, the end and the items are listed
+// separately, by position.
+type BoardJSON struct {
+	Width    int               `json:"width"`
+	Height   int               `json:"height"`
+	Tiles    []string          `json:"tiles"`
+	Start    Point             `json:"start"`
+	End      Point             `json:"end"`
+	Items    []PlacedItem      `json:"items,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// PlacedItem is an item on a board in JSON. Item is the character the item
+// is drawn with (see Item); Kind names it for readers' benefit and is
+// ignored when a board is read.
+type PlacedItem struct {
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	Item string `json:"item"`
+	Kind string `json:"kind,omitempty"`
+}
+
+// EncodeBoardJSON writes the board as BoardJSON, items in reading order.
+func EncodeBoardJSON(
+	w io.Writer,
+	b Board,
+	metadata map[string]string,
+) error {
+	out := BoardJSON{
+		Width:    b.Width(),
+		Height:   b.Height(),
+		Tiles:    make([]string, b.Height()),
+		Start:    b.Start,
+		End:      b.End,
+		Metadata: metadata,
+	}
+	for y, row := range b.Rows {
+		tiles := make([]rune, len(row))
+		for x, tile := range row {
+			tiles[x] = tileSpace
+			if tile == tileWall {
+				tiles[x] = tileWall
+			}
+			p := Point{X: x, Y: y}
+			if item, found := b.Items[p]; found {
+				out.Items = append(out.Items, PlacedItem{
+					X:    x,
+					Y:    y,
+					Item: string(item),
+					Kind: item.String(),
+				})
+			}
+		}
+		out.Tiles[y] = string(tiles)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// DecodeBoardJSON reads a board written as BoardJSON, returning its metadata
+// along with it.
+func DecodeBoardJSON(r io.Reader) (Board, map[string]string, error) {
+	var in BoardJSON
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return Board{}, nil, err
+	}
+	if in.Width < 1 || in.Height < 1 {
+		return Board{}, nil, ParseErrors{{
+			Kind:    ParseErrorEmpty,
+			Message: "Board is empty",
+		}}
+	}
+	if len(in.Tiles) != in.Height {
+		return Board{}, nil, fmt.Errorf(
+			"Wanted %d rows of tiles; got %d",
+			in.Height,
+			len(in.Tiles),
+		)
+	}
+
+	var errs ParseErrors
+	rows := make([][]rune, in.Height)
+	for y, tiles := range in.Tiles {
+		rows[y] = []rune(tiles)
+		if len(rows[y]) != in.Width {
+			errs = append(errs, ParseError{
+				Kind:   ParseErrorRowLength,
+				Line:   y + 1,
+				Column: minInt(len(rows[y]), in.Width) + 1,
+				Message: fmt.Sprintf(
+					"Wanted row length %d; got %d",
+					in.Width,
+					len(rows[y]),
+				),
+			})
+			continue
+		}
+		for x, tile := range rows[y] {
+			if tile != tileWall && tile != tileSpace {
+				errs = append(errs, ParseError{
+					Kind:    ParseErrorIllegalTile,
+					Line:    y + 1,
+					Column:  x + 1,
+					Message: fmt.Sprintf("Illegal tile %q", tile),
+				})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return Board{}, nil, errs
+	}
+
+	place := func(p Point, tile rune) error {
+		if p.X < 0 || p.Y < 0 || p.X >= in.Width || p.Y >= in.Height {
+			return fmt.Errorf("%s at (%d, %d) is off the board",
+				describeTile(tile),
+				p.X,
+				p.Y,
+			)
+		}
+		if rows[p.Y][p.X] != tileSpace {
+			return fmt.Errorf("%s at (%d, %d) isn't on an open tile",
+				describeTile(tile),
+				p.X,
+				p.Y,
+			)
+		}
+		rows[p.Y][p.X] = tile
+		return nil
+	}
+	if err := place(in.Start, 'S'); err != nil {
+		return Board{}, nil, err
+	}
+	if err := place(in.End, 'E'); err != nil {
+		return Board{}, nil, err
+	}
+	for _, item := range in.Items {
+		tile, size := utf8.DecodeRuneInString(item.Item)
+		if size != len(item.Item) || !isItem(tile) {
+			return Board{}, nil, fmt.Errorf("Unknown item %q", item.Item)
+		}
+		if err := place(Point{X: item.X, Y: item.Y}, tile); err != nil {
+			return Board{}, nil, err
+		}
+	}
+	board, err := parseRows(rows)
+	return board, in.Metadata, err
+}
+
+// describeTile names the start, the end or an item for errors.
+func describeTile(tile rune) string {
+	switch tile {
+	case 'S':
+		return "Start"
+	case 'E':
+		return "End"
+	default:
+		return fmt.Sprintf("Item '%c'", tile)
+	}
+}
+
+// maxImageTiles bounds the boards DecodePNG will read, so a small but highly
+// compressed image can't claim a huge board.
+const maxImageTiles = 1024 * 1024
+
+// tileColors are the pixel colours of each tile in a PNG board. Teleporters
+// are shades of blue, one per digit (see teleporterColor).
+var tileColors = map[rune]color.NRGBA{
+	tileWall:         {0x00, 0x00, 0x00, 0xff},
+	tileSpace:        {0xff, 0xff, 0xff, 0xff},
+	'S':              {0x00, 0xff, 0x00, 0xff},
+	'E':              {0xff, 0x00, 0x00, 0xff},
+	rune(ItemKey):    {0xff, 0xff, 0x00, 0xff},
+	rune(ItemDoor):   {0x80, 0x40, 0x00, 0xff},
+	rune(ItemSpeed):  {0x00, 0xff, 0xff, 0xff},
+	rune(ItemPhase):  {0xff, 0x00, 0xff, 0xff},
+	rune(ItemReveal): {0x80, 0x80, 0x80, 0xff},
+}
+
+func init() {
+	for item := itemTeleporterFirst; item <= itemTeleporterLast; item++ {
+		tileColors[rune(item)] = teleporterColor(item)
+	}
+}
+
+func teleporterColor(item Item) color.NRGBA {
+	return color.NRGBA{0x00, 0x00, uint8(0x80 + 8*(item-'0')), 0xff}
+}
+
+// EncodePNG draws the board one pixel per tile.
+func EncodePNG(w io.Writer, b Board) error {
+	img := image.NewNRGBA(image.Rect(0, 0, b.Width(), b.Height()))
+	for y, row := range b.Rows {
+		for x, tile := range row {
+			if item, found := b.Items[Point{X: x, Y: y}]; found {
+				tile = rune(item)
+			}
+			img.SetNRGBA(x, y, tileColors[tile])
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// DecodePNG reads a board drawn one pixel per tile. Transparency is ignored;
+// any colour that isn't a tile's is an error.
+func DecodePNG(r io.Reader) (Board, error) {
+	var buf bytes.Buffer
+	config, err := png.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		return Board{}, err
+	}
+	if config.Width*config.Height > maxImageTiles {
+		return Board{}, fmt.Errorf(
+			"Image too large: %dx%d",
+			config.Width,
+			config.Height,
+		)
+	}
+	img, err := png.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return Board{}, err
+	}
+
+	tiles := map[color.NRGBA]rune{}
+	for tile, c := range tileColors {
+		tiles[c] = tile
+	}
+	p := parser{firstLine: 1}
+	bounds := img.Bounds()
+	rows := make([][]rune, bounds.Dy())
+	for y := range rows {
+		rows[y] = make([]rune, bounds.Dx())
+		for x := range rows[y] {
+			c := color.NRGBAModel.Convert(
+				img.At(bounds.Min.X+x, bounds.Min.Y+y),
+			).(color.NRGBA)
+			c.A = 0xff
+			tile, found := tiles[c]
+			if !found {
+				p.errorf(
+					ParseErrorIllegalTile,
+					Point{X: x, Y: y},
+					"Unknown colour #%02x%02x%02x",
+					c.R,
+					c.G,
+					c.B,
+				)
+				tile = tileWall
+			}
+			rows[y][x] = tile
+		}
+	}
+	if len(p.errors) > 0 {
+		return Board{}, p.errors
+	}
+	return parseRows(rows)
+}
+
+// Walls around a cell in the bitmask format
+const (
+	wallUp = 1 << iota
+	wallRight
+	wallDown
+	wallLeft
+)
+
+// ErrNotCellBoard is returned when writing a board as a bitmask that isn't
+// laid out as a grid of cells.
+var ErrNotCellBoard = errors.New("Board isn't a grid of cells: wanted odd " +
+	"dimensions, open tiles at odd positions and walls at even ones")
+
+// EncodeBitmask writes a board that's laid out as a grid of cells, like the
+// generated ones (see Generator), with a hex digit per cell giving the walls
+// around it: 1 above, 2 to the right, 4 below and 8 to the left. A w x h cell
+// board is written as
+//
+//	bitmask <w> <h>
+//	start <x> <y>
+//	end <x> <y>
+//	item <char> <x> <y>    (one line per item)
+//	<h lines of w hex digits>
+//
+// where positions are in tiles. A gap in the outer wall is open, which is how
+// the start and end of a generated board are reached.
+func EncodeBitmask(w io.Writer, b Board) error {
+	if !b.cellBoard() {
+		return ErrNotCellBoard
+	}
+	cw, ch := b.Width()/2, b.Height()/2
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(buf, "bitmask %d %d\n", cw, ch)
+	fmt.Fprintf(buf, "start %d %d\n", b.Start.X, b.Start.Y)
+	fmt.Fprintf(buf, "end %d %d\n", b.End.X, b.End.Y)
+	for y, row := range b.Rows {
+		for x := range row {
+			if item, found := b.Items[Point{X: x, Y: y}]; found {
+				fmt.Fprintf(buf, "item %c %d %d\n", rune(item), x, y)
+			}
+		}
+	}
+	for cy := 0; cy < ch; cy++ {
+		for cx := 0; cx < cw; cx++ {
+			cell := Point{X: 2*cx + 1, Y: 2*cy + 1}
+			walls := 0
+			for _, side := range cellSides {
+				if !b.IsPath(cell.Translate(side.dir)) {
+					walls |= side.wall
+				}
+			}
+			buf.WriteString(strconv.FormatInt(int64(walls), 16))
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Flush()
+}
+
+var cellSides = []struct {
+	dir  Dir
+	wall int
+}{
+	{Up, wallUp},
+	{Right, wallRight},
+	{Down, wallDown},
+	{Left, wallLeft},
+}
+
+// cellBoard reports whether the board is a grid of cells: the tiles at odd
+// positions are open and the ones at even positions, between walls, are
+// walls.
+func (b *Board) cellBoard() bool {
+	if b.Width()%2 != 1 || b.Height()%2 != 1 {
+		return false
+	}
+	for y, row := range b.Rows {
+		for x, tile := range row {
+			switch {
+			case x%2 == 1 && y%2 == 1 && tile == tileWall:
+				return false
+			case x%2 == 0 && y%2 == 0 && tile != tileWall:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DecodeBitmask reads a board written by EncodeBitmask. Neighbouring cells
+// must agree about the wall between them. Boards are at most maxMazeSize
+// cells a side.
+func DecodeBitmask(r io.Reader) (Board, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Board{}, err
+	}
+
+	var cw, ch int
+	if len(lines) < 1 {
+		return Board{}, errors.New("Missing `bitmask <w> <h>` header")
+	}
+	// The sides are checked separately so huge ones can't overflow into a
+	// small area.
+	if _, err := fmt.Sscanf(lines[0], "bitmask %d %d", &cw, &ch); err != nil ||
+		cw < 1 || ch < 1 || cw > maxMazeSize || ch > maxMazeSize {
+		return Board{}, fmt.Errorf("Invalid header: %s", lines[0])
+	}
+	lines = lines[1:]
+
+	type placed struct {
+		tile rune
+		at   Point
+	}
+	var tiles []placed
+	for len(lines) > 0 && !isHex(lines[0]) {
+		var p placed
+		var err error
+		switch fields := strings.Fields(lines[0]); {
+		case fields[0] == "start":
+			p.tile = 'S'
+			_, err = fmt.Sscanf(lines[0], "start %d %d", &p.at.X, &p.at.Y)
+		case fields[0] == "end":
+			p.tile = 'E'
+			_, err = fmt.Sscanf(lines[0], "end %d %d", &p.at.X, &p.at.Y)
+		case fields[0] == "item" && len(fields) == 4:
+			p.tile, _ = utf8.DecodeRuneInString(fields[1])
+			if !isItem(p.tile) || utf8.RuneCountInString(fields[1]) != 1 {
+				return Board{}, fmt.Errorf("Unknown item %q", fields[1])
+			}
+			_, err = fmt.Sscanf(
+				fields[2]+" "+fields[3],
+				"%d %d",
+				&p.at.X,
+				&p.at.Y,
+			)
+		default:
+			err = errors.New("unknown line")
+		}
+		if err != nil {
+			return Board{}, fmt.Errorf("Invalid line: %s", lines[0])
+		}
+		tiles = append(tiles, p)
+		lines = lines[1:]
+	}
+	if len(lines) != ch {
+		return Board{}, fmt.Errorf(
+			"Wanted %d rows of cells; got %d",
+			ch,
+			len(lines),
+		)
+	}
+	for cy, line := range lines {
+		if len(line) != cw {
+			return Board{}, fmt.Errorf(
+				"Wanted %d cells in row %d; got %d",
+				cw,
+				cy+1,
+				len(line),
+			)
+		}
+	}
+
+	rows := make([][]rune, 2*ch+1)
+	for y := range rows {
+		rows[y] = make([]rune, 2*cw+1)
+		for x := range rows[y] {
+			rows[y][x] = tileWall
+		}
+	}
+	// Every cell opens the sides it has no wall on, so a cell that has a
+	// wall where the tile is open disagrees with its neighbour.
+	cells := make([][]int, ch)
+	for cy, line := range lines {
+		cells[cy] = make([]int, cw)
+		for cx, digit := range line {
+			value, _ := strconv.ParseUint(string(digit), 16, 4)
+			walls := int(value)
+			cells[cy][cx] = walls
+			cell := Point{X: 2*cx + 1, Y: 2*cy + 1}
+			rows[cell.Y][cell.X] = tileSpace
+			for _, side := range cellSides {
+				if walls&side.wall == 0 {
+					p := cell.Translate(side.dir)
+					rows[p.Y][p.X] = tileSpace
+				}
+			}
+		}
+	}
+	for cy := range cells {
+		for cx, walls := range cells[cy] {
+			cell := Point{X: 2*cx + 1, Y: 2*cy + 1}
+			for _, side := range cellSides {
+				p := cell.Translate(side.dir)
+				if walls&side.wall != 0 && rows[p.Y][p.X] == tileSpace {
+					return Board{}, fmt.Errorf(
+						"Cells disagree about the wall at (%d, %d)",
+						p.X,
+						p.Y,
+					)
+				}
+			}
+		}
+	}
+
+	for _, p := range tiles {
+		if p.at.X < 0 || p.at.Y < 0 ||
+			p.at.X >= 2*cw+1 || p.at.Y >= 2*ch+1 ||
+			rows[p.at.Y][p.at.X] != tileSpace {
+			return Board{}, fmt.Errorf(
+				"%s at (%d, %d) isn't on an open tile",
+				describeTile(p.tile),
+				p.at.X,
+				p.at.Y,
+			)
+		}
+		rows[p.at.Y][p.at.X] = p.tile
+	}
+	return parseRows(rows)
+}
+
+// isHex reports whether `s` is all hex digits.
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
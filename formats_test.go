@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// cellBoard is a 2x1 cell board with a key in the first cell.
+const cellBoard = `#####
+Sk  E
+#####
+`
+
+func TestBoardFormatRoundTrip(t *testing.T) {
+	board, err := ParseBoard(strings.NewReader(cellBoard))
+	if err != nil {
+		t.Fatalf("Invalid test board: %v", err)
+	}
+	for format := range boardFormatNames {
+		t.Run(format.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeBoard(&buf, board, format, nil); err != nil {
+				t.Fatalf("Unexpected error encoding: %v", err)
+			}
+			decoded, err := DecodeBoard(&buf, format, ParseOptions{})
+			if err != nil {
+				t.Fatalf("Unexpected error decoding: %v", err)
+			}
+			if decoded.Text() != cellBoard {
+				t.Fatalf("Wanted:\n%s\nGot:\n%s", cellBoard, decoded.Text())
+			}
+		})
+	}
+}
+
+func TestEncodeBitmask(t *testing.T) {
+	board, err := ParseBoard(strings.NewReader(cellBoard))
+	if err != nil {
+		t.Fatalf("Invalid test board: %v", err)
+	}
+	want := "bitmask 2 1\nstart 0 1\nend 4 1\nitem k 1 1\n55\n"
+	var buf bytes.Buffer
+	if err := EncodeBitmask(&buf, board); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if buf.String() != want {
+		t.Fatalf("Wanted:\n%s\nGot:\n%s", want, buf.String())
+	}
+
+	corridor, err := ParseBoard(strings.NewReader("####\nS  E\n####\n"))
+	if err != nil {
+		t.Fatalf("Invalid test board: %v", err)
+	}
+	if err := EncodeBitmask(&buf, corridor); err != ErrNotCellBoard {
+		t.Fatalf("Wanted ErrNotCellBoard; got %v", err)
+	}
+}
+
+// testPNG draws a PNG whose pixels are the given colours, row by row.
+func testPNG(t *testing.T, pixels [][]color.NRGBA) string {
+	img := image.NewNRGBA(image.Rect(0, 0, len(pixels[0]), len(pixels)))
+	for y, row := range pixels {
+		for x, c := range row {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDecodeBoardErrors(t *testing.T) {
+	wall, space := tileColors[tileWall], tileColors[tileSpace]
+	start, end := tileColors['S'], tileColors['E']
+	for _, testCase := range []struct {
+		name    string
+		format  BoardFormat
+		input   string
+		wantErr string
+	}{{
+		name:    "json-syntax",
+		format:  FormatJSON,
+		input:   `{"width": 5,`,
+		wantErr: "unexpected EOF",
+	}, {
+		name:    "json-empty",
+		format:  FormatJSON,
+		input:   `{"width": 0, "height": 0}`,
+		wantErr: "Board is empty",
+	}, {
+		name:    "json-missing-rows",
+		format:  FormatJSON,
+		input:   `{"width": 5, "height": 3, "tiles": ["#####"]}`,
+		wantErr: "Wanted 3 rows of tiles; got 1",
+	}, {
+		name:   "json-row-length",
+		format: FormatJSON,
+		input: `{"width": 5, "height": 3,
+			"tiles": ["#####", "   ", "#####"]}`,
+		wantErr: "Wanted row length 5; got 3",
+	}, {
+		name:   "json-illegal-tile",
+		format: FormatJSON,
+		input: `{"width": 5, "height": 3,
+			"tiles": ["#####", "  S  ", "#####"]}`,
+		wantErr: "Illegal tile 'S'",
+	}, {
+		name:   "json-start-off-board",
+		format: FormatJSON,
+		input: `{"width": 5, "height": 3,
+			"tiles": ["#####", "     ", "#####"],
+			"start": {"x": 5, "y": 1}, "end": {"x": 4, "y": 1}}`,
+		wantErr: "Start at (5, 1) is off the board",
+	}, {
+		name:   "json-end-on-wall",
+		format: FormatJSON,
+		input: `{"width": 5, "height": 3,
+			"tiles": ["#####", "     ", "#####"],
+			"start": {"x": 0, "y": 1}, "end": {"x": 4, "y": 0}}`,
+		wantErr: "End at (4, 0) isn't on an open tile",
+	}, {
+		name:   "json-unknown-item",
+		format: FormatJSON,
+		input: `{"width": 5, "height": 3,
+			"tiles": ["#####", "     ", "#####"],
+			"start": {"x": 0, "y": 1}, "end": {"x": 4, "y": 1},
+			"items": [{"x": 2, "y": 1, "item": "x"}]}`,
+		wantErr: `Unknown item "x"`,
+	}, {
+		name:    "bitmask-empty",
+		format:  FormatBitmask,
+		input:   "\n\n",
+		wantErr: "Missing `bitmask <w> <h>` header",
+	}, {
+		name:    "bitmask-header",
+		format:  FormatBitmask,
+		input:   "bitmask two 1\n55\n",
+		wantErr: "Invalid header: bitmask two 1",
+	}, {
+		name:    "bitmask-no-cells",
+		format:  FormatBitmask,
+		input:   "bitmask 0 1\n\n",
+		wantErr: "Invalid header: bitmask 0 1",
+	}, {
+		name:    "bitmask-too-wide",
+		format:  FormatBitmask,
+		input:   "bitmask 202 1\n" + strings.Repeat("5", 202) + "\n",
+		wantErr: "Invalid header: bitmask 202 1",
+	}, {
+		// The area overflows to 0, which mustn't let the sides through.
+		name:    "bitmask-overflow",
+		format:  FormatBitmask,
+		input:   "bitmask 4611686018427387904 4\n5\n5\n5\n5\n",
+		wantErr: "Invalid header: bitmask 4611686018427387904 4",
+	}, {
+		name:    "bitmask-unknown-line",
+		format:  FormatBitmask,
+		input:   "bitmask 2 1\nexit 4 1\n55\n",
+		wantErr: "Invalid line: exit 4 1",
+	}, {
+		name:    "bitmask-unknown-item",
+		format:  FormatBitmask,
+		input:   "bitmask 2 1\nitem x 1 1\n55\n",
+		wantErr: `Unknown item "x"`,
+	}, {
+		name:    "bitmask-missing-row",
+		format:  FormatBitmask,
+		input:   "bitmask 2 2\nstart 0 1\nend 4 1\n55\n",
+		wantErr: "Wanted 2 rows of cells; got 1",
+	}, {
+		name:    "bitmask-short-row",
+		format:  FormatBitmask,
+		input:   "bitmask 2 1\nstart 0 1\nend 4 1\n5\n",
+		wantErr: "Wanted 2 cells in row 1; got 1",
+	}, {
+		name:    "bitmask-long-row",
+		format:  FormatBitmask,
+		input:   "bitmask 2 2\nstart 0 1\nend 4 1\n55\n555\n",
+		wantErr: "Wanted 2 cells in row 2; got 3",
+	}, {
+		name:    "bitmask-disagreement",
+		format:  FormatBitmask,
+		input:   "bitmask 2 1\nstart 0 1\nend 4 1\ndd\n",
+		wantErr: "Cells disagree about the wall at (2, 1)",
+	}, {
+		name:    "bitmask-start-on-wall",
+		format:  FormatBitmask,
+		input:   "bitmask 2 1\nstart 0 0\nend 4 1\n55\n",
+		wantErr: "Start at (0, 0) isn't on an open tile",
+	}, {
+		name:    "png-garbage",
+		format:  FormatPNG,
+		input:   "#####\nS   E\n#####\n",
+		wantErr: "png: invalid format",
+	}, {
+		name:   "png-unknown-colour",
+		format: FormatPNG,
+		input: testPNG(t, [][]color.NRGBA{
+			{wall, wall, wall},
+			{start, {0x12, 0x34, 0x56, 0xff}, end},
+			{wall, space, wall},
+		}),
+		wantErr: "Unknown colour #123456",
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := DecodeBoard(
+				strings.NewReader(testCase.input),
+				testCase.format,
+				ParseOptions{},
+			)
+			if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+				t.Fatalf("Wanted error %q; got %v", testCase.wantErr, err)
+			}
+		})
+	}
+}
@@ -2,16 +2,110 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"time"
 )
 
+const ghostToken = '*'
+
+// Move is a single successful step taken by a player, recorded so runs can be
+// replayed.
+type Move struct {
+	Player  rune          `json:"player"`
+	Dir     Dir           `json:"dir"`
+	To      Point         `json:"to"`
+	Elapsed time.Duration `json:"elapsed"`
+	Tick    int           `json:"tick,omitempty"` // tick-based games only
+	Pushed  bool          `json:"pushed,omitempty"`
+}
+
+const (
+	conflictBlocked   = "blocked"
+	conflictPushed    = "pushed"
+	conflictContested = "contested"
+)
+
+// Conflict records a move running into another player. `At` is the tile the
+// player was blocked from, or the tile the other player was pushed to.
+type Conflict struct {
+	Kind   string `json:"kind"`
+	Player rune   `json:"player"`
+	Other  rune   `json:"other"`
+	At     Point  `json:"at"`
+	Tick   int    `json:"tick,omitempty"`
+}
+
 type Game struct {
+	Seed        int64
 	Board       Board
 	Players     []Player
 	WindowSize  Point
 	Start       time.Time
 	Winner      rune // non-zero value indicates the game has been won
 	SolvedTimes map[rune]time.Duration
+	History     []Move
+	Ghost       []Move // a previous run to race against; may be empty
+
+	// TickInterval is non-zero for tick-based games, which only advance in
+	// Steps; Tick counts the Steps taken so far. Time in a tick-based game is
+	// measured in ticks, so replaying the same moves gives the same times.
+	TickInterval time.Duration
+	Tick         int
+
+	// Collisions decides what happens when players run into each other;
+	// Conflicts lists the collisions caused by the latest move or tick.
+	Collisions CollisionRule
+	Conflicts  []Conflict
+
+	// Items are the items still lying on the board; they start out as the
+	// board's items and disappear as they are picked up (or, for doors,
+	// unlocked).
+	Items map[Point]Item
+
+	// Objective decides who has finished and who has won. Goals are the
+	// tiles it cares about, such as checkpoints, coins or the hill, and
+	// Checked is how far into the game it was last updated.
+	Objective ObjectiveRule
+	Goals     []Point
+	Checked   time.Duration
+
+	// ShiftInterval is non-zero for shifting mazes, whose walls change
+	// every interval and whenever a player finishes. Shifts records every
+	// change and Changes is the latest one, until the next move or tick.
+	ShiftInterval time.Duration
+	Shifts        []Shift
+	Changes       []TileChange
+
+	// In team games (see Player.Team) Scoring decides which team wins;
+	// WinningTeam is non-zero once it's known.
+	Scoring     TeamScoring
+	WinningTeam int
+
+	// The game is Over once its TimeLimit is up, Grace has passed since the
+	// first player finished, or nobody is left playing. Either limit may be
+	// zero.
+	TimeLimit time.Duration
+	Grace     time.Duration
+	Over      bool
+}
+
+// Elapsed returns how long the game has been running.
+func (g Game) Elapsed() time.Duration {
+	if g.TickInterval > 0 {
+		return time.Duration(g.Tick) * g.TickInterval
+	}
+	return time.Since(g.Start)
+}
+
+func (g Game) objective() Objective {
+	return g.Objective.Objective()
+}
+
+// active reports whether the player is still playing, i.e. they have neither
+// finished nor been tagged out.
+func (g Game) active(p Player) bool {
+	_, solved := g.SolvedTimes[p.ID]
+	return !solved && !p.Out
 }
 
 func (g Game) InitPlayer(pid rune) Player {
@@ -24,17 +118,44 @@ func (g Game) SetPlayers(players []Player) Game {
 }
 
 func (g Game) PlayerWindow(pid rune) string {
+	window, _ := g.PlayerTiles(pid)
+	return windowToString(window)
+}
+
+// PlayerTiles returns the tiles of the player's window along with the board
+// position of its top-left tile.
+func (g Game) PlayerTiles(pid rune) ([][]rune, Point) {
 	for _, p := range g.Players {
 		if p.ID == pid {
 			rect := RectFromCenterAndSize(
 				p.Pos,
 				g.WindowSize,
 			)
+			if p.Effects.Reveal > p.Moves {
+				rect = Rect{
+					TopLeft:     Point{g.Board.Left(), g.Board.Top()},
+					BottomRight: Point{g.Board.Right(), g.Board.Bottom()},
+				}
+			}
 			windowRect := g.Board.WindowRect(rect)
 
 			// get copy of window for the player
 			window := windowCopy(g.Board.Slice(windowRect))
 
+			// objective marks and items sit beneath everything else
+			for pos, mark := range g.objective().Marks(g, pid) {
+				if windowRect.Contains(pos) {
+					relPos := pos.Rel(windowRect.TopLeft)
+					window[relPos.Y][relPos.X] = mark
+				}
+			}
+			for pos, item := range g.Items {
+				if windowRect.Contains(pos) {
+					relPos := pos.Rel(windowRect.TopLeft)
+					window[relPos.Y][relPos.X] = rune(item)
+				}
+			}
+
 			// add in all players
 			for _, player := range g.Players {
 				if windowRect.Contains(player.Pos) {
@@ -43,15 +164,122 @@ func (g Game) PlayerWindow(pid rune) string {
 				}
 			}
 
+			// the ghost sits beneath every real player
+			if ghost, ok := g.GhostPos(g.Elapsed()); ok &&
+				windowRect.Contains(ghost) {
+				relPos := ghost.Rel(windowRect.TopLeft)
+				if window[relPos.Y][relPos.X] == tileSpace {
+					window[relPos.Y][relPos.X] = ghostToken
+				}
+			}
+
 			// make sure the requested player is on top
 			relPos := p.Pos.Rel(windowRect.TopLeft)
 			window[relPos.Y][relPos.X] = p.ID
-			return windowToString(window)
+			return window, windowRect.TopLeft
 		}
 	}
 	panic(fmt.Sprintf("Player not found: %s", string(pid)))
 }
 
+// GhostPos returns where the ghost was `elapsed` into its run. The `bool` is
+// false if there is no ghost.
+func (g Game) GhostPos(elapsed time.Duration) (Point, bool) {
+	if len(g.Ghost) < 1 {
+		return Point{}, false
+	}
+	pos := g.Board.Start
+	for _, move := range g.Ghost {
+		if move.Elapsed > elapsed {
+			break
+		}
+		pos = move.To
+	}
+	return pos, true
+}
+
+// PlayerPath returns the moves made by the given player.
+func (g Game) PlayerPath(pid rune) []Move {
+	var path []Move
+	for _, move := range g.History {
+		if move.Player == pid {
+			path = append(path, move)
+		}
+	}
+	return path
+}
+
+func (g Game) recordMove(move Move) Game {
+	history := make([]Move, len(g.History), len(g.History)+1)
+	copy(history, g.History)
+	move.Elapsed = g.Elapsed()
+	move.Tick = g.Tick
+	g.History = append(history, move)
+	return g
+}
+
+func (g Game) recordConflict(kind string, pid, other rune, at Point) Game {
+	conflicts := make([]Conflict, len(g.Conflicts), len(g.Conflicts)+1)
+	copy(conflicts, g.Conflicts)
+	g.Conflicts = append(conflicts, Conflict{
+		Kind:   kind,
+		Player: pid,
+		Other:  other,
+		At:     at,
+		Tick:   g.Tick,
+	})
+	return g
+}
+
+// occupant returns the player (other than `pid`) standing on `p`. Players who
+// have finished or been tagged out are off the board, so they never get in the
+// way.
+func (g Game) occupant(p Point, pid rune) (rune, bool) {
+	for _, player := range g.Players {
+		if !g.active(player) {
+			continue
+		}
+		if player.ID != pid && player.Pos == p {
+			return player.ID, true
+		}
+	}
+	return 0, false
+}
+
+// canPushTo reports whether a player can be pushed onto `p`. Nobody can be
+// pushed onto the end tile, since that would finish the board for them, or
+// into a locked door.
+func (g Game) canPushTo(p Point) bool {
+	_, occupied := g.occupant(p, 0)
+	return g.Board.IsPath(p) &&
+		p != g.Board.End &&
+		g.Items[p] != ItemDoor &&
+		!occupied
+}
+
+// takeItem removes the item at `p` from the board.
+func (g Game) takeItem(p Point) Game {
+	items := make(map[Point]Item, len(g.Items))
+	for q, item := range g.Items {
+		if q != p {
+			items[q] = item
+		}
+	}
+	g.Items = items
+	return g
+}
+
+// Player returns the player with the given ID. The `bool` is false if there is
+// no such player.
+func (g Game) Player(pid rune) (Player, bool) {
+	for _, p := range g.Players {
+		if p.ID == pid {
+			return p, true
+		}
+	}
+	return Player{}, false
+}
+
 func (g Game) MapPlayer(pid rune, f func(p Player) Player) Game {
 	players := make([]Player, len(g.Players))
 	var found bool
@@ -87,35 +315,218 @@ func (g Game) recordFinishTime(pid rune) Game {
 	for pid, duration := range g.SolvedTimes {
 		times[pid] = duration
 	}
-	times[pid] = time.Since(g.Start)
+	times[pid] = g.Elapsed()
 	return g.SetSolvedTimes(times)
 }
 
+// playerFinished records that the player has met the objective; players who
+// have already finished (e.g. stepping back onto the end) don't finish again.
 func (g Game) playerFinished(pid rune) Game {
+	if _, found := g.SolvedTimes[pid]; found {
+		return g
+	}
 	g = g.recordFinishTime(pid)
-	if g.Winner == 0 {
+	if g.teamGame() {
+		g = g.decideTeams()
+	} else if g.Winner == 0 {
 		g = g.SetWinner(pid)
 	}
+	if g.ShiftInterval > 0 {
+		g = g.Shift()
+	}
 	return g
 }
 
+// PlayerMove moves the player one tile (two with a speed boost), unless the
+// way is blocked by a wall, a locked door or (depending on the collision rule)
+// another player. However far the player goes, it counts as a single move.
+// Players who have been tagged out can't move at all, and nobody can once the
+// game is over.
 func (g Game) PlayerMove(pid rune, dir Dir) Game {
-	players := make([]Player, len(g.Players))
-	copy(players, g.Players)
-	for i, p := range players {
-		if p.ID == pid {
-			if proposed := p.Pos.Translate(dir); g.Board.IsPath(proposed) {
-				players[i].Pos = proposed
-				game := g.SetPlayers(players)
-				if proposed == g.Board.End {
-					game = game.playerFinished(pid)
-				}
-				return game
+	p, found := g.Player(pid)
+	if !found {
+		panic(fmt.Sprintf("Player not found: %s", string(pid)))
+	}
+	if p.Out || g.Over {
+		return g
+	}
+	steps := 1
+	if p.Effects.Speed > p.Moves {
+		steps = 2
+	}
+
+	moved := false
+	for i := 0; i < steps; i++ {
+		var ok bool
+		if g, ok = g.step(pid, dir); !ok {
+			break
+		}
+		moved = true
+		if p, _ := g.Player(pid); p.Pos == g.Board.End {
+			break
+		}
+	}
+	if !moved {
+		return g
+	}
+
+	g = g.MapPlayer(pid, func(p Player) Player {
+		p.Moves++
+		return p
+	})
+	p, _ = g.Player(pid)
+	g = g.recordMove(Move{Player: pid, Dir: dir, To: p.Pos})
+	return g.objective().Moved(g, pid)
+}
+
+// step moves the player a single tile (or over a single wall while phasing)
+// and picks up whatever is there. The `bool` is false if the player couldn't
+// move, in which case the only change to the game is any conflict recorded.
+func (g Game) step(pid rune, dir Dir) (Game, bool) {
+	p, _ := g.Player(pid)
+	proposed := p.Pos.Translate(dir)
+	if !g.Board.IsPath(proposed) {
+		if p.Effects.Phase <= p.Moves {
+			return g, false
+		}
+		if proposed = proposed.Translate(dir); !g.Board.IsPath(proposed) {
+			return g, false
+		}
+	}
+
+	if other, occupied := g.occupant(proposed, pid); occupied {
+		switch g.Collisions {
+		case CollisionBlock:
+			g = g.recordConflict(conflictBlocked, pid, other, proposed)
+			return g, false
+		case CollisionPush:
+			pushed := proposed.Translate(dir)
+			if !g.canPushTo(pushed) {
+				g = g.recordConflict(conflictBlocked, pid, other, proposed)
+				return g, false
 			}
+			g = g.MapPlayer(other, func(p Player) Player {
+				p.Pos = pushed
+				return p
+			}).recordMove(Move{
+				Player: other,
+				Dir:    dir,
+				To:     pushed,
+				Pushed: true,
+			}).recordConflict(conflictPushed, pid, other, pushed)
+		}
+	}
+
+	if g.Items[proposed] == ItemDoor {
+		if p.Keys < 1 {
+			return g, false
+		}
+		g = g.takeItem(proposed).MapPlayer(pid, func(p Player) Player {
+			p.Keys--
+			return p
+		})
+	}
+
+	g = g.MapPlayer(pid, func(p Player) Player {
+		p.Pos = proposed
+		return p
+	})
+	return g.pickUp(pid, proposed), true
+}
+
+// pickUp applies the item at `at` to the player who just stepped there.
+// Effects last for effectMoves moves after the current one.
+func (g Game) pickUp(pid rune, at Point) Game {
+	item, found := g.Items[at]
+	if !found || item == ItemDoor {
+		return g
+	}
+	if item.IsTeleporter() {
+		other, ok := otherTeleporter(g.Items, at)
+		if _, occupied := g.occupant(other, pid); !ok ||
+			(occupied && g.Collisions != CollisionNone) {
 			return g
 		}
+		return g.MapPlayer(pid, func(p Player) Player {
+			p.Pos = other
+			return p
+		})
 	}
-	panic(fmt.Sprintf("Player not found: %s", string(pid)))
+	return g.takeItem(at).MapPlayer(pid, func(p Player) Player {
+		until := p.Moves + 1 + effectMoves
+		switch item {
+		case ItemKey:
+			p.Keys++
+		case ItemSpeed:
+			p.Effects.Speed = until
+		case ItemPhase:
+			p.Effects.Phase = until
+		case ItemReveal:
+			p.Effects.Reveal = until
+		}
+		return p
+	})
+}
+
+// destination returns where the player would end up moving `dir` if nobody
+// else moved first, taking speed boosts, phasing and teleporters into account.
+// Players who can't move stay where they are.
+func (g Game) destination(pid rune, dir Dir) Point {
+	p, _ := g.PlayerMove(pid, dir).Player(pid)
+	return p.Pos
+}
+
+// Step advances a tick-based game by one tick, applying at most one move per
+// player. Moves are applied in token order, so if several players reach the
+// end on the same tick, they share a solve time and the lowest token wins.
+// When collisions are on, only one of the players whose moves would end on the
+// same tile in the same tick gets it; priority rotates through them in token order from
+// tick to tick, so that no one is favored. Once the moves are made, the
+// objective is updated for the tick that has passed, and a shifting maze
+// shifts if it's due. Games that are over don't advance.
+func (g Game) Step(moves map[rune]Dir) Game {
+	if g.Over {
+		return g
+	}
+	g.Tick++
+	g.Conflicts = nil
+	g.Changes = nil
+	var pids []rune
+	for pid := range moves {
+		if _, found := g.Player(pid); found {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	contenders := map[Point][]rune{}
+	destinations := map[rune]Point{}
+	if g.Collisions != CollisionNone {
+		for _, pid := range pids {
+			p, _ := g.Player(pid)
+			to := g.destination(pid, moves[pid])
+			if to != p.Pos && to != g.Board.End {
+				contenders[to] = append(contenders[to], pid)
+				destinations[pid] = to
+			}
+		}
+	}
+
+	for _, pid := range pids {
+		to, found := destinations[pid]
+		if rivals := contenders[to]; found && len(rivals) > 1 {
+			if winner := rivals[g.Tick%len(rivals)]; winner != pid {
+				g = g.recordConflict(conflictContested, pid, winner, to)
+				continue
+			}
+		}
+		g = g.PlayerMove(pid, moves[pid])
+	}
+	g = g.objective().Update(g)
+	if g.ShiftInterval > 0 && g.Elapsed()-g.lastShift() >= g.ShiftInterval {
+		g = g.Shift()
+	}
+	return g
 }
 
 func (g Game) PlayerMoveLeft(pid rune) Game {
@@ -142,15 +553,41 @@ func (g Game) AddPlayer(pid rune) Game {
 
 func (g Game) DropPlayer(pid rune) Game {
 	players := make([]Player, 0, len(g.Players)-1)
-	found := false
+	var dropped *Player
 	for _, p := range g.Players {
-		if p.ID != pid {
-			found = true
-			players = append(players, p)
+		if p.ID == pid {
+			p := p
+			dropped = &p
+			continue
 		}
+		players = append(players, p)
 	}
-	if !found {
+	if dropped == nil {
 		panic(fmt.Sprintf("Player not found: %#v", pid))
 	}
-	return g.SetPlayers(players)
+
+	// A player leaving with keys drops them on the free tiles nearest where
+	// they stood, so nobody is shut out by a door for good.
+	if dropped.Keys > 0 {
+		items := make(map[Point]Item, len(g.Items)+dropped.Keys)
+		for p, item := range g.Items {
+			items[p] = item
+		}
+		keys := dropped.Keys
+		for _, p := range g.Board.reachable(dropped.Pos, dropped.Pos) {
+			if keys < 1 {
+				break
+			}
+			if _, taken := items[p]; !taken && p != g.Board.End {
+				items[p] = ItemKey
+				keys--
+			}
+		}
+		g.Items = items
+	}
+	g = g.SetPlayers(players)
+	if g.teamGame() {
+		g = g.decideTeams()
+	}
+	return g.objective().Update(g)
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBoard has a corridor along the top and the end in the bottom right
+// corner, which can be reached from the left and from above.
+const testBoard = `#######
+S     #
+#    E#
+#######
+`
+
+// newTestGame starts a tick-based game on `board` with players standing
+// where `positions` says.
+func newTestGame(
+	t *testing.T,
+	board string,
+	collisions CollisionRule,
+	positions map[rune]Point,
+) Game {
+	b, err := ParseBoard(strings.NewReader(board))
+	if err != nil {
+		t.Fatalf("Invalid test board: %v", err)
+	}
+	g := Game{
+		Board:        b,
+		Items:        b.Items,
+		SolvedTimes:  map[rune]time.Duration{},
+		TickInterval: 100 * time.Millisecond,
+		Collisions:   collisions,
+	}
+	for pid, pos := range positions {
+		pos := pos
+		g = g.AddPlayer(pid).MapPlayer(pid, func(p Player) Player {
+			p.Pos = pos
+			return p
+		})
+	}
+	return g
+}
+
+func TestGameStep(t *testing.T) {
+	for _, testCase := range []struct {
+		name       string
+		collisions CollisionRule
+		tick       int // ticks already taken
+		positions  map[rune]Point
+		speed      []rune // players with a speed boost
+		moves      map[rune]Dir
+		want       map[rune]Point
+		conflicts  []Conflict
+	}{{
+		name:       "pass-through",
+		collisions: CollisionNone,
+		positions:  map[rune]Point{'@': {1, 1}, '$': {3, 1}},
+		moves:      map[rune]Dir{'@': Right, '$': Left},
+		want:       map[rune]Point{'@': {2, 1}, '$': {2, 1}},
+	}, {
+		name:       "wall",
+		collisions: CollisionNone,
+		positions:  map[rune]Point{'@': {1, 1}, '$': {3, 1}},
+		moves:      map[rune]Dir{'@': Up, '$': Right},
+		want:       map[rune]Point{'@': {1, 1}, '$': {4, 1}},
+	}, {
+		name:       "no-move",
+		collisions: CollisionNone,
+		positions:  map[rune]Point{'@': {1, 1}, '$': {3, 1}},
+		moves:      map[rune]Dir{'@': Right},
+		want:       map[rune]Point{'@': {2, 1}, '$': {3, 1}},
+	}, {
+		name:       "contested-odd-tick",
+		collisions: CollisionBlock,
+		positions:  map[rune]Point{'@': {1, 1}, '$': {3, 1}},
+		moves:      map[rune]Dir{'@': Right, '$': Left},
+		want:       map[rune]Point{'@': {2, 1}, '$': {3, 1}},
+		conflicts: []Conflict{{
+			Kind:   conflictContested,
+			Player: '$',
+			Other:  '@',
+			At:     Point{2, 1},
+			Tick:   1,
+		}},
+	}, {
+		name:       "contested-even-tick",
+		collisions: CollisionBlock,
+		tick:       1,
+		positions:  map[rune]Point{'@': {1, 1}, '$': {3, 1}},
+		moves:      map[rune]Dir{'@': Right, '$': Left},
+		want:       map[rune]Point{'@': {1, 1}, '$': {2, 1}},
+		conflicts: []Conflict{{
+			Kind:   conflictContested,
+			Player: '@',
+			Other:  '$',
+			At:     Point{2, 1},
+			Tick:   2,
+		}},
+	}, {
+		name:       "contested-after-speed",
+		collisions: CollisionBlock,
+		positions:  map[rune]Point{'@': {1, 1}, '$': {3, 2}},
+		speed:      []rune{'@'},
+		moves:      map[rune]Dir{'@': Right, '$': Up},
+		want:       map[rune]Point{'@': {3, 1}, '$': {3, 2}},
+		conflicts: []Conflict{{
+			Kind:   conflictContested,
+			Player: '$',
+			Other:  '@',
+			At:     Point{3, 1},
+			Tick:   1,
+		}},
+	}, {
+		name:       "speed-past-contender",
+		collisions: CollisionBlock,
+		positions:  map[rune]Point{'@': {1, 1}, 'a': {2, 2}},
+		speed:      []rune{'@'},
+		moves:      map[rune]Dir{'@': Right, 'a': Up},
+		want:       map[rune]Point{'@': {3, 1}, 'a': {2, 1}},
+	}, {
+		name:       "blocked",
+		collisions: CollisionBlock,
+		positions:  map[rune]Point{'@': {1, 1}, '$': {2, 1}},
+		moves:      map[rune]Dir{'@': Right},
+		want:       map[rune]Point{'@': {1, 1}, '$': {2, 1}},
+		conflicts: []Conflict{{
+			Kind:   conflictBlocked,
+			Player: '@',
+			Other:  '$',
+			At:     Point{2, 1},
+			Tick:   1,
+		}},
+	}, {
+		name:       "pushed",
+		collisions: CollisionPush,
+		positions:  map[rune]Point{'@': {1, 1}, '$': {2, 1}},
+		moves:      map[rune]Dir{'@': Right},
+		want:       map[rune]Point{'@': {2, 1}, '$': {3, 1}},
+		conflicts: []Conflict{{
+			Kind:   conflictPushed,
+			Player: '@',
+			Other:  '$',
+			At:     Point{3, 1},
+			Tick:   1,
+		}},
+	}, {
+		name:       "push-blocked-by-wall",
+		collisions: CollisionPush,
+		positions:  map[rune]Point{'@': {4, 1}, '$': {5, 1}},
+		moves:      map[rune]Dir{'@': Right},
+		want:       map[rune]Point{'@': {4, 1}, '$': {5, 1}},
+		conflicts: []Conflict{{
+			Kind:   conflictBlocked,
+			Player: '@',
+			Other:  '$',
+			At:     Point{5, 1},
+			Tick:   1,
+		}},
+	}, {
+		name:       "unknown-players-ignored",
+		collisions: CollisionBlock,
+		positions:  map[rune]Point{'@': {1, 1}},
+		moves:      map[rune]Dir{'@': Right, '$': Left},
+		want:       map[rune]Point{'@': {2, 1}},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			g := newTestGame(
+				t,
+				testBoard,
+				testCase.collisions,
+				testCase.positions,
+			)
+			for _, pid := range testCase.speed {
+				g = g.MapPlayer(pid, func(p Player) Player {
+					p.Effects.Speed = p.Moves + effectMoves
+					return p
+				})
+			}
+			g.Tick = testCase.tick
+			g = g.Step(testCase.moves)
+			if g.Tick != testCase.tick+1 {
+				t.Fatalf("Wanted tick %d; got %d", testCase.tick+1, g.Tick)
+			}
+			for pid, want := range testCase.want {
+				if p, _ := g.Player(pid); p.Pos != want {
+					t.Errorf("Wanted %c at %v; got %v", pid, want, p.Pos)
+				}
+			}
+			if !reflect.DeepEqual(g.Conflicts, testCase.conflicts) {
+				t.Errorf(
+					"Wanted conflicts %#v; got %#v",
+					testCase.conflicts,
+					g.Conflicts,
+				)
+			}
+		})
+	}
+}
+
+func TestGameStepTie(t *testing.T) {
+	// Players reaching the end on the same tick share a solve time, and the
+	// lowest token ('$' comes before '@') wins. The end is never contested,
+	// even with collisions on.
+	for _, collisions := range []CollisionRule{
+		CollisionNone,
+		CollisionBlock,
+		CollisionPush,
+	} {
+		t.Run(collisions.String(), func(t *testing.T) {
+			g := newTestGame(
+				t,
+				testBoard,
+				collisions,
+				map[rune]Point{'@': {4, 2}, '$': {5, 1}},
+			)
+			g = g.Step(map[rune]Dir{'@': Right, '$': Down})
+			want := map[rune]time.Duration{
+				'@': g.TickInterval,
+				'$': g.TickInterval,
+			}
+			if !reflect.DeepEqual(g.SolvedTimes, want) {
+				t.Fatalf("Wanted solve times %v; got %v", want, g.SolvedTimes)
+			}
+			if g.Winner != '$' {
+				t.Fatalf("Wanted '$' to win; got %q", g.Winner)
+			}
+		})
+	}
+}
+
+func TestGameStepOver(t *testing.T) {
+	g := newTestGame(
+		t,
+		testBoard,
+		CollisionNone,
+		map[rune]Point{'@': {1, 1}},
+	)
+	g.Over = true
+	g = g.Step(map[rune]Dir{'@': Right})
+	if p, _ := g.Player('@'); g.Tick != 0 || p.Pos != (Point{1, 1}) {
+		t.Fatalf(
+			"Wanted the game not to advance; got tick %d at %v",
+			g.Tick,
+			p.Pos,
+		)
+	}
+}
+
+func TestDropPlayerKeys(t *testing.T) {
+	for _, testCase := range []struct {
+		name  string
+		board string
+		keys  int
+		want  map[Point]Item
+	}{{
+		name:  "no-keys",
+		board: testBoard,
+		want:  map[Point]Item{},
+	}, {
+		name:  "one-key",
+		board: testBoard,
+		keys:  1,
+		want:  map[Point]Item{{2, 1}: ItemKey},
+	}, {
+		name:  "all-keys",
+		board: testBoard,
+		keys:  3,
+		want: map[Point]Item{
+			{2, 1}: ItemKey,
+			{1, 1}: ItemKey,
+			{3, 1}: ItemKey,
+		},
+	}, {
+		name:  "around-items",
+		board: "#######\nS ~   #\n#    E#\n#######\n",
+		keys:  2,
+		want: map[Point]Item{
+			{2, 1}: ItemPhase,
+			{1, 1}: ItemKey,
+			{3, 1}: ItemKey,
+		},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			g := newTestGame(
+				t,
+				testCase.board,
+				CollisionNone,
+				map[rune]Point{'@': {2, 1}, '$': {5, 1}},
+			)
+			g = g.MapPlayer('@', func(p Player) Player {
+				p.Keys = testCase.keys
+				return p
+			}).DropPlayer('@')
+			items := map[Point]Item{}
+			for p, item := range g.Items {
+				items[p] = item
+			}
+			if !reflect.DeepEqual(items, testCase.want) {
+				t.Fatalf("Wanted items %v; got %v", testCase.want, items)
+			}
+		})
+	}
+}
@@ -10,11 +10,19 @@ var seed = time.Now().UnixNano()
 const boardWidth = 20
 const boardHeight = 10
 
-var playerTokens = []rune("@$")
+var playerTokens = []rune("@$&%")
 
 type GameManager struct {
-	Mutex   sync.RWMutex
-	Lobbies []*Lobby
+	Mutex     sync.RWMutex
+	Lobbies   []*Lobby
+	Results   *Results
+	Daily     *Daily
+	Mazes     *MazeLibrary
+	FillTimes FillTimes
+
+	// Logs is where server-side bots' logs are written, if anywhere (see
+	// NewBot)
+	Logs chan<- interface{}
 }
 
 func (gm *GameManager) State() []LobbyState {
@@ -22,37 +30,131 @@ func (gm *GameManager) State() []LobbyState {
 	defer gm.Mutex.RUnlock()
 	lobbies := make([]LobbyState, len(gm.Lobbies))
 	for i, lobby := range gm.Lobbies {
-		lobbies[i] = *lobby.lobbyState()
+		lobbies[i] = lobby.LobbyState()
 	}
 	return lobbies
 }
 
-func (gm *GameManager) Join(user *UserSession) *Lobby {
+func (gm *GameManager) rating(name string) float64 {
+	if gm.Results == nil {
+		return initialRating
+	}
+	return gm.Results.Rating(name)
+}
+
+// Join adds the user to the waiting lobby whose average rating is closest to
+// the user's, provided it's within that lobby's rating window. If no lobby is
+// close enough, a new one is created. Only lobbies with the same rules are
+// considered.
+func (gm *GameManager) Join(
+	user Participant,
+	rules Rules,
+) *Lobby {
 	gm.Mutex.Lock()
 	defer gm.Mutex.Unlock()
+
+	rating := gm.rating(user.Name())
+
+	now := time.Now()
+	var closest *Lobby
+	var closestDistance float64
 	for _, lobby := range gm.Lobbies {
-		if lobby.Add(user) {
-			return lobby
+		if lobby.Rules != rules {
+			continue
+		}
+		distance, ok := lobby.ratingDistance(rating, now)
+		if ok && (closest == nil || distance < closestDistance) {
+			closest, closestDistance = lobby, distance
 		}
 	}
-	lobby := &Lobby{MaxSize: len(playerTokens)}
+	if closest != nil && closest.Add(user, rating) {
+		return closest
+	}
+
+	lobby := NewLobby(
+		rules.lobbySize(),
+		gm.Results,
+		&gm.FillTimes,
+		rules,
+	)
+	lobby.Mazes = gm.Mazes
+	lobby.Logs = gm.Logs
 	gm.Lobbies = append(gm.Lobbies, lobby)
-	if !lobby.Add(user) {
-		// shouldn't get here unless playerTokens is empty (and thus
-		// lobby.MaxSize is zero), which shouldn't happen.
+	if !lobby.Add(user, rating) {
+		// shouldn't get here unless the lobby size is zero, which
+		// shouldn't happen.
 		panic("Couldn't add user to lobby")
 	}
 	return lobby
 }
 
-func (gm *GameManager) Drop(user *UserSession) {
+// JoinSolo starts a single-player game for the user on the board described
+// by `spec`.
+func (gm *GameManager) JoinSolo(
+	user Participant,
+	spec BoardSpec,
+	ghost bool,
+	rules Rules,
+) *Lobby {
+	gm.Mutex.Lock()
+	defer gm.Mutex.Unlock()
+	return gm.joinSolo(
+		user,
+		NewSoloLobby(spec, ghost, gm.Results, rules),
+	)
+}
+
+// JoinDaily starts a single-player game for the user on today's daily
+// challenge and counts it as an attempt. The daily challenge is always played
+// in real time so that everyone's times are comparable. Attempts are counted
+// and ranked by account, so players who haven't proved who they are (see
+// Server.identify) get today's board as practice, neither counted nor ranked.
+func (gm *GameManager) JoinDaily(
+	user Participant,
+	authenticated bool,
+	ghost bool,
+) *Lobby {
+	gm.Mutex.Lock()
+	defer gm.Mutex.Unlock()
+	if gm.Daily == nil {
+		spec := DefaultBoardSpec(DailySeed(time.Now()))
+		return gm.joinSolo(user, NewSoloLobby(spec, ghost, gm.Results, Rules{}))
+	}
+	if !authenticated {
+		_, spec := gm.Daily.Today()
+		return gm.joinSolo(user, NewSoloLobby(spec, ghost, gm.Results, Rules{}))
+	}
+
+	day, spec, attempt, err := gm.Daily.Attempt(user.Name())
+	if err != nil {
+		user.Logf("Error recording daily attempt: %v", err)
+	}
+	lobby := NewSoloLobby(spec, ghost, gm.Results, Rules{})
+	lobby.Daily = day
+	lobby.Attempt = attempt
+	return gm.joinSolo(user, lobby)
+}
+
+// joinSolo assumes the mutex is already locked.
+func (gm *GameManager) joinSolo(user Participant, lobby *Lobby) *Lobby {
+	lobby.Mazes = gm.Mazes
+	gm.Lobbies = append(gm.Lobbies, lobby)
+	if !lobby.Add(user, gm.rating(user.Name())) {
+		panic("Couldn't add user to solo lobby")
+	}
+	return lobby
+}
+
+func (gm *GameManager) Drop(user Participant) {
 	gm.Mutex.Lock()
 	defer gm.Mutex.Unlock()
 
 	for i, lobby := range gm.Lobbies {
 		if success, count := lobby.Drop(user); success {
-			// If there are no more players in the lobby, remove it
-			if count < 1 {
+			// If there are no more players in the lobby, remove it. Bots
+			// don't keep a lobby alive, so they leave with the last human.
+			if count < 1 || lobby.Humans() < 1 {
+				lobby.DropBots()
 				gm.Lobbies = append(gm.Lobbies[:i], gm.Lobbies[i+1:]...)
 				return
 			}
@@ -1,15 +1,46 @@
 package main
 
 import (
+	"sort"
 	"sync"
 	"time"
 )
 
+// ghostInterval is how often a game with a ghost is re-broadcast so the ghost
+// keeps moving between the player's own moves.
+const ghostInterval = 100 * time.Millisecond
+
+// maxQueuedMoves bounds how far ahead a player can queue moves in a tick-based
+// game; further moves are dropped.
+const maxQueuedMoves = 4
+
 type GameSession struct {
-	Mutex   sync.Mutex
-	Game    Game
-	UserMap map[rune]*UserSession
-	Winner  rune
+	Mutex         sync.Mutex
+	ID            string
+	Game          Game
+	UserMap       map[rune]Participant
+	Winner        rune
+	Results       *Results
+	Solo          bool
+	Daily         string
+	Attempt       int
+	PersonalBests map[rune]time.Duration
+	Maze          string    // the stored maze being played, if any
+	Spec          BoardSpec // what the board was generated from, if not
+
+	// queued holds each player's pending moves in a tick-based game
+	queued map[rune][]Dir
+
+	// recorded holds the players whose solves have been recorded
+	recorded map[rune]bool
+
+	// left holds the users who finished a team game and left before their
+	// solves could be recorded
+	left map[rune]Participant
+
+	// names holds the name of everybody who has played, for the final
+	// standings
+	names map[rune]string
 }
 
 func (gs *GameSession) Broadcast() {
@@ -20,6 +51,7 @@ func (gs *GameSession) Broadcast() {
 
 // broadcast assumes the mutex is already locked
 func (gs *GameSession) broadcast() {
+	defer metrics.BroadcastLatency.ObserveSince(time.Now())
 	players := make([]rune, 0, len(gs.UserMap))
 	for pid := range gs.UserMap {
 		players = append(players, pid)
@@ -34,36 +66,340 @@ func (gs *GameSession) broadcast() {
 		solvedTimes[string(pid)] = duration
 	}
 
+	teams := gs.Game.TeamResults()
+	ends, _ := gs.Game.Ends()
+	var standings []Standing
+	if gs.Game.Over {
+		standings = gs.standings()
+	}
+
+	ratings := make(map[string]int, len(gs.UserMap))
+	if gs.Results != nil {
+		for pid, session := range gs.UserMap {
+			ratings[string(pid)] = int(gs.Results.Rating(session.Name()))
+		}
+	}
+
 	for pid, session := range gs.UserMap {
+		player, _ := gs.Game.Player(pid)
+		tiles, origin := gs.Game.PlayerTiles(pid)
 		session.NotifyUserState(UserState{
 			Mode: ModeGame,
 			GameState: &GameState{
-				Token:       pid,
-				Window:      gs.Game.PlayerWindow(pid),
-				Players:     players,
-				Winner:      winner,
-				SolvedTimes: solvedTimes,
-				GameStart:   gs.Game.Start,
+				Token:        pid,
+				Window:       windowToString(tiles),
+				Tiles:        tiles,
+				Origin:       origin,
+				Players:      players,
+				Winner:       winner,
+				SolvedTimes:  solvedTimes,
+				Ratings:      ratings,
+				PersonalBest: gs.PersonalBests[pid],
+				Daily:        gs.Daily,
+				Attempt:      gs.Attempt,
+				GameStart:    gs.Game.Start,
+				Position:     player.Pos,
+				Tick:         gs.Game.Tick,
+				TickInterval: gs.Game.TickInterval,
+				Conflicts:    gs.Game.Conflicts,
+				Keys:         player.Keys,
+				Effects:      player.Effects.Remaining(player.Moves),
+				Objective:    gs.Game.Objective,
+				Progress:     player.Progress(),
+				Shift:        gs.Game.ShiftInterval,
+				Changes:      gs.Game.Changes,
+				Team:         player.Team,
+				Teammates:    gs.Game.teammates(pid),
+				Teams:        teams,
+				TimeLimit:    gs.Game.TimeLimit,
+				Grace:        gs.Game.Grace,
+				Ends:         ends,
+				Over:         gs.Game.Over,
+				Standings:    standings,
 			},
 		})
 	}
 }
 
-func (gs *GameSession) PlayerMove(pid rune, dir Dir) {
+// PlayerMove moves the player and broadcasts the result. The return value
+// indicates whether the player actually moved. In a tick-based game the move
+// is only queued for a later tick, so the player never moves immediately.
+func (gs *GameSession) PlayerMove(pid rune, dir Dir) bool {
+	metrics.Moves.Inc()
 	gs.Mutex.Lock()
 	defer gs.Mutex.Unlock()
+	if gs.Game.TickInterval > 0 {
+		if len(gs.queued[pid]) < maxQueuedMoves {
+			gs.queued[pid] = append(gs.queued[pid], dir)
+		}
+		return false
+	}
+	before, _ := gs.Game.Player(pid)
+	gs.Game.Conflicts = nil
+	gs.Game.Changes = nil
 	gs.Game = gs.Game.PlayerMove(pid, dir)
+	gs.recordSolves()
+	gs.checkEnd()
 	// TODO: Move these into the user session loop?
 	gs.broadcast()
+	after, _ := gs.Game.Player(pid)
+	return after.Pos != before.Pos
 }
 
-func (gs *GameSession) DropPlayer(user *UserSession) {
+// Ticked reports whether this is a tick-based game.
+func (gs *GameSession) Ticked() bool {
+	gs.Mutex.Lock()
+	defer gs.Mutex.Unlock()
+	return gs.Game.TickInterval > 0
+}
+
+// runTicks advances a tick-based game every `interval` until it's over or
+// everyone has left. Each tick applies the next queued move for every player
+// and then broadcasts once.
+func (gs *GameSession) runTicks(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		gs.Mutex.Lock()
+		if len(gs.UserMap) < 1 || gs.Game.Over {
+			gs.Mutex.Unlock()
+			return
+		}
+		gs.step()
+		gs.Mutex.Unlock()
+	}
+}
+
+// step assumes the mutex is already locked.
+func (gs *GameSession) step() {
+	moves := make(map[rune]Dir, len(gs.queued))
+	for pid, queue := range gs.queued {
+		moves[pid] = queue[0]
+		if len(queue) > 1 {
+			gs.queued[pid] = queue[1:]
+		} else {
+			delete(gs.queued, pid)
+		}
+	}
+
+	gs.Game = gs.Game.Step(moves)
+	gs.recordSolves()
+	gs.checkEnd()
+	gs.broadcast()
+}
+
+// runObjective updates a real-time game's objective every objectiveInterval
+// until it's over or everyone has left, for objectives that change as time
+// passes.
+func (gs *GameSession) runObjective() {
+	t := time.NewTicker(objectiveInterval)
+	defer t.Stop()
+	for range t.C {
+		gs.Mutex.Lock()
+		if len(gs.UserMap) < 1 || gs.Game.Over {
+			gs.Mutex.Unlock()
+			return
+		}
+		gs.Game = gs.Game.objective().Update(gs.Game)
+		gs.recordSolves()
+		gs.checkEnd()
+		gs.broadcast()
+		gs.Mutex.Unlock()
+	}
+}
+
+// runShifts shifts a real-time shifting maze every `interval` until it's over
+// or everyone has left.
+func (gs *GameSession) runShifts(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		gs.Mutex.Lock()
+		if len(gs.UserMap) < 1 || gs.Game.Over {
+			gs.Mutex.Unlock()
+			return
+		}
+		gs.Game = gs.Game.Shift()
+		gs.broadcast()
+		gs.Mutex.Unlock()
+	}
+}
+
+// runClock ends a real-time game with a time limit or a grace period once time
+// is up, checking every clockInterval until it's over or everyone has left.
+func (gs *GameSession) runClock() {
+	t := time.NewTicker(clockInterval)
+	defer t.Stop()
+	for range t.C {
+		gs.Mutex.Lock()
+		if len(gs.UserMap) < 1 || gs.Game.Over {
+			gs.Mutex.Unlock()
+			return
+		}
+		if gs.checkEnd() {
+			gs.broadcast()
+		}
+		gs.Mutex.Unlock()
+	}
+}
+
+// checkEnd ends the game if it's due, recording the solves that were waiting
+// on the result, and reports whether it did. This assumes the mutex is already
+// locked.
+func (gs *GameSession) checkEnd() bool {
+	if gs.Game.Over || !gs.Game.due() {
+		return false
+	}
+	gs.Game = gs.Game.End()
+	gs.recordSolves()
+	metrics.GamesFinished.Inc()
+	return true
+}
+
+// Over reports whether the game is over.
+func (gs *GameSession) Over() bool {
+	gs.Mutex.Lock()
+	defer gs.Mutex.Unlock()
+	return gs.Game.Over
+}
+
+// Standings returns the final standings of a game that is over.
+func (gs *GameSession) Standings() []Standing {
+	gs.Mutex.Lock()
+	defer gs.Mutex.Unlock()
+	return gs.standings()
+}
+
+// recordSolves records the solves of everyone who has finished since it was
+// last called, in token order so replays record them identically. In a team
+// game, solves wait until the winning team is known, since that decides who
+// won. This assumes the mutex is already locked.
+func (gs *GameSession) recordSolves() {
+	if gs.Game.teamGame() && gs.Game.WinningTeam == 0 {
+		return
+	}
+	var solvers []rune
+	for pid := range gs.Game.SolvedTimes {
+		if !gs.recorded[pid] {
+			solvers = append(solvers, pid)
+		}
+	}
+	sort.Slice(solvers, func(i, j int) bool { return solvers[i] < solvers[j] })
+	for _, pid := range solvers {
+		gs.recorded[pid] = true
+		gs.recordSolve(pid)
+	}
+}
+
+// user returns the user playing `pid`, even if they have left. This assumes
+// the mutex is already locked.
+func (gs *GameSession) user(pid rune) Participant {
+	if user, found := gs.UserMap[pid]; found {
+		return user
+	}
+	return gs.left[pid]
+}
+
+func (gs *GameSession) Player(pid rune) (Player, bool) {
+	gs.Mutex.Lock()
+	defer gs.Mutex.Unlock()
+	return gs.Game.Player(pid)
+}
+
+// boardKey identifies the board being played. Generated boards record how
+// they were generated so matches can be replayed; stored mazes are looked up
+// by name instead.
+func (gs *GameSession) boardKey() BoardKey {
+	key := BoardKey{
+		Seed:         gs.Game.Seed,
+		Width:        gs.Game.Board.Width(),
+		Height:       gs.Game.Board.Height(),
+		Maze:         gs.Maze,
+		TickInterval: gs.Game.TickInterval,
+		Shift:        gs.Game.ShiftInterval,
+	}
+	if gs.Maze == "" {
+		key.Generator, key.Items = gs.Spec.Generator, gs.Spec.Items
+	}
+	return key
+}
+
+// recordSolve assumes the mutex is already locked
+func (gs *GameSession) recordSolve(pid rune) {
+	if gs.Results == nil {
+		return
+	}
+	user := gs.user(pid)
+	player, _ := gs.Game.Player(pid)
+	moves := player.Moves
+	won := gs.Game.won(pid) && !gs.Solo
+
+	// A solve beats everybody still on the board, except in team games,
+	// where the winners beat everybody on the other teams.
+	var beat []string
+	for _, p := range gs.Game.Players {
+		opponent := gs.user(p.ID)
+		if opponent.Name() == user.Name() {
+			continue
+		}
+		_, solved := gs.Game.SolvedTimes[p.ID]
+		if (player.Team > 0 && won && p.Team != player.Team) ||
+			(player.Team == 0 && !solved) {
+			beat = append(beat, opponent.Name())
+		}
+	}
+	board := gs.boardKey()
+	shortest := gs.Game.Board.ShortestPathLength()
+	if err := gs.Results.Record(Solve{
+		MatchID:      gs.ID,
+		Player:       user.Name(),
+		Token:        string(pid),
+		Seed:         board.Seed,
+		Width:        board.Width,
+		Height:       board.Height,
+		Duration:     gs.Game.SolvedTimes[pid],
+		Moves:        moves,
+		Shortest:     shortest,
+		Efficiency:   efficiency(shortest, moves),
+		Won:          won,
+		Solo:         gs.Solo,
+		Daily:        gs.Daily,
+		Maze:         board.Maze,
+		Generator:    board.Generator,
+		Items:        board.Items || len(gs.Game.Board.Items) > 0,
+		Bot:          isBot(user),
+		TickInterval: board.TickInterval,
+		Shift:        board.Shift,
+		Objective:    gs.Game.Objective.Kind,
+		Finished:     time.Now(),
+		Path:         gs.Game.PlayerPath(pid),
+		Shifts:       gs.Game.Shifts,
+		Beat:         beat,
+	}); err != nil {
+		user.Logf("Error recording solve: %v", err)
+	}
+}
+
+func (gs *GameSession) DropPlayer(user Participant) {
 	gs.Mutex.Lock()
 	defer gs.Mutex.Unlock()
 	for pid, u := range gs.UserMap {
 		if u == user {
-			gs.Game = gs.Game.DropPlayer(pid)
 			delete(gs.UserMap, pid)
+			delete(gs.queued, pid)
+
+			// A player who leaves while their team is still playing
+			// stays on the board, so their time still counts.
+			_, solved := gs.Game.SolvedTimes[pid]
+			if solved && !gs.recorded[pid] {
+				gs.left[pid] = user
+				return
+			}
+
+			// Leaving can decide the game for whoever is left.
+			gs.Game = gs.Game.DropPlayer(pid)
+			gs.recordSolves()
+			gs.checkEnd()
 			return
 		}
 	}
@@ -71,10 +407,37 @@ func (gs *GameSession) DropPlayer(user *UserSession) {
 	gs.broadcast()
 }
 
-func (gs *GameSession) AddPlayer(pid rune, user *UserSession) {
+// AddPlayer hands the player `pid`, who must already be on the board, to the
+// user and tells them the game has started.
+func (gs *GameSession) AddPlayer(pid rune, user Participant) {
 	gs.Mutex.Lock()
 	defer gs.Mutex.Unlock()
-	gs.Game = gs.Game.AddPlayer(pid)
 	gs.UserMap[pid] = user
+	gs.names[pid] = user.Name()
+	if gs.Results != nil {
+		best, found := gs.Results.PersonalBest(user.Name(), gs.boardKey())
+		if found {
+			gs.PersonalBests[pid] = best.Duration
+		}
+	}
 	user.GameStart(PlayerSession{Token: pid, GameSession: gs})
 }
+
+// runGhost re-broadcasts the game until the ghost has finished its run, the
+// game is over or everyone has left.
+func (gs *GameSession) runGhost() {
+	t := time.NewTicker(ghostInterval)
+	defer t.Stop()
+	for range t.C {
+		gs.Mutex.Lock()
+		ghost := gs.Game.Ghost
+		finished := len(ghost) < 1 ||
+			time.Since(gs.Game.Start) > ghost[len(ghost)-1].Elapsed
+		if len(gs.UserMap) < 1 || gs.Game.Over || finished {
+			gs.Mutex.Unlock()
+			return
+		}
+		gs.broadcast()
+		gs.Mutex.Unlock()
+	}
+}
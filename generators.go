@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const defaultGenerator = "default"
+
+// Generator builds a board from a seed and a size in cells (not tiles); a
+// w x h cell board is 2w+1 tiles wide and 2h+1 tiles tall. Generators must be
+// deterministic for a given seed so boards can be shared by seed alone.
+type Generator func(seed int64, w, h int) Board
+
+var generators = map[string]Generator{
+	defaultGenerator: GenerateBoard,
+	"binary-tree":    GenerateBinaryTreeBoard,
+}
+
+// BoardSpec describes how to generate a board.
+type BoardSpec struct {
+	Seed      int64  `json:"seed"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Generator string `json:"generator"`
+	Items     bool   `json:"items,omitempty"` // scatter items over the board
+}
+
+func DefaultBoardSpec(seed int64) BoardSpec {
+	return BoardSpec{
+		Seed:      seed,
+		Width:     boardWidth,
+		Height:    boardHeight,
+		Generator: defaultGenerator,
+	}
+}
+
+func (spec BoardSpec) Validate() error {
+	if _, found := generators[spec.Generator]; !found {
+		return fmt.Errorf("Unknown generator: %s", spec.Generator)
+	}
+	if spec.Width < 1 || spec.Height < 1 {
+		return fmt.Errorf(
+			"Board size must be positive; got %dx%d",
+			spec.Width,
+			spec.Height,
+		)
+	}
+	return nil
+}
+
+// Generate builds the board; the spec must be valid.
+func (spec BoardSpec) Generate() Board {
+	generate, found := generators[spec.Generator]
+	if !found {
+		panic("Unknown generator: " + spec.Generator)
+	}
+	board := generate(spec.Seed, spec.Width, spec.Height)
+	if spec.Items {
+		board = placeItems(board, rand.New(rand.NewSource(spec.Seed)))
+	}
+	return board
+}
+
+// GenerateBinaryTreeBoard carves each cell open to either the north or the
+// east. The result is a perfect maze with a strong diagonal bias and long
+// open corridors along the top and right edges, so it plays very differently
+// from the default generator.
+func GenerateBinaryTreeBoard(seed int64, w, h int) Board {
+	rng := rand.New(rand.NewSource(seed))
+	rows := make([][]rune, 2*h+1)
+	for y := range rows {
+		rows[y] = make([]rune, 2*w+1)
+		for x := range rows[y] {
+			rows[y][x] = tileWall
+		}
+	}
+
+	for cy := 0; cy < h; cy++ {
+		for cx := 0; cx < w; cx++ {
+			x, y := 2*cx+1, 2*cy+1
+			rows[y][x] = tileSpace
+			north, east := cy > 0, cx < w-1
+			if north && (!east || rng.Intn(2) == 0) {
+				rows[y-1][x] = tileSpace
+			} else if east {
+				rows[y][x+1] = tileSpace
+			}
+		}
+	}
+
+	board := Board{
+		Rows:  rows,
+		Start: Point{0, 1},
+		End:   Point{2 * w, 2*h - 1},
+	}
+	rows[board.Start.Y][board.Start.X] = 'S'
+	rows[board.End.Y][board.End.X] = 'E'
+	return board
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Item is something lying on a board tile. Items are drawn with their own
+// characters, both in board files and in players' windows.
+type Item rune
+
+const (
+	ItemKey    Item = 'k' // opens one door
+	ItemDoor   Item = 'D' // impassable without a key
+	ItemSpeed  Item = '+' // every move goes two tiles for a while
+	ItemPhase  Item = '~' // step through walls for a while
+	ItemReveal Item = '?' // see the whole board for a while
+
+	// Teleporters come in pairs marked with the same digit; stepping onto
+	// one moves the player to the other.
+	itemTeleporterFirst Item = '0'
+	itemTeleporterLast  Item = '9'
+)
+
+// effectMoves is how many moves each effect lasts once picked up.
+const effectMoves = 10
+
+var itemNames = map[Item]string{
+	ItemKey:    "key",
+	ItemDoor:   "door",
+	ItemSpeed:  "speed",
+	ItemPhase:  "phase",
+	ItemReveal: "reveal",
+}
+
+func (item Item) String() string {
+	if item.IsTeleporter() {
+		return "teleporter"
+	}
+	if name, found := itemNames[item]; found {
+		return name
+	}
+	return fmt.Sprintf("Item(%q)", rune(item))
+}
+
+func (item Item) IsTeleporter() bool {
+	return item >= itemTeleporterFirst && item <= itemTeleporterLast
+}
+
+// isItem reports whether `r` marks an item in a board file or window.
+func isItem(r rune) bool {
+	_, found := itemNames[Item(r)]
+	return found || Item(r).IsTeleporter()
+}
+
+// Effects are the temporary powers a player has picked up. Each is the move
+// count (see Player.Moves) at which it wears off.
+type Effects struct {
+	Speed  int `json:"speed,omitempty"`
+	Phase  int `json:"phase,omitempty"`
+	Reveal int `json:"reveal,omitempty"`
+}
+
+// Remaining returns how many more moves each effect lasts for a player who
+// has made `moves` moves.
+func (e Effects) Remaining(moves int) Effects {
+	remaining := func(until int) int {
+		if until > moves {
+			return until - moves
+		}
+		return 0
+	}
+	return Effects{
+		Speed:  remaining(e.Speed),
+		Phase:  remaining(e.Phase),
+		Reveal: remaining(e.Reveal),
+	}
+}
+
+// otherTeleporter returns the other end of the teleporter at `p`.
+func otherTeleporter(items map[Point]Item, p Point) (Point, bool) {
+	for q, item := range items {
+		if item == items[p] && q != p {
+			return q, true
+		}
+	}
+	return Point{}, false
+}
+
+// validateItems checks that every teleporter has exactly one partner.
+func validateItems(items map[Point]Item) error {
+	counts := map[Item]int{}
+	for _, item := range items {
+		if item.IsTeleporter() {
+			counts[item]++
+		}
+	}
+	for item, count := range counts {
+		if count != 2 {
+			return fmt.Errorf(
+				"Wanted 2 teleporters marked '%c'; got %d",
+				rune(item),
+				count,
+			)
+		}
+	}
+	return nil
+}
+
+// placeItems scatters one of each item over the open tiles of a board. The
+// door goes halfway along the shortest path and the key somewhere that can be
+// reached without passing through the door, so the board stays solvable.
+func placeItems(b Board, rng *rand.Rand) Board {
+	b.Items = map[Point]Item{}
+	path := b.ShortestPath(b.Start, b.End)
+	if len(path) < 3 {
+		return b
+	}
+	door := path[len(path)/2]
+	b.Items[door] = ItemDoor
+
+	free := func(p Point) bool {
+		_, taken := b.Items[p]
+		return !taken && p != b.Start && p != b.End
+	}
+	var beforeDoor []Point
+	for _, p := range b.reachable(b.Start, door) {
+		if free(p) {
+			beforeDoor = append(beforeDoor, p)
+		}
+	}
+	if len(beforeDoor) < 1 {
+		delete(b.Items, door)
+	} else {
+		b.Items[beforeDoor[rng.Intn(len(beforeDoor))]] = ItemKey
+	}
+
+	var open []Point
+	for y, row := range b.Rows {
+		for x, tile := range row {
+			if p := (Point{x, y}); tile != tileWall && free(p) {
+				open = append(open, p)
+			}
+		}
+	}
+	for _, item := range []Item{
+		ItemSpeed,
+		ItemPhase,
+		ItemReveal,
+		itemTeleporterFirst,
+		itemTeleporterFirst,
+	} {
+		for tries := 0; tries < len(open); tries++ {
+			if p := open[rng.Intn(len(open))]; free(p) {
+				b.Items[p] = item
+				break
+			}
+		}
+	}
+	if err := validateItems(b.Items); err != nil {
+		// Only possible on a tiny board; drop the odd teleporter.
+		for p, item := range b.Items {
+			if item.IsTeleporter() {
+				delete(b.Items, p)
+			}
+		}
+	}
+	return b
+}
+
+// reachable lists the points reachable from `from` without passing through
+// `blocked`, in the order a breadth-first search finds them.
+func (b *Board) reachable(from, blocked Point) []Point {
+	seen := map[Point]bool{from: true, blocked: true}
+	queue := []Point{from}
+	for i := 0; i < len(queue); i++ {
+		for _, d := range dirs {
+			next := queue[i].Translate(d)
+			if !seen[next] && b.IsPath(next) {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return queue
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	boardSeed       = "seed"
+	boardSize       = "size"
+	boardWins       = "wins"
+	boardEfficiency = "efficiency"
+	boardDaily      = "daily"
+
+	// Bots are ranked separately from humans on boards with this prefix,
+	// e.g. `bots/wins`.
+	botsPrefix = "bots/"
+)
+
+type LeaderboardEntry struct {
+	Rank   int    `json:"rank"`
+	Player string `json:"player"`
+	Wins   int    `json:"wins,omitempty"`
+	Solve  *Solve `json:"solve,omitempty"`
+}
+
+// Leaderboard is a single page of a leaderboard.
+type Leaderboard struct {
+	Board   string             `json:"board"`
+	Total   int                `json:"total"`
+	Offset  int                `json:"offset"`
+	Limit   int                `json:"limit"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// Record is published whenever a solve takes first place on a leaderboard.
+type Record struct {
+	Board string           `json:"board"`
+	Entry LeaderboardEntry `json:"entry"`
+}
+
+// seedBoard names the board ranking solves of exactly the same board, e.g.
+// `seed/42,15x15,default,tick=200ms`.
+func seedBoard(key BoardKey) string {
+	return fmt.Sprintf("%s/%d,%s", boardSeed, key.Seed, boardShape(key))
+}
+
+func dailyBoard(day string) string {
+	return fmt.Sprintf("%s/%s", boardDaily, day)
+}
+
+// sizeBoard names the board ranking solves of boards of the same size made
+// the same way, whatever their seed, e.g. `size/15x15,default,items`.
+func sizeBoard(key BoardKey) string {
+	return fmt.Sprintf("%s/%s", boardSize, boardShape(key))
+}
+
+// boardShape describes everything about a board but its seed: its size, how
+// it was generated or which stored maze it is (last, as maze names may have
+// commas), and its timing.
+func boardShape(key BoardKey) string {
+	shape := fmt.Sprintf("%dx%d", key.Width, key.Height)
+	if key.Maze == "" {
+		shape += "," + key.Generator
+		if key.Items {
+			shape += ",items"
+		}
+	}
+	if key.TickInterval > 0 {
+		shape += ",tick=" + key.TickInterval.String()
+	}
+	if key.Shift > 0 {
+		shape += ",shift=" + key.Shift.String()
+	}
+	if key.Maze != "" {
+		shape += ",maze=" + key.Maze
+	}
+	return shape
+}
+
+// ranked reports whether a solve can appear on leaderboards. Anybody can play
+// as anonymousName, so its solves are kept (for replays) but never ranked.
+func ranked(solve Solve) bool {
+	return solve.Player != anonymousName
+}
+
+// solveBoards returns the names of every leaderboard a solve can appear on.
+// Times and paths are only comparable between races to the end, so solves of
+// other objectives only count towards wins. Paths through boards with items
+// depend on the items, so those solves are left off the efficiency board.
+func solveBoards(solve Solve) []string {
+	var boards []string
+	if !ranked(solve) {
+		return boards
+	}
+	if solve.Objective == "" {
+		boards = append(
+			boards,
+			seedBoard(solve.BoardKey()),
+			sizeBoard(solve.BoardKey()),
+		)
+		if !solve.Items {
+			boards = append(boards, boardEfficiency)
+		}
+	}
+	if solve.Won {
+		boards = append(boards, boardWins)
+	}
+	if solve.Daily != "" {
+		boards = append(boards, dailyBoard(solve.Daily))
+	}
+	if solve.Bot {
+		for i, board := range boards {
+			boards[i] = botsPrefix + board
+		}
+	}
+	return boards
+}
+
+// brokeRecord reports whether `solve` made `leader` the leader of a board.
+// For the wins board, a win by the leader extends their lead, which counts as
+// breaking the record.
+func brokeRecord(solve Solve, leader *LeaderboardEntry) bool {
+	if leader == nil || leader.Player != solve.Player {
+		return false
+	}
+	if leader.Solve != nil {
+		return leader.Solve.MatchID == solve.MatchID &&
+			leader.Solve.Token == solve.Token
+	}
+	return true
+}
+
+func fasterSolve(a, b Solve) bool {
+	if a.Duration != b.Duration {
+		return a.Duration < b.Duration
+	}
+	return a.Finished.Before(b.Finished)
+}
+
+func moreEfficientSolve(a, b Solve) bool {
+	if a.Efficiency != b.Efficiency {
+		return a.Efficiency > b.Efficiency
+	}
+	return fasterSolve(a, b)
+}
+
+// ranking says which solves count towards a board: only bots' or only
+// humans', only ranked races to the end, and only those that `match`. Each
+// player is ranked by their best solve according to `better`.
+type ranking struct {
+	bots   bool
+	match  func(Solve) bool
+	better func(a, b Solve) bool
+}
+
+func (rk ranking) counts(solve Solve) bool {
+	return solve.Bot == rk.bots && solve.Objective == "" && ranked(solve) &&
+		rk.match(solve)
+}
+
+// bestSolves ranks the best solve for each player. This assumes the mutex is
+// already locked.
+func (r *Results) bestSolves(rk ranking) []LeaderboardEntry {
+	best := map[string]int{}
+	for i, solve := range r.Solves {
+		if !rk.counts(solve) {
+			continue
+		}
+		j, found := best[solve.Player]
+		if !found || rk.better(solve, r.Solves[j]) {
+			best[solve.Player] = i
+		}
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(best))
+	for _, i := range best {
+		entries = append(entries, solveEntry(r.Solves[i]))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return rk.better(*entries[i].Solve, *entries[j].Solve)
+	})
+	return rank(entries)
+}
+
+// bestSolve returns the leader of a board ranking solves, without ranking
+// everybody else. This assumes the mutex is already locked.
+func (r *Results) bestSolve(rk ranking) *LeaderboardEntry {
+	best := -1
+	for i, solve := range r.Solves {
+		if rk.counts(solve) && (best < 0 || rk.better(solve, r.Solves[best])) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	entry := solveEntry(r.Solves[best])
+	entry.Rank = 1
+	return &entry
+}
+
+func solveEntry(solve Solve) LeaderboardEntry {
+	// paths are only needed for ghosts and replays; leave them out of
+	// leaderboards to keep them small
+	solve.Path = nil
+	return LeaderboardEntry{Player: solve.Player, Solve: &solve}
+}
+
+// wins counts the ranked wins of each player. This assumes the mutex is
+// already locked.
+func (r *Results) wins(bots bool) map[string]int {
+	wins := map[string]int{}
+	for _, solve := range r.Solves {
+		if solve.Won && solve.Bot == bots && ranked(solve) {
+			wins[solve.Player]++
+		}
+	}
+	return wins
+}
+
+func moreWins(a, b LeaderboardEntry) bool {
+	if a.Wins != b.Wins {
+		return a.Wins > b.Wins
+	}
+	return a.Player < b.Player
+}
+
+// mostWins assumes the mutex is already locked.
+func (r *Results) mostWins(bots bool) []LeaderboardEntry {
+	wins := r.wins(bots)
+	entries := make([]LeaderboardEntry, 0, len(wins))
+	for player, count := range wins {
+		entries = append(entries, LeaderboardEntry{Player: player, Wins: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return moreWins(entries[i], entries[j])
+	})
+	return rank(entries)
+}
+
+// mostWinsLeader returns the leader of a wins board. This assumes the mutex is
+// already locked.
+func (r *Results) mostWinsLeader(bots bool) *LeaderboardEntry {
+	var leader *LeaderboardEntry
+	for player, count := range r.wins(bots) {
+		entry := LeaderboardEntry{Rank: 1, Player: player, Wins: count}
+		if leader == nil || moreWins(entry, *leader) {
+			leader = &entry
+		}
+	}
+	return leader
+}
+
+func rank(entries []LeaderboardEntry) []LeaderboardEntry {
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries
+}
+
+// InvalidLeaderboardError is returned for leaderboards whose key can't be
+// parsed, e.g. `seed/abc`.
+type InvalidLeaderboardError struct {
+	Err error
+}
+
+func (err InvalidLeaderboardError) Error() string {
+	return "Invalid leaderboard: " + err.Err.Error()
+}
+
+// parseBoard returns how the named board ranks players. The wins board ranks
+// players by their wins rather than by their solves, which is reported as
+// `wins`.
+func parseBoard(board string) (rk ranking, wins bool, err error) {
+	rk.bots = strings.HasPrefix(board, botsPrefix)
+	name := strings.TrimPrefix(board, botsPrefix)
+	kind, key := name, ""
+	if parts := strings.SplitN(kind, "/", 2); len(parts) == 2 {
+		kind, key = parts[0], parts[1]
+	}
+
+	switch kind {
+	case boardSeed:
+		seed := strings.SplitN(key, ",", 2)[0]
+		if _, err := strconv.ParseInt(seed, 10, 64); err != nil {
+			return rk, false, InvalidLeaderboardError{
+				fmt.Errorf("Invalid seed '%s': %v", seed, err),
+			}
+		}
+		rk.match = func(s Solve) bool { return seedBoard(s.BoardKey()) == name }
+		rk.better = fasterSolve
+	case boardSize:
+		var w, h int
+		if _, err := fmt.Sscanf(key, "%dx%d", &w, &h); err != nil {
+			return rk, false, InvalidLeaderboardError{
+				fmt.Errorf("Invalid board size '%s': %v", key, err),
+			}
+		}
+		rk.match = func(s Solve) bool { return sizeBoard(s.BoardKey()) == name }
+		rk.better = fasterSolve
+	case boardDaily:
+		rk.match = func(s Solve) bool { return s.Daily == key }
+		rk.better = fasterSolve
+	case boardWins:
+		return rk, true, nil
+	case boardEfficiency:
+		rk.match = func(s Solve) bool { return !s.Items }
+		rk.better = moreEfficientSolve
+	default:
+		return rk, false, fmt.Errorf("Unknown leaderboard: %s", board)
+	}
+	return rk, false, nil
+}
+
+// entries returns the full ranking for the named board. This assumes the mutex
+// is already locked.
+func (r *Results) entries(board string) ([]LeaderboardEntry, error) {
+	rk, wins, err := parseBoard(board)
+	if err != nil {
+		return nil, err
+	}
+	if wins {
+		return r.mostWins(rk.bots), nil
+	}
+	return r.bestSolves(rk), nil
+}
+
+// leader returns the first entry of the named board, if any. Only the leader
+// is found, so this is much cheaper than ranking the whole board. This assumes
+// the mutex is already locked.
+func (r *Results) leader(board string) *LeaderboardEntry {
+	rk, wins, err := parseBoard(board)
+	if err != nil {
+		return nil
+	}
+	if wins {
+		return r.mostWinsLeader(rk.bots)
+	}
+	return r.bestSolve(rk)
+}
+
+// Leaderboard returns the page of the named board starting at `offset` and
+// containing at most `limit` entries.
+func (r *Results) Leaderboard(board string, offset, limit int) (
+	Leaderboard,
+	error,
+) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	entries, err := r.entries(board)
+	if err != nil {
+		return Leaderboard{}, err
+	}
+
+	page := Leaderboard{
+		Board:   board,
+		Total:   len(entries),
+		Offset:  offset,
+		Limit:   limit,
+		Entries: []LeaderboardEntry{},
+	}
+	if offset < len(entries) {
+		end := offset + limit
+		if end > len(entries) {
+			end = len(entries)
+		}
+		page.Entries = entries[offset:end]
+	}
+	return page, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testSolve is a race to the end of the same generated board.
+func testSolve(player string, duration time.Duration, won bool) Solve {
+	return Solve{
+		MatchID:    player,
+		Player:     player,
+		Token:      "@",
+		Seed:       1,
+		Width:      9,
+		Height:     9,
+		Generator:  defaultGenerator,
+		Duration:   duration,
+		Moves:      10,
+		Shortest:   10,
+		Efficiency: 1,
+		Won:        won,
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	for _, testCase := range []struct {
+		name   string
+		solves []Solve
+		board  string
+		want   []string
+	}{{
+		name: "seed",
+		solves: []Solve{
+			testSolve("alice", 3*time.Second, false),
+			testSolve("bob", 2*time.Second, true),
+			testSolve("alice", time.Second, true),
+		},
+		board: seedBoard(testSolve("", 0, false).BoardKey()),
+		want:  []string{"alice", "bob"},
+	}, {
+		name: "anonymous-seed",
+		solves: []Solve{
+			testSolve(anonymousName, time.Second, true),
+			testSolve("bob", 2*time.Second, true),
+		},
+		board: seedBoard(testSolve("", 0, false).BoardKey()),
+		want:  []string{"bob"},
+	}, {
+		name: "anonymous-wins",
+		solves: []Solve{
+			testSolve(anonymousName, time.Second, true),
+			testSolve(anonymousName, time.Second, true),
+			testSolve("bob", 2*time.Second, true),
+		},
+		board: boardWins,
+		want:  []string{"bob"},
+	}, {
+		name: "efficiency-without-items",
+		solves: []Solve{
+			func() Solve {
+				solve := testSolve("alice", time.Second, true)
+				solve.Items = true
+				return solve
+			}(),
+			func() Solve {
+				solve := testSolve("bob", 2*time.Second, true)
+				solve.Efficiency = 0.5
+				return solve
+			}(),
+		},
+		board: boardEfficiency,
+		want:  []string{"bob"},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			var r Results
+			for _, solve := range testCase.solves {
+				if err := r.Record(solve); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			page, err := r.Leaderboard(testCase.board, 0, 10)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var players []string
+			for _, entry := range page.Entries {
+				players = append(players, entry.Player)
+			}
+			if !reflect.DeepEqual(players, testCase.want) {
+				t.Fatalf("Wanted %v; got %v", testCase.want, players)
+			}
+		})
+	}
+}
+
+func TestRecordAnonymous(t *testing.T) {
+	// Anonymous solves are kept for replays but break no records.
+	var r Results
+	records := r.Subscribe()
+	defer r.Unsubscribe(records)
+	solve := testSolve(anonymousName, time.Second, true)
+	if err := r.Record(solve); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(r.Solves) != 1 {
+		t.Fatal("Wanted the solve kept")
+	}
+	select {
+	case record := <-records:
+		t.Fatalf("Wanted no records broken; got %v", record)
+	default:
+	}
+}
+
+func TestLeaderboardInvalid(t *testing.T) {
+	for _, testCase := range []struct {
+		name    string
+		board   string
+		invalid bool
+	}{{
+		name:    "seed",
+		board:   "seed/abc,9x9,default",
+		invalid: true,
+	}, {
+		name:    "size",
+		board:   "bots/size/big",
+		invalid: true,
+	}, {
+		name:  "unknown",
+		board: "fastest",
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			var r Results
+			_, err := r.Leaderboard(testCase.board, 0, 10)
+			if err == nil {
+				t.Fatal("Wanted an error; got nil")
+			}
+			_, invalid := err.(InvalidLeaderboardError)
+			if invalid != testCase.invalid {
+				t.Fatalf(
+					"Wanted invalid=%t; got %t (%v)",
+					testCase.invalid,
+					invalid,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	var r Results
+	records := r.Subscribe()
+	defer r.Unsubscribe(records)
+	key := testSolve("", 0, false).BoardKey()
+	for _, testCase := range []struct {
+		name  string
+		solve Solve
+		want  []string
+	}{{
+		name:  "first",
+		solve: testSolve("alice", 2*time.Second, true),
+		want: []string{
+			seedBoard(key),
+			sizeBoard(key),
+			boardEfficiency,
+			boardWins,
+		},
+	}, {
+		name:  "slower",
+		solve: testSolve("bob", 3*time.Second, true),
+	}, {
+		name:  "faster",
+		solve: testSolve("bob", time.Second, false),
+		want:  []string{seedBoard(key), sizeBoard(key), boardEfficiency},
+	}, {
+		name:  "extend-lead",
+		solve: testSolve("bob", 4*time.Second, true),
+		want:  []string{boardWins},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.solve.MatchID = testCase.name
+			if err := r.Record(testCase.solve); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var boards []string
+			for len(records) > 0 {
+				record := <-records
+				if record.Entry.Player != testCase.solve.Player {
+					t.Fatalf(
+						"Wanted %s to lead %s; got %s",
+						testCase.solve.Player,
+						record.Board,
+						record.Entry.Player,
+					)
+				}
+				boards = append(boards, record.Board)
+			}
+			if !reflect.DeepEqual(boards, testCase.want) {
+				t.Fatalf("Wanted records %v; got %v", testCase.want, boards)
+			}
+		})
+	}
+}
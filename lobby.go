@@ -1,51 +1,300 @@
 package main
 
 import (
+	"math"
 	"sync"
 	"time"
+
+	"github.com/pborman/uuid"
 )
 
 type Lobby struct {
-	Mutex   sync.RWMutex
-	Users   []*UserSession
-	Game    *GameSession
-	MaxSize int
+	Mutex     sync.RWMutex
+	Users     []Participant
+	Game      *GameSession
+	MaxSize   int
+	Results   *Results
+	Created   time.Time
+	FillTimes *FillTimes
+	Votes     map[Participant]bool
+	Ratings   map[Participant]float64 // as of joining (or the last rematch)
+	Spec      BoardSpec
+	Rules     Rules
+	Mazes     *MazeLibrary // where the maze the rules name (if any) is found
+
+	// Logs is where the bots added to the lobby log (see NewBot)
+	Logs chan<- interface{}
+
+	// Rematches holds the users' votes for the next match, once the current
+	// one is over
+	Rematches map[Participant]Rematch
+
+	// Solo lobbies hold a single player and race against their personal
+	// best, optionally drawn as a ghost. Daily is the day of the daily
+	// challenge being played, if any.
+	Solo    bool
+	Ghost   bool
+	Daily   string
+	Attempt int
+}
+
+// NewLobby creates an empty lobby, whose games are played by `rules`, and
+// starts its queue timeout.
+func NewLobby(
+	maxSize int,
+	results *Results,
+	fillTimes *FillTimes,
+	rules Rules,
+) *Lobby {
+	spec := DefaultBoardSpec(seed)
+	spec.Items = rules.Items
+	l := &Lobby{
+		MaxSize:   maxSize,
+		Results:   results,
+		Created:   time.Now(),
+		FillTimes: fillTimes,
+		Votes:     map[Participant]bool{},
+		Ratings:   map[Participant]float64{},
+		Spec:      spec,
+		Rules:     rules,
+		Rematches: map[Participant]Rematch{},
+	}
+	time.AfterFunc(queueTimeout, l.timeout)
+	return l
+}
+
+// NewSoloLobby creates a lobby for a single player on the board described by
+// `spec` (with items if the rules call for them). The game starts as soon as
+// the player is added.
+func NewSoloLobby(
+	spec BoardSpec,
+	ghost bool,
+	results *Results,
+	rules Rules,
+) *Lobby {
+	spec.Items = spec.Items || rules.Items
+	return &Lobby{
+		MaxSize:   1,
+		Results:   results,
+		Created:   time.Now(),
+		Votes:     map[Participant]bool{},
+		Ratings:   map[Participant]float64{},
+		Spec:      spec,
+		Solo:      true,
+		Ghost:     ghost,
+		Rules:     rules,
+		Rematches: map[Participant]Rematch{},
+	}
 }
 
 func (l *Lobby) Broadcast() {
 	l.Mutex.Lock()
 	defer l.Mutex.Unlock()
+	l.broadcast()
+}
+
+// broadcast assumes the mutex is already locked. Once the game is over, the
+// lobby is back in charge: users are told how the vote for a rematch is going.
+func (l *Lobby) broadcast() {
 	var userState UserState
-	if l.Game != nil {
+	if l.Game != nil && !l.Game.Over() {
 		l.Game.Broadcast()
 	} else {
+		defer metrics.BroadcastLatency.ObserveSince(time.Now())
 		userState = UserState{
-			Mode:       ModeLobby,
+			Mode:       ModeMatchMaking,
 			LobbyState: l.lobbyState(),
 		}
+		if l.Game != nil {
+			userState.Mode = ModeLobby
+		}
 		for _, user := range l.Users {
 			user.NotifyUserState(userState)
 		}
 	}
 }
 
+// timeout starts the game once the lobby has been queued for queueTimeout.
+// If enough people have joined, the game starts with a partial lobby;
+// otherwise the empty seats are filled with bots of the level the rules ask
+// for.
+func (l *Lobby) timeout() {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	humans := l.humans()
+	if l.Game != nil || humans < 1 {
+		return
+	}
+	if humans < minPlayers {
+		l.addBots(l.Rules.BotLevel(), l.MaxSize-len(l.Users))
+	}
+	if l.Game == nil {
+		l.startGame()
+	}
+	l.broadcast()
+}
+
+// Vote records the user's vote to start the game without waiting for the
+// lobby to fill. The game starts as soon as every user in the lobby has voted.
+func (l *Lobby) Vote(user Participant) {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	if l.Game != nil {
+		return
+	}
+	l.Votes[user] = true
+	if len(l.Votes) >= l.humans() {
+		l.startGame()
+	}
+	l.broadcast()
+}
+
+// AddBots adds up to `count` bots to the lobby, or fills it if `count` is
+// zero. The game starts if the lobby fills.
+func (l *Lobby) AddBots(level BotLevel, count int) {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	if l.Game != nil {
+		return
+	}
+	if count < 1 || count > l.MaxSize-len(l.Users) {
+		count = l.MaxSize - len(l.Users)
+	}
+	l.addBots(level, count)
+	l.broadcast()
+}
+
+// addBots assumes the mutex is already locked.
+func (l *Lobby) addBots(level BotLevel, count int) {
+	for i := 0; i < count; i++ {
+		bot := NewBot(level, l.Logs)
+		rating := float64(initialRating)
+		if l.Results != nil {
+			rating = l.Results.Rating(bot.Name())
+		}
+		l.add(bot, rating)
+	}
+}
+
+// DropBots removes (and stops) every bot in the lobby.
+func (l *Lobby) DropBots() {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	users := make([]Participant, len(l.Users))
+	copy(users, l.Users)
+	for _, user := range users {
+		if bot, ok := user.(*Bot); ok {
+			l.drop(bot)
+			bot.Stop()
+		}
+	}
+}
+
+// Humans counts the users in the lobby who aren't server-side bots. Agents
+// connected over the bot socket count as humans here since, like people, they
+// come and go on their own.
+func (l *Lobby) Humans() int {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	return l.humans()
+}
+
+// humans assumes the mutex is already locked.
+func (l *Lobby) humans() int {
+	humans := 0
+	for _, user := range l.Users {
+		if _, ok := user.(*Bot); !ok {
+			humans++
+		}
+	}
+	return humans
+}
+
+// LobbyState returns the state of the lobby as shown to players and on the
+// stats socket.
+func (l *Lobby) LobbyState() LobbyState {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	return *l.lobbyState()
+}
+
+// lobbyState assumes the mutex is already locked.
 func (l *Lobby) lobbyState() *LobbyState {
+	ratings := make([]int, len(l.Users))
+	for i, user := range l.Users {
+		ratings[i] = int(l.Ratings[user])
+	}
+	waited := time.Since(l.Created)
+	var eta time.Duration
+	if l.Game == nil && l.FillTimes != nil {
+		eta = l.FillTimes.ETA(waited)
+	}
+	over := l.Game != nil && l.Game.Over()
+	var standings []Standing
+	var match string
+	if over {
+		standings = l.Game.Standings()
+		match = l.Game.ID
+	}
 	return &LobbyState{
-		Players:    len(l.Users),
-		Total:      l.MaxSize,
-		InProgress: l.Game != nil,
+		Players:       len(l.Users),
+		Bots:          len(l.Users) - l.humans(),
+		Total:         l.MaxSize,
+		InProgress:    l.Game != nil && !over,
+		Over:          over,
+		Standings:     standings,
+		Match:         match,
+		Rematches:     len(l.Rematches),
+		AverageRating: int(l.averageRating()),
+		Ratings:       ratings,
+		Waited:        waited,
+		ETA:           eta,
+		Votes:         len(l.Votes),
+		Rules:         l.Rules,
 	}
 }
 
+// averageRating assumes the mutex is already locked.
+func (l *Lobby) averageRating() float64 {
+	if len(l.Users) < 1 {
+		return initialRating
+	}
+	var total float64
+	for _, user := range l.Users {
+		total += l.Ratings[user]
+	}
+	return total / float64(len(l.Users))
+}
+
+// ratingDistance returns how far `rating` is from the lobby's average rating
+// and whether that is close enough for the player to join as of `now`.
+// Lobbies that have already started are never close enough.
+func (l *Lobby) ratingDistance(rating float64, now time.Time) (float64, bool) {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	if l.Game != nil {
+		return 0, false
+	}
+	distance := math.Abs(l.averageRating() - rating)
+	return distance, distance <= ratingWindow(now.Sub(l.Created))
+}
+
 // Add adds a user to the lobby and starts the game if the lobby is full.
 // Starting the game implies notifying the players that the game has started.
 // The return value indicates whether or not the player was successfully added.
-// In partiuclar, `false` means the lobby is full.
-func (l *Lobby) Add(user *UserSession) bool {
+// In partiuclar, `false` means the lobby is full. `rating` is the user's
+// rating at the time of joining.
+func (l *Lobby) Add(user Participant, rating float64) bool {
 	l.Mutex.Lock()
 	defer l.Mutex.Unlock()
+	return l.add(user, rating)
+}
+
+// add assumes the mutex is already locked.
+func (l *Lobby) add(user Participant, rating float64) bool {
 	if l.Game == nil {
 		l.Users = append(l.Users, user)
+		l.Ratings[user] = rating
 		if len(l.Users) >= l.MaxSize {
 			l.startGame()
 		}
@@ -57,9 +306,20 @@ func (l *Lobby) Add(user *UserSession) bool {
 // Drops the user if found in the lobby. Returns a `bool` indicating whether or
 // not the user was found (and consequently whether or not the drop succeeded)
 // and an `int` representing the count of remaining users.
-func (l *Lobby) Drop(user *UserSession) (bool, int) {
+func (l *Lobby) Drop(user Participant) (bool, int) {
 	l.Mutex.Lock()
 	defer l.Mutex.Unlock()
+	dropped := l.drop(user)
+
+	// Whoever is left may all have voted for a rematch already.
+	if dropped && len(l.Rematches) > 0 && len(l.Rematches) >= l.humans() {
+		l.rematch(l.chosenRematch())
+	}
+	return dropped, len(l.Users)
+}
+
+// drop assumes the mutex is already locked.
+func (l *Lobby) drop(user Participant) bool {
 	for i, u := range l.Users {
 		if u == user {
 			if l.Game != nil {
@@ -67,27 +327,116 @@ func (l *Lobby) Drop(user *UserSession) (bool, int) {
 				user.ClearGame()
 			}
 			l.Users = append(l.Users[:i], l.Users[i+1:]...)
-			return true, len(l.Users)
+			delete(l.Votes, user)
+			delete(l.Ratings, user)
+			delete(l.Rematches, user)
+			return true
 		}
 	}
-	return false, len(l.Users)
+	return false
 }
 
 // This method assumes the mutex is already locked; it has a side effect of
 // creating the game session and notifying all players that the game has
 // started
 func (l *Lobby) startGame() {
+	// Rematches don't wait for the lobby to fill, so they don't count.
+	if l.FillTimes != nil && l.Game == nil {
+		l.FillTimes.Record(time.Since(l.Created))
+		metrics.FillTime.ObserveSince(l.Created)
+	}
+	metrics.GamesStarted.Inc()
+	board, seed := l.board()
 	l.Game = &GameSession{
+		ID: uuid.New(),
 		Game: Game{
-			Board:       GenerateBoard(seed, boardWidth, boardHeight),
-			Players:     make([]Player, len(l.Users)),
-			WindowSize:  Point{41, 21},
-			SolvedTimes: map[rune]time.Duration{},
-			Start:       time.Now(),
+			Seed:          seed,
+			Board:         board,
+			Items:         board.Items,
+			Players:       make([]Player, 0, len(l.Users)),
+			WindowSize:    Point{41, 21},
+			SolvedTimes:   map[rune]time.Duration{},
+			Start:         time.Now(),
+			TickInterval:  l.Rules.TickInterval,
+			Collisions:    l.Rules.Collisions,
+			Objective:     l.Rules.Objective,
+			ShiftInterval: l.Rules.Shift,
+			Scoring:       l.Rules.Scoring,
+			TimeLimit:     l.Rules.TimeLimit,
+			Grace:         l.Rules.Grace,
 		},
-		UserMap: make(map[rune]*UserSession, len(l.Users)),
+		UserMap:       make(map[rune]Participant, len(l.Users)),
+		Results:       l.Results,
+		Solo:          l.Solo,
+		Daily:         l.Daily,
+		Attempt:       l.Attempt,
+		PersonalBests: map[rune]time.Duration{},
+		Maze:          l.Rules.Maze,
+		Spec:          l.Spec,
+		queued:        map[rune][]Dir{},
+		recorded:      map[rune]bool{},
+		left:          map[rune]Participant{},
+		names:         map[rune]string{},
+	}
+
+	// The board is set up before anybody is told the game has started, since
+	// they may start moving straight away.
+	teams := map[Participant]int{}
+	if l.Rules.Teams > 0 {
+		teams = assignTeams(l.Users, l.Ratings, l.Rules.Teams)
+	}
+	for i, user := range l.Users {
+		team := teams[user]
+		l.Game.Game = l.Game.Game.AddPlayer(playerTokens[i]).MapPlayer(
+			playerTokens[i],
+			func(p Player) Player {
+				p.Team = team
+				return p
+			},
+		)
 	}
+	l.Game.Game = l.Game.Game.objective().Setup(l.Game.Game)
 	for i, user := range l.Users {
 		l.Game.AddPlayer(playerTokens[i], user)
 	}
+
+	if l.Solo && l.Ghost && l.Results != nil {
+		best, found := l.Results.PersonalBest(
+			l.Users[0].Name(),
+			l.Game.boardKey(),
+		)
+		if found && len(best.Path) > 0 {
+			l.Game.Game.Ghost = best.Path
+			// Tick-based games are re-broadcast every tick anyway.
+			if l.Rules.TickInterval == 0 {
+				go l.Game.runGhost()
+			}
+		}
+	}
+
+	if l.Rules.TickInterval > 0 {
+		go l.Game.runTicks(l.Rules.TickInterval)
+	} else {
+		if l.Rules.Objective.timed() {
+			go l.Game.runObjective()
+		}
+		if l.Rules.Shift > 0 {
+			go l.Game.runShifts(l.Rules.Shift)
+		}
+		if l.Rules.TimeLimit > 0 || l.Rules.Grace > 0 {
+			go l.Game.runClock()
+		}
+	}
+}
+
+// board builds the board for the next game, along with the seed it's played
+// with: the stored maze the rules name, if any, or else a board generated
+// from the spec. This assumes the mutex is already locked.
+func (l *Lobby) board() (Board, int64) {
+	if l.Rules.Maze != "" && l.Mazes != nil {
+		if maze, found := l.Mazes.Get(l.Rules.Maze); found {
+			return maze.Board(), maze.Seed()
+		}
+	}
+	return l.Spec.Generate(), l.Spec.Seed
 }
@@ -46,6 +46,14 @@ func (rww *responseWriterWrapper) WriteHeader(s int) {
 	rww.w.WriteHeader(s)
 }
 
+// Flush passes flushes through to the response writer, if it supports them,
+// so responses can be streamed.
+func (rww *responseWriterWrapper) Flush() {
+	if flusher, ok := rww.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 type hijackingResponseWriterWrapper struct {
 	responseWriterWrapper
 }
@@ -58,7 +66,8 @@ func (hrrw *hijackingResponseWriterWrapper) Hijack() (
 	return hrrw.responseWriterWrapper.w.(http.Hijacker).Hijack()
 }
 
-func HTTPHandlerFunc(out io.Writer, hf HandlerFunc) http.HandlerFunc {
+// logSerializer returns a channel whose values are written to `out` as JSON.
+func logSerializer(out io.Writer) chan<- interface{} {
 	// Spin up a separate goroutine for serialization so as to not stall
 	// the requests. The input channel holds 1024 objects before blocking, so
 	// this should give us some runway.
@@ -72,7 +81,12 @@ func HTTPHandlerFunc(out io.Writer, hf HandlerFunc) http.HandlerFunc {
 			}
 		}
 	}()
+	metrics.AddLogQueue(serializer)
+	return serializer
+}
 
+func HTTPHandlerFunc(out io.Writer, hf HandlerFunc) http.HandlerFunc {
+	serializer := logSerializer(out)
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := Logger{}
 
@@ -1,9 +1,16 @@
+// From Go 1.24, rand.Seed does nothing unless randseednop is off, and boards
+// are generated from a seed by seeding the global source (see GenerateBoard).
+// Older toolchains take this for a comment.
+//go:debug randseednop=0
+
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 
@@ -37,13 +44,123 @@ func fileHandler(path string) http.HandlerFunc {
 }
 
 func main() {
+	runCommand(os.Args[1:])
+
+	var dailyConfig DailyConfig
+	flag.IntVar(
+		&dailyConfig.Width,
+		"daily-width",
+		boardWidth,
+		"width in cells of new daily challenge boards",
+	)
+	flag.IntVar(
+		&dailyConfig.Height,
+		"daily-height",
+		boardHeight,
+		"height in cells of new daily challenge boards",
+	)
+	flag.StringVar(
+		&dailyConfig.Generator,
+		"daily-generator",
+		defaultGenerator,
+		"generator for new daily challenge boards",
+	)
+	telnetAddr := flag.String(
+		"telnet",
+		"",
+		"address to serve the game over telnet on (e.g. :2323), if any",
+	)
+	flag.Parse()
+	if err := (BoardSpec{
+		Width:     dailyConfig.Width,
+		Height:    dailyConfig.Height,
+		Generator: dailyConfig.Generator,
+	}).Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid daily challenge config:", err)
+		os.Exit(1)
+	}
+
+	results, err := OpenResults("./results.jsonl")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening results:", err)
+		os.Exit(1)
+	}
+	daily, err := OpenDaily("./daily.jsonl", dailyConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening daily attempts:", err)
+		os.Exit(1)
+	}
+	botAccounts, err := OpenBotAccounts("./bots.jsonl")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening bot accounts:", err)
+		os.Exit(1)
+	}
+	playerAccounts, err := OpenPlayerAccounts("./players.jsonl")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening player accounts:", err)
+		os.Exit(1)
+	}
+	mazes, err := OpenMazeLibrary("./mazes.jsonl")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening maze library:", err)
+		os.Exit(1)
+	}
+
 	r := mux.NewRouter()
-	server := Server{}
+	server := Server{
+		GameManager: GameManager{
+			Results: results,
+			Daily:   daily,
+			Mazes:   mazes,
+			Logs:    logSerializer(os.Stderr),
+		},
+		BotAccounts:    botAccounts,
+		PlayerAccounts: playerAccounts,
+		Mazes:          mazes,
+	}
 	r.Path("/stats-socket/").HandlerFunc(handler(server.Stats))
 	r.Path("/user-socket/").HandlerFunc(handler(server.User))
+	r.Path("/user-events/").Methods("GET").HandlerFunc(
+		handler(server.UserEvents),
+	)
+	r.Path("/user-events/{token}/").Methods("POST").HandlerFunc(
+		handler(server.UserCommand),
+	)
+	r.Path("/bot-socket/").HandlerFunc(handler(server.Bot))
+	r.Path("/bot-accounts/").Methods("POST").HandlerFunc(
+		handler(server.RegisterBot),
+	)
+	r.Path("/player-accounts/").Methods("POST").HandlerFunc(
+		handler(server.RegisterPlayer),
+	)
+	r.Path("/leaderboards/{kind}/").HandlerFunc(handler(server.Leaderboard))
+	r.Path("/leaderboards/{kind}/{key:.+}/").HandlerFunc(
+		handler(server.Leaderboard),
+	)
+	r.Path("/mazes/").Methods("POST").HandlerFunc(handler(server.UploadMaze))
+	r.Path("/mazes/").Methods("GET").HandlerFunc(handler(server.MazeList))
+	r.Path("/mazes/{name}/").HandlerFunc(handler(server.Maze))
+	r.Path("/render/{matchID}/").HandlerFunc(handler(server.Render))
+	r.Path("/daily/").HandlerFunc(handler(server.Daily))
+	r.Path("/daily/archive/").HandlerFunc(handler(server.DailyArchive))
+	r.Path("/metrics").HandlerFunc(handler(server.Metrics))
 	r.Path("/stats/").HandlerFunc(fileHandler("./stats.html"))
 	r.Path("/").HandlerFunc(fileHandler("./index.html"))
 
+	if *telnetAddr != "" {
+		listener, err := net.Listen("tcp", *telnetAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error listening for telnet:", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := server.ServeTelnet(listener, os.Stderr); err != nil {
+				fmt.Fprintln(os.Stderr, "Error serving telnet:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	log.Println("Listening!")
 	if false { // TODO: Re-enable this once TLS is working
 		if err := http.Serve(
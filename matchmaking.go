@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// minPlayers is how many players a queued lobby needs before the queue
+	// timeout will start the game without waiting for it to fill.
+	minPlayers = 2
+
+	// queueTimeout is how long a lobby waits to fill before starting with
+	// however many players it has (provided it has at least minPlayers).
+	queueTimeout = 30 * time.Second
+
+	// fillTimeWeight is how much each newly filled lobby moves the average
+	// fill time, so the ETA tracks recent traffic.
+	fillTimeWeight = 0.2
+)
+
+// FillTimes tracks how long lobbies take to fill so queued players can be given
+// an estimate of their remaining wait.
+type FillTimes struct {
+	Mutex   sync.Mutex
+	Average time.Duration
+	Samples int
+}
+
+// Record folds the time it took a lobby to fill into the running average.
+func (ft *FillTimes) Record(d time.Duration) {
+	ft.Mutex.Lock()
+	defer ft.Mutex.Unlock()
+	if ft.Samples < 1 {
+		ft.Average = d
+	} else {
+		ft.Average += time.Duration(fillTimeWeight * float64(d-ft.Average))
+	}
+	ft.Samples++
+}
+
+// ETA estimates how much longer a lobby that has waited for `waited` will
+// take to start. Without any samples, the queue timeout is the best guess.
+func (ft *FillTimes) ETA(waited time.Duration) time.Duration {
+	ft.Mutex.Lock()
+	defer ft.Mutex.Unlock()
+	expected := queueTimeout
+	if ft.Samples > 0 && ft.Average < expected {
+		expected = ft.Average
+	}
+	if waited >= expected {
+		return 0
+	}
+	return expected - waited
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testParticipant is a person sitting in a lobby who ignores whatever they're
+// sent.
+type testParticipant struct{ name string }
+
+func (p *testParticipant) Name() string                { return p.name }
+func (p *testParticipant) GameStart(PlayerSession)     {}
+func (p *testParticipant) NotifyUserState(UserState)   {}
+func (p *testParticipant) ClearGame()                  {}
+func (p *testParticipant) Logf(string, ...interface{}) {}
+
+func TestFillTimesRecord(t *testing.T) {
+	var ft FillTimes
+	ft.Record(10 * time.Second)
+	if ft.Average != 10*time.Second {
+		t.Fatalf("Wanted the first sample as the average; got %v", ft.Average)
+	}
+	ft.Record(20 * time.Second)
+	if want := 12 * time.Second; ft.Average != want {
+		t.Fatalf("Wanted average %v; got %v", want, ft.Average)
+	}
+}
+
+func TestFillTimesETA(t *testing.T) {
+	for _, testCase := range []struct {
+		name    string
+		samples []time.Duration
+		waited  time.Duration
+		want    time.Duration
+	}{{
+		name:   "no-samples",
+		waited: 10 * time.Second,
+		want:   queueTimeout - 10*time.Second,
+	}, {
+		name:   "no-samples-timed-out",
+		waited: queueTimeout + time.Second,
+		want:   0,
+	}, {
+		name:    "fills-sooner",
+		samples: []time.Duration{12 * time.Second},
+		waited:  5 * time.Second,
+		want:    7 * time.Second,
+	}, {
+		name:    "fills-later",
+		samples: []time.Duration{2 * queueTimeout},
+		waited:  5 * time.Second,
+		want:    queueTimeout - 5*time.Second,
+	}, {
+		name:    "overdue",
+		samples: []time.Duration{12 * time.Second},
+		waited:  15 * time.Second,
+		want:    0,
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			var ft FillTimes
+			for _, sample := range testCase.samples {
+				ft.Record(sample)
+			}
+			if eta := ft.ETA(testCase.waited); eta != testCase.want {
+				t.Fatalf("Wanted ETA %v; got %v", testCase.want, eta)
+			}
+		})
+	}
+}
+
+func TestLobbyTimeout(t *testing.T) {
+	for _, testCase := range []struct {
+		name    string
+		size    int
+		humans  int
+		rules   Rules
+		started bool
+		bots    int
+		level   BotLevel
+	}{{
+		name: "empty",
+		size: 2,
+	}, {
+		name:    "partial",
+		size:    4,
+		humans:  minPlayers,
+		started: true,
+	}, {
+		name:    "filled-with-bots",
+		size:    2,
+		humans:  minPlayers - 1,
+		started: true,
+		bots:    1,
+		level:   defaultBotLevel,
+	}, {
+		name:    "filled-with-chosen-bots",
+		size:    2,
+		humans:  minPlayers - 1,
+		rules:   Rules{Bots: "tremaux"},
+		started: true,
+		bots:    1,
+		level:   BotTremaux,
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			l := &Lobby{
+				MaxSize: testCase.size,
+				Created: time.Now(),
+				Votes:   map[Participant]bool{},
+				Ratings: map[Participant]float64{},
+				Spec:    DefaultBoardSpec(1),
+				Rules:   testCase.rules,
+			}
+			for i := 0; i < testCase.humans; i++ {
+				user := &testParticipant{name: string('a' + rune(i))}
+				l.Add(user, initialRating)
+			}
+			l.timeout()
+			defer l.DropBots()
+
+			if started := l.Game != nil; started != testCase.started {
+				t.Fatalf(
+					"Wanted started %t; got %t",
+					testCase.started,
+					started,
+				)
+			}
+			if bots := len(l.Users) - l.Humans(); bots != testCase.bots {
+				t.Fatalf("Wanted %d bots; got %d", testCase.bots, bots)
+			}
+			for _, user := range l.Users {
+				if bot, ok := user.(*Bot); ok && bot.Level != testCase.level {
+					t.Fatalf(
+						"Wanted %s bots; got %s",
+						testCase.level,
+						bot.Level,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestGameManagerStateRace(t *testing.T) {
+	// Lobbies change under their own lock, not the manager's, so reading
+	// their state mustn't race with people coming and going (go test -race).
+	l := &Lobby{
+		MaxSize: 4,
+		Created: time.Now(),
+		Votes:   map[Participant]bool{},
+		Ratings: map[Participant]float64{},
+		Spec:    DefaultBoardSpec(1),
+	}
+	gm := GameManager{Lobbies: []*Lobby{l}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		user := &testParticipant{name: "alice"}
+		for i := 0; i < 100; i++ {
+			l.Add(user, initialRating)
+			l.Drop(user)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if lobbies := gm.State(); len(lobbies) != 1 {
+			t.Fatalf("Wanted 1 lobby; got %d", len(lobbies))
+		}
+	}
+	<-done
+}
+
+func TestBotLogs(t *testing.T) {
+	logs := make(chan interface{}, 2)
+	l := &Lobby{
+		MaxSize: 2,
+		Votes:   map[Participant]bool{},
+		Ratings: map[Participant]float64{},
+		Spec:    DefaultBoardSpec(1),
+		Logs:    logs,
+	}
+	l.AddBots(BotRandom, 2)
+	l.Users[0].Logf("Error recording solve: %v", "disk full")
+	l.DropBots()
+
+	// Only bots that logged anything are written out.
+	select {
+	case data := <-logs:
+		want := []interface{}{"bot:random: Error recording solve: disk full"}
+		if !reflect.DeepEqual(data, want) {
+			t.Fatalf("Wanted %v; got %v", want, data)
+		}
+	default:
+		t.Fatal("Wanted the bot's log written once it stopped")
+	}
+	if len(logs) != 0 {
+		t.Fatalf("Wanted a single log; got %v", <-logs)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits on uploaded mazes. Sizes are in tiles.
+const (
+	maxMazeBytes   = 64 * 1024
+	maxMazeName    = 64
+	minMazeSize    = 3
+	maxMazeSize    = 201
+	defaultAuthor  = "anonymous"
+	mazeNameDenied = " \t\r\n/?&#=%"
+)
+
+var (
+	ErrInvalidMazeName = errors.New("Maze names must be 1 to 64 characters " +
+		"long and contain no whitespace or any of /?&#=%")
+	ErrMazeNameTaken = errors.New("Maze name already taken")
+)
+
+// InvalidMazeError is returned for uploaded mazes that can't be played.
+type InvalidMazeError struct {
+	Err error
+}
+
+func (err InvalidMazeError) Error() string {
+	return "Invalid maze: " + err.Err.Error()
+}
+
+// StoredMaze is a hand-made maze in the library, in the same text format
+// ParseBoard reads. Mazes are stored as they were parsed, so any whitespace
+// the lenient parse tidied up is gone.
+type StoredMaze struct {
+	Name     string    `json:"name"`
+	Author   string    `json:"author"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	Shortest int       `json:"shortest"`
+	Uploaded time.Time `json:"uploaded"`
+	Maze     string    `json:"maze,omitempty"`
+}
+
+// Board parses the maze, which was validated when it was uploaded.
+func (m StoredMaze) Board() Board {
+	board, err := ParseBoard(strings.NewReader(m.Maze))
+	if err != nil {
+		panic("Error parsing stored maze: " + err.Error())
+	}
+	return board
+}
+
+// Seed identifies the maze wherever boards are identified by their seed, such
+// as personal bests. It's derived from the maze itself, so it never changes.
+func (m StoredMaze) Seed() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(m.Maze))
+	return int64(h.Sum64())
+}
+
+// ValidateMaze decodes an uploaded maze (leniently, if it's text) and checks
+// that it's a sensible size and can be solved.
+func ValidateMaze(data []byte, format BoardFormat) (Board, error) {
+	board, err := DecodeBoard(
+		bytes.NewReader(data),
+		format,
+		ParseOptions{Lenient: true},
+	)
+	if err != nil {
+		return Board{}, InvalidMazeError{err}
+	}
+	w, h := board.Width(), board.Height()
+	if w < minMazeSize || h < minMazeSize ||
+		w > maxMazeSize || h > maxMazeSize {
+		return Board{}, InvalidMazeError{fmt.Errorf(
+			"Wanted between %dx%d and %dx%d tiles; got %dx%d",
+			minMazeSize,
+			minMazeSize,
+			maxMazeSize,
+			maxMazeSize,
+			w,
+			h,
+		)}
+	}
+	if !board.Solvable() {
+		return Board{}, InvalidMazeError{
+			errors.New("The end can't be reached from the start"),
+		}
+	}
+	return board, nil
+}
+
+// MazeLibrary is the store of uploaded mazes. Mazes are appended (as JSON
+// lines) to a file so they survive restarts.
+type MazeLibrary struct {
+	Mutex sync.RWMutex
+	Mazes map[string]StoredMaze // by name
+	file  *os.File
+}
+
+// OpenMazeLibrary loads any previously uploaded mazes from `path` and opens it
+// for appending.
+func OpenMazeLibrary(path string) (*MazeLibrary, error) {
+	library := &MazeLibrary{Mazes: map[string]StoredMaze{}}
+	file, err := openJSONLines(path, library.load)
+	if err != nil {
+		return nil, err
+	}
+	library.file = file
+	return library, nil
+}
+
+// ReadMazeLibrary loads the mazes stored at `path` without opening it for
+// appending. Mazes added to the returned library are kept in memory only.
+func ReadMazeLibrary(path string) (*MazeLibrary, error) {
+	library := &MazeLibrary{Mazes: map[string]StoredMaze{}}
+	if err := readJSONLines(path, library.load); err != nil {
+		return nil, err
+	}
+	return library, nil
+}
+
+// load adds a stored maze while the library is being loaded.
+func (ml *MazeLibrary) load(data []byte) error {
+	var maze StoredMaze
+	if err := json.Unmarshal(data, &maze); err != nil {
+		return err
+	}
+	ml.Mazes[maze.Name] = maze
+	return nil
+}
+
+// Add validates an uploaded maze, written in `format`, and stores it as text
+// under `name`, which must not be taken yet.
+func (ml *MazeLibrary) Add(
+	name string,
+	author string,
+	format BoardFormat,
+	data []byte,
+) (
+	StoredMaze,
+	error,
+) {
+	if name == "" || len(name) > maxMazeName ||
+		strings.ContainsAny(name, mazeNameDenied) {
+		return StoredMaze{}, ErrInvalidMazeName
+	}
+	if author == "" {
+		author = defaultAuthor
+	}
+	board, err := ValidateMaze(data, format)
+	if err != nil {
+		return StoredMaze{}, err
+	}
+
+	ml.Mutex.Lock()
+	defer ml.Mutex.Unlock()
+	if _, found := ml.Mazes[name]; found {
+		return StoredMaze{}, ErrMazeNameTaken
+	}
+	maze := StoredMaze{
+		Name:     name,
+		Author:   author,
+		Width:    board.Width(),
+		Height:   board.Height(),
+		Shortest: board.ShortestPathLength(),
+		Uploaded: time.Now(),
+		Maze:     board.Text(),
+	}
+	if ml.file != nil {
+		if err := appendJSONLine(ml.file, maze); err != nil {
+			return StoredMaze{}, err
+		}
+	}
+	ml.Mazes[name] = maze
+	return maze, nil
+}
+
+// Get returns the maze stored under `name`.
+func (ml *MazeLibrary) Get(name string) (StoredMaze, bool) {
+	ml.Mutex.RLock()
+	defer ml.Mutex.RUnlock()
+	maze, found := ml.Mazes[name]
+	return maze, found
+}
+
+// List returns every maze in the library, by name, without the mazes
+// themselves.
+func (ml *MazeLibrary) List() []StoredMaze {
+	ml.Mutex.RLock()
+	defer ml.Mutex.RUnlock()
+	mazes := make([]StoredMaze, 0, len(ml.Mazes))
+	for _, maze := range ml.Mazes {
+		maze.Maze = ""
+		mazes = append(mazes, maze)
+	}
+	sort.Slice(mazes, func(i, j int) bool {
+		return mazes[i].Name < mazes[j].Name
+	})
+	return mazes
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// metrics counts what the server is up to, for the /metrics endpoint.
+var metrics = NewMetrics()
+
+// Metrics are the counts served at /metrics in Prometheus's text format.
+// Lobbies by state are read off the game manager as they're served, rather
+// than counted.
+type Metrics struct {
+	Connections          *CounterVec // open, by kind; a gauge
+	GamesStarted         Counter
+	GamesFinished        Counter
+	Moves                Counter
+	WebsocketWriteErrors *CounterVec // by socket
+	BroadcastLatency     *Histogram
+	FillTime             *Histogram
+
+	lock      sync.Mutex
+	logQueues []chan interface{}
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Connections: NewCounterVec(
+			"user-socket",
+			"user-events",
+			"telnet",
+			"bot-socket",
+			"stats-socket",
+		),
+		WebsocketWriteErrors: NewCounterVec("user", "bot", "stats"),
+		BroadcastLatency: NewHistogram(
+			.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1,
+		),
+		FillTime: NewHistogram(1, 2, 5, 10, 15, 20, 25, 30, 45, 60),
+	}
+}
+
+// Connect counts a connection of the given kind as open until the returned
+// func is called.
+func (m *Metrics) Connect(kind string) func() {
+	connections := m.Connections.With(kind)
+	connections.Add(1)
+	return func() { connections.Add(-1) }
+}
+
+// AddLogQueue adds a log serializer's channel to those whose depth is served.
+func (m *Metrics) AddLogQueue(queue chan interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.logQueues = append(m.logQueues, queue)
+}
+
+// logQueueDepth returns how many log entries are waiting to be written, across
+// every serializer, and how many they can hold.
+func (m *Metrics) logQueueDepth() (int, int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var depth, capacity int
+	for _, queue := range m.logQueues {
+		depth += len(queue)
+		capacity += cap(queue)
+	}
+	return depth, capacity
+}
+
+// Write writes every metric in Prometheus's text format, along with how many
+// of `lobbies` are in each state.
+func (m *Metrics) Write(w io.Writer, lobbies []LobbyState) error {
+	buf := bufio.NewWriter(w)
+
+	writeHeader(buf, "maze_connections", "gauge", "Open connections, by kind.")
+	m.Connections.write(buf, "maze_connections", "kind")
+
+	states := map[string]int{"filling": 0, "in_progress": 0, "over": 0}
+	for _, lobby := range lobbies {
+		switch {
+		case lobby.Over:
+			states["over"]++
+		case lobby.InProgress:
+			states["in_progress"]++
+		default:
+			states["filling"]++
+		}
+	}
+	writeHeader(buf, "maze_lobbies", "gauge", "Lobbies, by state.")
+	for _, state := range sortedKeys(states) {
+		fmt.Fprintf(
+			buf,
+			"maze_lobbies{state=%q} %d\n",
+			state,
+			states[state],
+		)
+	}
+
+	writeHeader(
+		buf,
+		"maze_games_started_total",
+		"counter",
+		"Games started, rematches included.",
+	)
+	fmt.Fprintf(buf, "maze_games_started_total %d\n", m.GamesStarted.Value())
+	writeHeader(buf, "maze_games_finished_total", "counter", "Games ended.")
+	fmt.Fprintf(buf, "maze_games_finished_total %d\n", m.GamesFinished.Value())
+	writeHeader(
+		buf,
+		"maze_moves_total",
+		"counter",
+		"Moves made (or queued, in tick-based games) by players and bots.",
+	)
+	fmt.Fprintf(buf, "maze_moves_total %d\n", m.Moves.Value())
+
+	writeHeader(
+		buf,
+		"maze_websocket_write_errors_total",
+		"counter",
+		"Errors writing to websockets, by socket.",
+	)
+	m.WebsocketWriteErrors.write(
+		buf,
+		"maze_websocket_write_errors_total",
+		"socket",
+	)
+
+	writeHeader(
+		buf,
+		"maze_broadcast_duration_seconds",
+		"histogram",
+		"Time taken to send a lobby or game's state to all its players.",
+	)
+	m.BroadcastLatency.write(buf, "maze_broadcast_duration_seconds")
+	writeHeader(
+		buf,
+		"maze_lobby_fill_seconds",
+		"histogram",
+		"Time taken for lobbies to start their first game.",
+	)
+	m.FillTime.write(buf, "maze_lobby_fill_seconds")
+
+	depth, capacity := m.logQueueDepth()
+	writeHeader(
+		buf,
+		"maze_log_queue_depth",
+		"gauge",
+		"Request logs waiting to be written.",
+	)
+	fmt.Fprintf(buf, "maze_log_queue_depth %d\n", depth)
+	writeHeader(
+		buf,
+		"maze_log_queue_capacity",
+		"gauge",
+		"Request logs that can wait before requests block.",
+	)
+	fmt.Fprintf(buf, "maze_log_queue_capacity %d\n", capacity)
+	return buf.Flush()
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a count that's safe to update concurrently.
+type Counter struct {
+	lock sync.Mutex
+	n    int64
+}
+
+func (c *Counter) Add(delta int64) {
+	c.lock.Lock()
+	c.n += delta
+	c.lock.Unlock()
+}
+
+func (c *Counter) Inc() { c.Add(1) }
+
+func (c *Counter) Value() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.n
+}
+
+// CounterVec is a set of counters told apart by the value of a label. The
+// values it's created with are served even while they're zero.
+type CounterVec struct {
+	lock     sync.Mutex
+	counters map[string]*Counter
+}
+
+func NewCounterVec(values ...string) *CounterVec {
+	cv := &CounterVec{counters: map[string]*Counter{}}
+	for _, value := range values {
+		cv.counters[value] = &Counter{}
+	}
+	return cv
+}
+
+// With returns the counter for the label's value.
+func (cv *CounterVec) With(value string) *Counter {
+	cv.lock.Lock()
+	defer cv.lock.Unlock()
+	counter, found := cv.counters[value]
+	if !found {
+		counter = &Counter{}
+		cv.counters[value] = counter
+	}
+	return counter
+}
+
+func (cv *CounterVec) write(w io.Writer, name, label string) {
+	cv.lock.Lock()
+	values := make([]string, 0, len(cv.counters))
+	for value := range cv.counters {
+		values = append(values, value)
+	}
+	cv.lock.Unlock()
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(
+			w,
+			"%s{%s=%q} %d\n",
+			name,
+			label,
+			value,
+			cv.With(value).Value(),
+		)
+	}
+}
+
+// Histogram counts durations into buckets, bounded above by seconds.
+type Histogram struct {
+	lock   sync.Mutex
+	bounds []float64
+	counts []uint64 // by bucket, plus one for everything past the last
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with buckets bounded by `bounds`, which
+// must be in increasing order.
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.counts[sort.SearchFloat64s(h.bounds, seconds)]++
+	h.sum += seconds
+	h.count++
+}
+
+// ObserveSince observes the time since `start`, for deferring.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// write writes the histogram's buckets, which Prometheus wants cumulative,
+// and its sum and count.
+func (h *Histogram) write(w io.Writer, name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		bound := math.Inf(1)
+		if i < len(h.bounds) {
+			bound = h.bounds[i]
+		}
+		fmt.Fprintf(
+			w,
+			"%s_bucket{le=%q} %d\n",
+			name,
+			formatFloat(bound),
+			cumulative,
+		)
+	}
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// formatFloat formats a float the way Prometheus does, `+Inf` included.
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
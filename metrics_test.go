@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramWrite(t *testing.T) {
+	h := NewHistogram(1, 2.5)
+	for _, d := range []time.Duration{
+		500 * time.Millisecond,
+		time.Second,
+		2 * time.Second,
+		time.Minute,
+	} {
+		h.Observe(d)
+	}
+	var buf bytes.Buffer
+	h.write(&buf, "test_seconds")
+	want := `test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="2.5"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 63.5
+test_seconds_count 4
+`
+	if buf.String() != want {
+		t.Fatalf("Wanted:\n%s\nGot:\n%s", want, buf.String())
+	}
+}
+
+func TestCounterVecWrite(t *testing.T) {
+	cv := NewCounterVec("b", "a")
+	cv.With("b").Inc()
+	cv.With("c").Add(3)
+	var buf bytes.Buffer
+	cv.write(&buf, "test_total", "kind")
+	want := `test_total{kind="a"} 0
+test_total{kind="b"} 1
+test_total{kind="c"} 3
+`
+	if buf.String() != want {
+		t.Fatalf("Wanted:\n%s\nGot:\n%s", want, buf.String())
+	}
+}
+
+func TestMetricsWrite(t *testing.T) {
+	m := NewMetrics()
+	disconnect := m.Connect("telnet")
+	m.Connect("telnet")
+	disconnect()
+	m.GamesStarted.Inc()
+	m.Moves.Add(7)
+	m.AddLogQueue(make(chan interface{}, 8))
+
+	var buf bytes.Buffer
+	if err := m.Write(&buf, []LobbyState{
+		{},
+		{InProgress: true},
+		{InProgress: true},
+		{InProgress: true, Over: true},
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`maze_connections{kind="telnet"} 1`,
+		`maze_connections{kind="bot-socket"} 0`,
+		`maze_lobbies{state="filling"} 1`,
+		`maze_lobbies{state="in_progress"} 2`,
+		`maze_lobbies{state="over"} 1`,
+		"maze_games_started_total 1",
+		"maze_games_finished_total 0",
+		"maze_moves_total 7",
+		`maze_broadcast_duration_seconds_bucket{le="+Inf"} 0`,
+		"maze_log_queue_capacity 8",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("Wanted %s in:\n%s", want, out)
+		}
+	}
+
+	// Every sample follows the HELP and TYPE of the metric it belongs to.
+	var metric string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			metric = fields[2]
+		case strings.HasPrefix(line, "# TYPE "):
+			if fields[2] != metric {
+				t.Fatalf("Wanted TYPE after HELP for %s; got %s", metric, line)
+			}
+		case len(fields) != 2 || !strings.HasPrefix(fields[0], metric):
+			t.Fatalf("Wanted a sample of %s; got %s", metric, line)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	objectiveExit         = "exit"
+	objectiveCheckpoints  = "checkpoints"
+	objectiveCoins        = "coins"
+	objectiveHill         = "hill"
+	objectiveLastStanding = "last-standing"
+)
+
+// Characters marking the tiles an objective cares about in players' windows
+const (
+	markCheckpoint = '!'
+	markCoin       = 'o'
+	markHill       = '^'
+)
+
+var markNames = map[rune]string{
+	markCheckpoint: "checkpoint",
+	markCoin:       "coin",
+	markHill:       "hill",
+}
+
+// Bounds and defaults for the objectives' settings
+const (
+	minObjectiveCount  = 1
+	maxObjectiveCount  = 10
+	defaultCheckpoints = 3
+	defaultCoins       = 5
+
+	minHold     = time.Second
+	maxHold     = time.Minute
+	defaultHold = 10 * time.Second
+)
+
+// objectiveInterval is how often a real-time game with a timed objective is
+// updated (and re-broadcast) between moves.
+const objectiveInterval = 100 * time.Millisecond
+
+// Objective decides when players have finished a game and who has won. Games
+// consult it after every move and as time passes, so a new way of winning only
+// needs a new Objective. Objectives keep no state of their own; progress is
+// kept in the Game and its Players.
+type Objective interface {
+	// Setup prepares a game once its players have joined, e.g. by placing
+	// checkpoints on the board.
+	Setup(g Game) Game
+
+	// Moved is called after player `pid` has moved.
+	Moved(g Game, pid rune) Game
+
+	// Update is called as time passes and after players leave.
+	Update(g Game) Game
+
+	// Marks returns the tiles to draw in player `pid`'s window, such as the
+	// checkpoints they still have to visit.
+	Marks(g Game, pid rune) map[Point]rune
+}
+
+// ObjectiveRule describes a game's objective. It is part of the lobby's rules,
+// so it must stay comparable.
+type ObjectiveRule struct {
+	Kind    string        `json:"kind,omitempty"`    // exit if empty
+	Count   int           `json:"count,omitempty"`   // checkpoints or coins
+	Ordered bool          `json:"ordered,omitempty"` // checkpoints in order
+	Hold    time.Duration `json:"hold,omitempty"`    // time to hold the hill
+}
+
+var objectives = map[string]func(rule ObjectiveRule) Objective{
+	objectiveExit: func(ObjectiveRule) Objective { return ExitObjective{} },
+	objectiveCheckpoints: func(rule ObjectiveRule) Objective {
+		return CheckpointObjective{Count: rule.Count, Ordered: rule.Ordered}
+	},
+	objectiveCoins: func(rule ObjectiveRule) Objective {
+		return CoinObjective{Count: rule.Count}
+	},
+	objectiveHill: func(rule ObjectiveRule) Objective {
+		return HillObjective{Hold: rule.Hold}
+	},
+	objectiveLastStanding: func(ObjectiveRule) Objective {
+		return LastStandingObjective{}
+	},
+}
+
+// Objective builds the objective; the rule must be valid.
+func (rule ObjectiveRule) Objective() Objective {
+	if rule.Kind == "" {
+		return ExitObjective{}
+	}
+	newObjective, found := objectives[rule.Kind]
+	if !found {
+		panic("Unknown objective: " + rule.Kind)
+	}
+	return newObjective(rule)
+}
+
+// timed reports whether the objective changes as time passes, in which case
+// real-time games have to update it between moves.
+func (rule ObjectiveRule) timed() bool {
+	return rule.Kind == objectiveHill
+}
+
+// queryObjectiveRule returns the objective the player asked for with the
+// `objective` query parameter and its settings: `count` and `ordered` for
+// checkpoints, `count` for coins and `hold` for the hill.
+func queryObjectiveRule(query url.Values) (ObjectiveRule, error) {
+	kind := query.Get("objective")
+	if kind == "" || kind == objectiveExit {
+		return ObjectiveRule{}, nil
+	}
+	if _, found := objectives[kind]; !found {
+		return ObjectiveRule{}, fmt.Errorf("Unknown objective: %s", kind)
+	}
+	rule := ObjectiveRule{Kind: kind}
+
+	switch kind {
+	case objectiveCheckpoints, objectiveCoins:
+		rule.Count = defaultCheckpoints
+		if kind == objectiveCoins {
+			rule.Count = defaultCoins
+		}
+		if value := query.Get("count"); value != "" {
+			count, err := strconv.Atoi(value)
+			if err != nil {
+				return ObjectiveRule{}, err
+			}
+			if count < minObjectiveCount || count > maxObjectiveCount {
+				return ObjectiveRule{}, fmt.Errorf(
+					"Wanted count between %d and %d; got %d",
+					minObjectiveCount,
+					maxObjectiveCount,
+					count,
+				)
+			}
+			rule.Count = count
+		}
+	case objectiveHill:
+		rule.Hold = defaultHold
+		if value := query.Get("hold"); value != "" {
+			hold, err := time.ParseDuration(value)
+			if err != nil {
+				return ObjectiveRule{}, err
+			}
+			if hold < minHold || hold > maxHold {
+				return ObjectiveRule{}, fmt.Errorf(
+					"Wanted hold between %v and %v; got %v",
+					minHold,
+					maxHold,
+					hold,
+				)
+			}
+			rule.Hold = hold
+		}
+	}
+
+	if kind == objectiveCheckpoints {
+		if value := query.Get("ordered"); value != "" {
+			ordered, err := strconv.ParseBool(value)
+			if err != nil {
+				return ObjectiveRule{}, err
+			}
+			rule.Ordered = ordered
+		}
+	}
+	return rule, nil
+}
+
+// Progress is a player's progress towards the objective, as shown to them.
+type Progress struct {
+	Checkpoints int           `json:"checkpoints,omitempty"` // visited
+	Coins       int           `json:"coins,omitempty"`
+	Held        time.Duration `json:"held,omitempty"`
+	Out         bool          `json:"out,omitempty"`
+}
+
+func (p Player) Progress() Progress {
+	return Progress{
+		Checkpoints: len(p.Visited),
+		Coins:       p.Coins,
+		Held:        p.Held,
+		Out:         p.Out,
+	}
+}
+
+// ExitObjective is the classic race: a player finishes by reaching the end
+// and the first to finish wins.
+type ExitObjective struct{}
+
+func (ExitObjective) Setup(g Game) Game { return g }
+
+func (ExitObjective) Moved(g Game, pid rune) Game {
+	if p, _ := g.Player(pid); p.Pos == g.Board.End {
+		return g.playerFinished(pid)
+	}
+	return g
+}
+
+func (ExitObjective) Update(g Game) Game { return g }
+
+func (ExitObjective) Marks(g Game, pid rune) map[Point]rune { return nil }
+
+// CheckpointObjective has players visit every checkpoint, either in any order
+// or in sequence, before heading for the end.
+type CheckpointObjective struct {
+	Count   int
+	Ordered bool
+}
+
+func (o CheckpointObjective) Setup(g Game) Game {
+	g.Goals = g.freeTiles(o.Count)
+	return g
+}
+
+// remaining returns the checkpoints the player may visit next: all of the
+// unvisited ones, or just the next one in sequence.
+func (o CheckpointObjective) remaining(g Game, p Player) []Point {
+	if o.Ordered {
+		if len(p.Visited) < len(g.Goals) {
+			return g.Goals[len(p.Visited) : len(p.Visited)+1]
+		}
+		return nil
+	}
+	visited := map[Point]bool{}
+	for _, checkpoint := range p.Visited {
+		visited[checkpoint] = true
+	}
+	var remaining []Point
+	for _, checkpoint := range g.Goals {
+		if !visited[checkpoint] {
+			remaining = append(remaining, checkpoint)
+		}
+	}
+	return remaining
+}
+
+func (o CheckpointObjective) Moved(g Game, pid rune) Game {
+	p, _ := g.Player(pid)
+	for _, checkpoint := range o.remaining(g, p) {
+		if checkpoint == p.Pos {
+			g = g.MapPlayer(pid, func(p Player) Player {
+				visited := make([]Point, len(p.Visited), len(p.Visited)+1)
+				copy(visited, p.Visited)
+				p.Visited = append(visited, checkpoint)
+				return p
+			})
+			p, _ = g.Player(pid)
+			break
+		}
+	}
+	if len(p.Visited) == len(g.Goals) && p.Pos == g.Board.End {
+		return g.playerFinished(pid)
+	}
+	return g
+}
+
+func (CheckpointObjective) Update(g Game) Game { return g }
+
+func (o CheckpointObjective) Marks(g Game, pid rune) map[Point]rune {
+	p, _ := g.Player(pid)
+	marks := map[Point]rune{}
+	for _, checkpoint := range o.remaining(g, p) {
+		marks[checkpoint] = markCheckpoint
+	}
+	return marks
+}
+
+// CoinObjective has players collect coins before heading for the end. There
+// are enough coins for everybody, but players who have enough leave the rest
+// alone, so coins taken by one player are lost to the others.
+type CoinObjective struct {
+	Count int
+}
+
+func (o CoinObjective) Setup(g Game) Game {
+	g.Goals = g.freeTiles(o.Count * len(g.Players))
+	return g
+}
+
+func (o CoinObjective) Moved(g Game, pid rune) Game {
+	p, _ := g.Player(pid)
+	if p.Coins < o.Count {
+		for i, coin := range g.Goals {
+			if coin == p.Pos {
+				coins := make([]Point, 0, len(g.Goals)-1)
+				coins = append(coins, g.Goals[:i]...)
+				g.Goals = append(coins, g.Goals[i+1:]...)
+				g = g.MapPlayer(pid, func(p Player) Player {
+					p.Coins++
+					return p
+				})
+				p, _ = g.Player(pid)
+				break
+			}
+		}
+	}
+	if p.Coins >= o.Count && p.Pos == g.Board.End {
+		return g.playerFinished(pid)
+	}
+	return g
+}
+
+func (CoinObjective) Update(g Game) Game { return g }
+
+func (o CoinObjective) Marks(g Game, pid rune) map[Point]rune {
+	marks := map[Point]rune{}
+	if p, _ := g.Player(pid); p.Coins < o.Count {
+		for _, coin := range g.Goals {
+			marks[coin] = markCoin
+		}
+	}
+	return marks
+}
+
+// HillObjective is king of the hill: a player finishes once they have stood
+// on the hill for `Hold` in total. Time only counts while they have the hill
+// to themselves.
+type HillObjective struct {
+	Hold time.Duration
+}
+
+// Setup puts the hill on the open tile nearest the middle of the board.
+func (HillObjective) Setup(g Game) Game {
+	middle := Point{g.Board.Width() / 2, g.Board.Height() / 2}
+	distance := func(p Point) int {
+		d := p.Rel(middle)
+		if d.X < 0 {
+			d.X = -d.X
+		}
+		if d.Y < 0 {
+			d.Y = -d.Y
+		}
+		return d.X + d.Y
+	}
+	free := g.freeTiles(0)
+	if len(free) < 1 {
+		return g
+	}
+	hill := free[0]
+	for _, p := range free {
+		if distance(p) < distance(hill) {
+			hill = p
+		}
+	}
+	g.Goals = []Point{hill}
+	return g
+}
+
+func (o HillObjective) Moved(g Game, pid rune) Game { return o.Update(g) }
+
+// Update credits the time since the last update to whoever is alone on the
+// hill.
+func (o HillObjective) Update(g Game) Game {
+	now := g.Elapsed()
+	since := now - g.Checked
+	g.Checked = now
+	if len(g.Goals) < 1 {
+		return g
+	}
+
+	var holders []rune
+	for _, p := range g.Players {
+		if p.Pos == g.Goals[0] && g.active(p) {
+			holders = append(holders, p.ID)
+		}
+	}
+	if len(holders) != 1 {
+		return g
+	}
+	g = g.MapPlayer(holders[0], func(p Player) Player {
+		p.Held += since
+		return p
+	})
+	if p, _ := g.Player(holders[0]); p.Held >= o.Hold {
+		return g.playerFinished(p.ID)
+	}
+	return g
+}
+
+func (HillObjective) Marks(g Game, pid rune) map[Point]rune {
+	marks := map[Point]rune{}
+	for _, hill := range g.Goals {
+		marks[hill] = markHill
+	}
+	return marks
+}
+
+// LastStandingObjective is a race to the end in which players can tag each
+// other out by landing on them. A player finishes by reaching the end, or by
+// being the only one left once everyone else has finished or been tagged out.
+// Tagging needs players to share tiles, so it only happens when collisions
+// are off.
+type LastStandingObjective struct{}
+
+func (LastStandingObjective) Setup(g Game) Game { return g }
+
+func (o LastStandingObjective) Moved(g Game, pid rune) Game {
+	p, _ := g.Player(pid)
+	for _, other := range g.Players {
+		if other.ID != pid && other.Pos == p.Pos && g.active(other) {
+			g = g.MapPlayer(other.ID, func(p Player) Player {
+				p.Out = true
+				return p
+			})
+		}
+	}
+	if p.Pos == g.Board.End {
+		g = g.playerFinished(pid)
+	}
+	return o.Update(g)
+}
+
+func (LastStandingObjective) Update(g Game) Game {
+	var active []rune
+	var out bool
+	for _, p := range g.Players {
+		if g.active(p) {
+			active = append(active, p.ID)
+		}
+		out = out || p.Out
+	}
+	if len(active) == 1 && out {
+		return g.playerFinished(active[0])
+	}
+	return g
+}
+
+func (LastStandingObjective) Marks(g Game, pid rune) map[Point]rune {
+	return nil
+}
+
+// freeTiles picks `n` open tiles at random (or returns all of them if `n` is
+// zero), avoiding the start, the end and anything already on the board. The
+// choice depends only on the game's seed, so replays see the same tiles.
+func (g Game) freeTiles(n int) []Point {
+	taken := map[Point]bool{g.Board.Start: true, g.Board.End: true}
+	for p := range g.Items {
+		taken[p] = true
+	}
+	for _, p := range g.Goals {
+		taken[p] = true
+	}
+	var free []Point
+	for y, row := range g.Board.Rows {
+		for x, tile := range row {
+			if p := (Point{x, y}); tile != tileWall && !taken[p] {
+				free = append(free, p)
+			}
+		}
+	}
+	if n < 1 {
+		return free
+	}
+
+	if n > len(free) {
+		n = len(free)
+	}
+	picked := make([]Point, n)
+	rng := rand.New(rand.NewSource(g.Seed))
+	for i, j := range rng.Perm(len(free))[:n] {
+		picked[i] = free[j]
+	}
+	return picked
+}
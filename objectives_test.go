@@ -0,0 +1,259 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQueryObjectiveRule(t *testing.T) {
+	for _, testCase := range []struct {
+		query   string
+		want    ObjectiveRule
+		wantErr bool
+	}{
+		{query: "", want: ObjectiveRule{}},
+		{query: "objective=exit", want: ObjectiveRule{}},
+		{
+			query: "objective=checkpoints",
+			want:  ObjectiveRule{Kind: objectiveCheckpoints, Count: 3},
+		},
+		{
+			query: "objective=checkpoints&count=5&ordered=true",
+			want: ObjectiveRule{
+				Kind:    objectiveCheckpoints,
+				Count:   5,
+				Ordered: true,
+			},
+		},
+		{
+			query: "objective=coins",
+			want:  ObjectiveRule{Kind: objectiveCoins, Count: 5},
+		},
+		{
+			query: "objective=hill",
+			want:  ObjectiveRule{Kind: objectiveHill, Hold: 10 * time.Second},
+		},
+		{
+			query: "objective=hill&hold=30s",
+			want:  ObjectiveRule{Kind: objectiveHill, Hold: 30 * time.Second},
+		},
+		{
+			query: "objective=last-standing",
+			want:  ObjectiveRule{Kind: objectiveLastStanding},
+		},
+		{query: "objective=capture-the-flag", wantErr: true},
+		{query: "objective=coins&count=0", wantErr: true},
+		{query: "objective=coins&count=11", wantErr: true},
+		{query: "objective=coins&count=many", wantErr: true},
+		{query: "objective=checkpoints&ordered=maybe", wantErr: true},
+		{query: "objective=hill&hold=500ms", wantErr: true},
+		{query: "objective=hill&hold=2m", wantErr: true},
+	} {
+		t.Run(testCase.query, func(t *testing.T) {
+			query, err := url.ParseQuery(testCase.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rule, err := queryObjectiveRule(query)
+			if (err != nil) != testCase.wantErr {
+				t.Fatalf("Wanted error %t; got %v", testCase.wantErr, err)
+			}
+			if err == nil && rule != testCase.want {
+				t.Fatalf("Wanted %#v; got %#v", testCase.want, rule)
+			}
+		})
+	}
+}
+
+func TestObjectiveSetup(t *testing.T) {
+	g := newTestGame(
+		t,
+		testBoard,
+		CollisionNone,
+		map[rune]Point{'@': {1, 1}, '$': {1, 1}},
+	)
+	for _, testCase := range []struct {
+		rule  ObjectiveRule
+		goals int
+	}{
+		{ObjectiveRule{Kind: objectiveCheckpoints, Count: 3}, 3},
+		{ObjectiveRule{Kind: objectiveCoins, Count: 2}, 4}, // 2 each
+		{ObjectiveRule{Kind: objectiveHill}, 1},
+		{ObjectiveRule{Kind: objectiveLastStanding}, 0},
+	} {
+		t.Run(testCase.rule.Kind, func(t *testing.T) {
+			setup := testCase.rule.Objective().Setup(g)
+			if len(setup.Goals) != testCase.goals {
+				t.Fatalf(
+					"Wanted %d goals; got %v",
+					testCase.goals,
+					setup.Goals,
+				)
+			}
+			seen := map[Point]bool{}
+			for _, goal := range setup.Goals {
+				if seen[goal] || !g.Board.IsPath(goal) ||
+					goal == g.Board.Start || goal == g.Board.End {
+					t.Fatalf("Wanted distinct open goals; got %v", setup.Goals)
+				}
+				seen[goal] = true
+			}
+			again := testCase.rule.Objective().Setup(g)
+			if !reflect.DeepEqual(again.Goals, setup.Goals) {
+				t.Fatalf(
+					"Wanted the same goals for the same seed; got %v and %v",
+					setup.Goals,
+					again.Goals,
+				)
+			}
+		})
+	}
+}
+
+func TestCheckpointObjective(t *testing.T) {
+	// '@' passes (2, 1) and then (3, 1) on the way to the end.
+	route := []Dir{Right, Right, Right, Down, Right}
+	for _, testCase := range []struct {
+		name     string
+		ordered  bool
+		visited  []Point
+		finished bool
+	}{{
+		name:     "any-order",
+		visited:  []Point{{2, 1}, {3, 1}},
+		finished: true,
+	}, {
+		name:    "ordered",
+		ordered: true,
+		visited: []Point{{3, 1}},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			g := newTestGame(
+				t,
+				testBoard,
+				CollisionNone,
+				map[rune]Point{'@': {1, 1}},
+			)
+			g.Objective = ObjectiveRule{
+				Kind:    objectiveCheckpoints,
+				Count:   2,
+				Ordered: testCase.ordered,
+			}
+			g.Goals = []Point{{3, 1}, {2, 1}}
+			for _, dir := range route {
+				g = g.PlayerMove('@', dir)
+			}
+			p, _ := g.Player('@')
+			if !reflect.DeepEqual(p.Visited, testCase.visited) {
+				t.Fatalf(
+					"Wanted visited %v; got %v",
+					testCase.visited,
+					p.Visited,
+				)
+			}
+			if _, finished := g.SolvedTimes['@']; finished !=
+				testCase.finished {
+				t.Fatalf(
+					"Wanted finished %t; got %t",
+					testCase.finished,
+					finished,
+				)
+			}
+		})
+	}
+}
+
+func TestCoinObjective(t *testing.T) {
+	g := newTestGame(
+		t,
+		testBoard,
+		CollisionNone,
+		map[rune]Point{'@': {1, 1}, '$': {4, 2}},
+	)
+	g.Objective = ObjectiveRule{Kind: objectiveCoins, Count: 1}
+	g.Goals = []Point{{2, 1}, {3, 1}}
+
+	// '$' has no coin yet, so reaching the end doesn't finish them.
+	g = g.PlayerMove('$', Right)
+	if _, finished := g.SolvedTimes['$']; finished {
+		t.Fatal("Wanted '$' not to finish without a coin")
+	}
+
+	// '@' takes the first coin and leaves the second one alone.
+	g = g.PlayerMove('@', Right).PlayerMove('@', Right)
+	if p, _ := g.Player('@'); p.Coins != 1 {
+		t.Fatalf("Wanted '@' to have 1 coin; got %d", p.Coins)
+	}
+	if want := []Point{{3, 1}}; !reflect.DeepEqual(g.Goals, want) {
+		t.Fatalf("Wanted coins %v left; got %v", want, g.Goals)
+	}
+	if marks := g.objective().Marks(g, '@'); len(marks) > 0 {
+		t.Fatalf("Wanted no coins shown to '@'; got %v", marks)
+	}
+
+	g = g.PlayerMove('@', Right).PlayerMove('@', Down).PlayerMove('@', Right)
+	if g.Winner != '@' {
+		t.Fatalf("Wanted '@' to win; got %q", g.Winner)
+	}
+}
+
+func TestHillObjective(t *testing.T) {
+	for _, testCase := range []struct {
+		name      string
+		positions map[rune]Point
+		held      time.Duration
+		winner    rune
+	}{{
+		name:      "alone",
+		positions: map[rune]Point{'@': {3, 1}, '$': {1, 1}},
+		held:      300 * time.Millisecond,
+		winner:    '@',
+	}, {
+		name:      "shared",
+		positions: map[rune]Point{'@': {3, 1}, '$': {3, 1}},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			g := newTestGame(t, testBoard, CollisionNone, testCase.positions)
+			g.Objective = ObjectiveRule{
+				Kind: objectiveHill,
+				Hold: 300 * time.Millisecond,
+			}
+			g.Goals = []Point{{3, 1}}
+			for i := 0; i < 3; i++ {
+				g = g.Step(map[rune]Dir{})
+			}
+			if p, _ := g.Player('@'); p.Held != testCase.held {
+				t.Fatalf(
+					"Wanted '@' to hold for %v; got %v",
+					testCase.held,
+					p.Held,
+				)
+			}
+			if g.Winner != testCase.winner {
+				t.Fatalf("Wanted winner %q; got %q", testCase.winner, g.Winner)
+			}
+		})
+	}
+}
+
+func TestLastStandingObjective(t *testing.T) {
+	g := newTestGame(
+		t,
+		testBoard,
+		CollisionNone,
+		map[rune]Point{'@': {1, 1}, '$': {2, 1}},
+	)
+	g.Objective = ObjectiveRule{Kind: objectiveLastStanding}
+	g = g.PlayerMove('@', Right)
+	if p, _ := g.Player('$'); !p.Out {
+		t.Fatal("Wanted '$' tagged out")
+	}
+	if g.Winner != '@' {
+		t.Fatalf("Wanted '@' to win as the last one standing; got %q", g.Winner)
+	}
+	if moved := g.PlayerMove('$', Left); !reflect.DeepEqual(moved, g) {
+		t.Fatal("Wanted '$' unable to move once out")
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxParseErrors bounds how many errors ParseBoard collects before it gives
+// up on the rest of the board.
+const maxParseErrors = 20
+
+// ParseErrorKind says what is wrong with a board.
+type ParseErrorKind int
+
+const (
+	ParseErrorEmpty ParseErrorKind = iota
+	ParseErrorRowLength
+	ParseErrorIllegalTile
+	ParseErrorDuplicateStart
+	ParseErrorDuplicateEnd
+	ParseErrorMissingStart
+	ParseErrorMissingEnd
+	ParseErrorTeleporter
+)
+
+var parseErrorKindNames = map[ParseErrorKind]string{
+	ParseErrorEmpty:          "empty",
+	ParseErrorRowLength:      "row-length",
+	ParseErrorIllegalTile:    "illegal-tile",
+	ParseErrorDuplicateStart: "duplicate-start",
+	ParseErrorDuplicateEnd:   "duplicate-end",
+	ParseErrorMissingStart:   "missing-start",
+	ParseErrorMissingEnd:     "missing-end",
+	ParseErrorTeleporter:     "teleporter",
+}
+
+func (k ParseErrorKind) String() string {
+	if name, found := parseErrorKindNames[k]; found {
+		return name
+	}
+	return fmt.Sprintf("ParseErrorKind(%d)", int(k))
+}
+
+func (k ParseErrorKind) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.String())
+}
+
+// ParseError is a single problem with a board. Lines and columns count from
+// one; errors about the board as a whole, such as a missing start, have
+// neither.
+type ParseError struct {
+	Kind    ParseErrorKind `json:"kind"`
+	Line    int            `json:"line,omitempty"`
+	Column  int            `json:"column,omitempty"`
+	Message string         `json:"message"`
+}
+
+func (err ParseError) Error() string {
+	if err.Line < 1 {
+		return err.Message
+	}
+	return fmt.Sprintf(
+		"line %d, column %d: %s",
+		err.Line,
+		err.Column,
+		err.Message,
+	)
+}
+
+// ParseErrors is every problem ParseBoard found with a board, in the order it
+// found them.
+type ParseErrors []ParseError
+
+func (errs ParseErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ParseOptions loosen what ParseBoardOptions accepts.
+type ParseOptions struct {
+	// Lenient ignores whitespace at the end of rows and blank lines before
+	// and after the board, and pads short rows out with open tiles.
+	Lenient bool
+}
+
+// ParseBoard reads a board drawn with `#` for walls, spaces for open tiles,
+// `S` for the start, `E` for the end and the items' characters for items.
+// Every row must be the same length; lines may end in CRLF. If the board is
+// invalid the error is ParseErrors, listing every problem (up to a limit);
+// otherwise it's whatever went wrong reading `r`.
+func ParseBoard(r io.Reader) (Board, error) {
+	return ParseBoardOptions(r, ParseOptions{})
+}
+
+// ParseBoardOptions is ParseBoard with options.
+func ParseBoardOptions(r io.Reader, opts ParseOptions) (Board, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSize)
+	var rows [][]rune
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if opts.Lenient {
+			line = strings.TrimRight(line, " \t")
+		}
+		rows = append(rows, []rune(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return Board{}, err
+	}
+
+	// Lines are reported as they were read, before any leading blank lines
+	// were dropped.
+	firstLine := 1
+	if opts.Lenient {
+		for len(rows) > 0 && len(rows[0]) < 1 {
+			rows = rows[1:]
+			firstLine++
+		}
+		for len(rows) > 0 && len(rows[len(rows)-1]) < 1 {
+			rows = rows[:len(rows)-1]
+		}
+		rows = padRows(rows)
+	}
+	w := rowLength(rows)
+	if w < 1 {
+		return Board{}, ParseErrors{{
+			Kind:    ParseErrorEmpty,
+			Message: "Board is empty",
+		}}
+	}
+
+	p := parser{firstLine: firstLine}
+	var start, end *Point
+	items := map[Point]Item{}
+	teleporters := map[Item][]Point{}
+	for y, row := range rows {
+		if len(row) != w {
+			p.errorf(
+				ParseErrorRowLength,
+				Point{X: minInt(len(row), w), Y: y},
+				"Wanted row length %d; got %d",
+				w,
+				len(row),
+			)
+		}
+		for x, r := range row {
+			at := Point{X: x, Y: y}
+			switch {
+			case r == 'S':
+				if start != nil {
+					p.errorf(
+						ParseErrorDuplicateStart,
+						at,
+						"Start already found at %s",
+						p.position(*start),
+					)
+					continue
+				}
+				start = &at
+			case r == 'E':
+				if end != nil {
+					p.errorf(
+						ParseErrorDuplicateEnd,
+						at,
+						"End already found at %s",
+						p.position(*end),
+					)
+					continue
+				}
+				end = &at
+			case isItem(r):
+				items[at] = Item(r)
+				if Item(r).IsTeleporter() {
+					teleporters[Item(r)] = append(teleporters[Item(r)], at)
+				}
+				row[x] = tileSpace
+			case r != tileWall && r != tileSpace:
+				p.errorf(ParseErrorIllegalTile, at, "Illegal tile %q", r)
+			}
+		}
+	}
+
+	if start == nil {
+		p.errorf(ParseErrorMissingStart, Point{-1, -1}, "Start not found")
+	}
+	if end == nil {
+		p.errorf(ParseErrorMissingEnd, Point{-1, -1}, "End not found")
+	}
+	for item := itemTeleporterFirst; item <= itemTeleporterLast; item++ {
+		if found := teleporters[item]; len(found) > 0 && len(found) != 2 {
+			p.errorf(
+				ParseErrorTeleporter,
+				found[0],
+				"Wanted 2 teleporters marked '%c'; got %d",
+				rune(item),
+				len(found),
+			)
+		}
+	}
+	if len(p.errors) > 0 {
+		return Board{}, p.errors
+	}
+	return Board{Rows: rows, Start: *start, End: *end, Items: items}, nil
+}
+
+// parser collects the errors found while parsing a board.
+type parser struct {
+	firstLine int
+	errors    ParseErrors
+}
+
+// errorf records an error at board position `at`; a negative position means
+// the error is about the whole board. Errors past maxParseErrors are dropped.
+func (p *parser) errorf(
+	kind ParseErrorKind,
+	at Point,
+	format string,
+	v ...interface{},
+) {
+	if len(p.errors) >= maxParseErrors {
+		return
+	}
+	err := ParseError{Kind: kind, Message: fmt.Sprintf(format, v...)}
+	if at.X >= 0 && at.Y >= 0 {
+		err.Line, err.Column = p.firstLine+at.Y, at.X+1
+	}
+	p.errors = append(p.errors, err)
+}
+
+// position describes board position `at` as errors do.
+func (p *parser) position(at Point) string {
+	return fmt.Sprintf("line %d, column %d", p.firstLine+at.Y, at.X+1)
+}
+
+// rowLength returns the length most rows have, which is the length the rest
+// are wrong about. Ties go to the length that comes first. It's zero only if
+// every row is empty.
+func rowLength(rows [][]rune) int {
+	counts := map[int]int{}
+	length := 0
+	for _, row := range rows {
+		counts[len(row)]++
+		if len(row) > 0 && (length == 0 || counts[len(row)] > counts[length]) {
+			length = len(row)
+		}
+	}
+	return length
+}
+
+// padRows pads every row out to the length of the longest with open tiles.
+func padRows(rows [][]rune) [][]rune {
+	w := 0
+	for _, row := range rows {
+		if len(row) > w {
+			w = len(row)
+		}
+	}
+	for y, row := range rows {
+		for len(row) < w {
+			row = append(row, tileSpace)
+		}
+		rows[y] = row
+	}
+	return rows
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
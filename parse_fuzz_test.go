@@ -0,0 +1,28 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// FuzzParseBoard is seeded with the boards in fuzz/corpus and makes the
+// checks in checkParseBoard. Fuzzing needs Go 1.18; older toolchains still
+// run the corpus (see TestParseBoardCorpus).
+func FuzzParseBoard(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("fuzz", "corpus", "*"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(checkParseBoard)
+}
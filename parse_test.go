@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBoardErrors(t *testing.T) {
+	type want struct {
+		kind         ParseErrorKind
+		line, column int
+	}
+	for _, testCase := range []struct {
+		name  string
+		board string
+		opts  ParseOptions
+		wants []want
+	}{{
+		name:  "empty",
+		board: "",
+		wants: []want{{ParseErrorEmpty, 0, 0}},
+	}, {
+		name:  "blank-lines",
+		board: "\n\n",
+		wants: []want{{ParseErrorEmpty, 0, 0}},
+	}, {
+		name:  "row-length",
+		board: "#####\nS   E\n####\n",
+		wants: []want{{ParseErrorRowLength, 3, 5}},
+	}, {
+		name:  "illegal-tile",
+		board: "#####\nS x E\n#####\n",
+		wants: []want{{ParseErrorIllegalTile, 2, 3}},
+	}, {
+		name:  "duplicates",
+		board: "#####\nS S E\n##E##\n",
+		wants: []want{
+			{ParseErrorDuplicateStart, 2, 3},
+			{ParseErrorDuplicateEnd, 3, 3},
+		},
+	}, {
+		name:  "missing",
+		board: "#####\n#   #\n#####\n",
+		wants: []want{
+			{ParseErrorMissingStart, 0, 0},
+			{ParseErrorMissingEnd, 0, 0},
+		},
+	}, {
+		name:  "teleporter",
+		board: "#####\nS 1 E\n#####\n",
+		wants: []want{{ParseErrorTeleporter, 2, 3}},
+	}, {
+		name:  "lenient-lines-counted-from-input",
+		board: "\n\n#####\nS x E\n#####\n",
+		opts:  ParseOptions{Lenient: true},
+		wants: []want{{ParseErrorIllegalTile, 4, 3}},
+	}, {
+		name:  "too-many",
+		board: "S" + strings.Repeat("x", 2*maxParseErrors) + "E\n",
+		wants: func() []want {
+			wants := make([]want, maxParseErrors)
+			for i := range wants {
+				wants[i] = want{ParseErrorIllegalTile, 1, i + 2}
+			}
+			return wants
+		}(),
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := ParseBoardOptions(
+				strings.NewReader(testCase.board),
+				testCase.opts,
+			)
+			errs, ok := err.(ParseErrors)
+			if !ok {
+				t.Fatalf("Wanted ParseErrors; got %#v", err)
+			}
+			var got []want
+			for _, err := range errs {
+				got = append(got, want{err.Kind, err.Line, err.Column})
+			}
+			if !reflect.DeepEqual(got, testCase.wants) {
+				t.Fatalf("Wanted %v; got %v (%v)", testCase.wants, got, errs)
+			}
+		})
+	}
+}
+
+func TestParseBoard(t *testing.T) {
+	for _, testCase := range []struct {
+		name  string
+		board string
+		opts  ParseOptions
+		want  string
+	}{{
+		name:  "corridor",
+		board: "#####\nS   E\n#####\n",
+		want:  "#####\nS   E\n#####\n",
+	}, {
+		name:  "crlf",
+		board: "#####\r\nS   E\r\n#####\r\n",
+		want:  "#####\nS   E\n#####\n",
+	}, {
+		name:  "lenient",
+		board: "\n#####  \nS   E\n#####\t\n\n",
+		opts:  ParseOptions{Lenient: true},
+		want:  "#####\nS   E\n#####\n",
+	}, {
+		name:  "lenient-pads-short-rows",
+		board: "#####\nS  E\n#####\n",
+		opts:  ParseOptions{Lenient: true},
+		want:  "#####\nS  E \n#####\n",
+	}, {
+		name:  "items",
+		board: "#####\nS1 1E\n#####\n",
+		want:  "#####\nS1 1E\n#####\n",
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			board, err := ParseBoardOptions(
+				strings.NewReader(testCase.board),
+				testCase.opts,
+			)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := board.Text(); got != testCase.want {
+				t.Fatalf("Wanted %q; got %q", testCase.want, got)
+			}
+		})
+	}
+}
+
+// TestParseBoardCorpus runs the checks FuzzParseBoard makes over the boards in
+// fuzz/corpus, for toolchains too old to fuzz.
+func TestParseBoardCorpus(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("fuzz", "corpus", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) < 1 {
+		t.Fatal("Wanted boards in fuzz/corpus")
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			checkParseBoard(t, data)
+		})
+	}
+}
+
+// checkParseBoard checks that, whatever the input, ParseBoard doesn't panic;
+// errors about the board itself are positioned within it; and any board that
+// parses parses to the same board again once written out.
+func checkParseBoard(t *testing.T, data []byte) {
+	for _, opts := range []ParseOptions{{}, {Lenient: true}} {
+		board, err := ParseBoardOptions(bytes.NewReader(data), opts)
+		if errs, ok := err.(ParseErrors); ok {
+			checkParseErrors(t, data, errs)
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		again, err := ParseBoard(strings.NewReader(board.Text()))
+		if err != nil {
+			t.Fatalf("Error re-parsing %q: %v", board.Text(), err)
+		}
+		if !reflect.DeepEqual(board, again) {
+			t.Fatalf("%#v re-parsed as %#v", board, again)
+		}
+	}
+}
+
+// checkParseErrors fails unless there are a sensible number of errors, all
+// within the lines of `data`.
+func checkParseErrors(t *testing.T, data []byte, errs ParseErrors) {
+	if len(errs) < 1 || len(errs) > maxParseErrors {
+		t.Fatalf("Wanted 1 to %d errors; got %d", maxParseErrors, len(errs))
+	}
+	lines := bytes.Count(data, []byte("\n")) + 1
+	for _, err := range errs {
+		if err.Line > lines || err.Line < 0 || err.Column < 0 {
+			t.Fatalf("Error outside of %d lines: %v", lines, err)
+		}
+	}
+}
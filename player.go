@@ -1,8 +1,21 @@
 package main
 
+import "time"
+
 type Player struct {
-	ID  rune
-	Pos Point
+	ID      rune
+	Pos     Point
+	Moves   int
+	Keys    int
+	Effects Effects
+
+	// Progress towards the game's objective
+	Visited []Point       // checkpoints, in the order they were visited
+	Coins   int           // coins collected
+	Held    time.Duration // time spent holding the hill
+	Out     bool          // tagged out of the game
+
+	Team int // zero outside of team games
 }
 
 // Replaced by Game.PlayerWindow()
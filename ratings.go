@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	initialRating = 1500
+
+	// eloK bounds how far a single pairing can move a rating
+	eloK = 32
+
+	// A lobby accepts players whose rating is within baseRatingWindow of the
+	// lobby average, and that window widens by ratingWindowGrowth for every
+	// second the lobby has been waiting so nobody waits forever.
+	baseRatingWindow   = 100
+	ratingWindowGrowth = 10
+)
+
+// expectedScore is the Elo probability that a player rated `r` beats a player
+// rated `opponent`.
+func expectedScore(r, opponent float64) float64 {
+	return 1 / (1 + math.Pow(10, (opponent-r)/400))
+}
+
+// ratingWindow returns how far from a lobby's average rating a joining player
+// may be after the lobby has waited for `wait`.
+func ratingWindow(wait time.Duration) float64 {
+	return baseRatingWindow + ratingWindowGrowth*wait.Seconds()
+}
+
+// Rating returns the current rating for the named player.
+func (r *Results) Rating(player string) float64 {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.rating(player)
+}
+
+// rating assumes the mutex is already locked.
+func (r *Results) rating(player string) float64 {
+	if rating, found := r.ratings[player]; found {
+		return rating
+	}
+	return initialRating
+}
+
+// rate applies the outcome of a solve to the ratings. Finish order is scored
+// pairwise: whoever finishes first beats every player still on the board at
+// that time, so each pair of players is rated exactly once per match.
+// Anybody can play as anonymousName, so pairs including it aren't rated. This
+// assumes the mutex is already locked.
+func (r *Results) rate(solve Solve) {
+	if r.ratings == nil {
+		r.ratings = map[string]float64{}
+	}
+	if solve.Player == anonymousName {
+		return
+	}
+	for _, loser := range solve.Beat {
+		if loser == anonymousName {
+			continue
+		}
+		winnerRating := r.rating(solve.Player)
+		loserRating := r.rating(loser)
+		delta := eloK * (1 - expectedScore(winnerRating, loserRating))
+		r.ratings[solve.Player] = winnerRating + delta
+		r.ratings[loser] = loserRating - delta
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRate(t *testing.T) {
+	for _, testCase := range []struct {
+		name    string
+		ratings map[string]float64 // before; anyone else starts out unrated
+		solves  []Solve
+		want    map[string]float64
+	}{{
+		name:   "nobody-beaten",
+		solves: []Solve{{Player: "alice"}},
+		want:   map[string]float64{"alice": initialRating},
+	}, {
+		name:   "even",
+		solves: []Solve{{Player: "alice", Beat: []string{"bob"}}},
+		want:   map[string]float64{"alice": 1516, "bob": 1484},
+	}, {
+		name: "pairs-rated-in-turn",
+		solves: []Solve{
+			{Player: "alice", Beat: []string{"bob", "carol"}},
+			{Player: "bob", Beat: []string{"carol"}},
+		},
+		want: map[string]float64{
+			"alice": 1531.2637,
+			"bob":   1500.0339,
+			"carol": 1468.7024,
+		},
+	}, {
+		name: "anonymous-winner",
+		solves: []Solve{
+			{Player: anonymousName, Beat: []string{"bob"}},
+		},
+		want: map[string]float64{anonymousName: initialRating, "bob": 1500},
+	}, {
+		name: "anonymous-beaten",
+		solves: []Solve{
+			{Player: "alice", Beat: []string{anonymousName, "bob"}},
+		},
+		want: map[string]float64{
+			"alice":       1516,
+			"bob":         1484,
+			anonymousName: initialRating,
+		},
+	}, {
+		name:    "upset",
+		ratings: map[string]float64{"alice": 1400, "bob": 1600},
+		solves:  []Solve{{Player: "alice", Beat: []string{"bob"}}},
+		want:    map[string]float64{"alice": 1424.3119, "bob": 1575.6881},
+	}, {
+		name:    "favorite",
+		ratings: map[string]float64{"alice": 1600, "bob": 1400},
+		solves:  []Solve{{Player: "alice", Beat: []string{"bob"}}},
+		want:    map[string]float64{"alice": 1607.6881, "bob": 1392.3119},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := Results{ratings: testCase.ratings}
+			var before float64
+			for _, rating := range testCase.ratings {
+				before += rating
+			}
+			for _, solve := range testCase.solves {
+				r.rate(solve)
+			}
+
+			var after float64
+			for player, want := range testCase.want {
+				got := r.rating(player)
+				if math.Abs(got-want) > 1e-3 {
+					t.Errorf(
+						"Wanted %s rated %.4f; got %.4f",
+						player,
+						want,
+						got,
+					)
+				}
+				if _, found := testCase.ratings[player]; !found {
+					before += initialRating
+				}
+				after += got
+			}
+			if math.Abs(after-before) > 1e-9 {
+				t.Errorf("Wanted ratings to sum to %f; got %f", before, after)
+			}
+		})
+	}
+}
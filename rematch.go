@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Rematch is a proposal for the next match in a lobby whose match is over:
+// the same board or a new one, and the rules to play it by.
+type Rematch struct {
+	SameSeed bool  `json:"same_seed"`
+	Rules    Rules `json:"rules"`
+}
+
+// parseRematch parses a vote for a rematch, which looks like
+// `rematch [same|new] [rules]`, where the rules are given as query parameters
+// (e.g. `tick=200ms&collisions=push`; see queryRules). By default the rematch
+// is on a new board, with the `current` rules.
+func parseRematch(msg string, current Rules) (Rematch, bool) {
+	fields := strings.Fields(msg)
+	if len(fields) < 1 || len(fields) > 3 || fields[0] != "rematch" {
+		return Rematch{}, false
+	}
+	rematch := Rematch{Rules: current}
+	for _, field := range fields[1:] {
+		switch field {
+		case "same":
+			rematch.SameSeed = true
+		case "new":
+			rematch.SameSeed = false
+		default:
+			query, err := url.ParseQuery(field)
+			if err != nil {
+				return Rematch{}, false
+			}
+			if rematch.Rules, err = parseRules(query); err != nil {
+				return Rematch{}, false
+			}
+		}
+	}
+	return rematch, true
+}
+
+// CurrentRules returns the rules the lobby's games are played by, which can
+// change from one match to the next.
+func (l *Lobby) CurrentRules() Rules {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	return l.Rules
+}
+
+// VoteRematch records the user's vote for a rematch once the lobby's match is
+// over. When every user in the lobby has voted, the proposal with the most
+// votes is played, in this same lobby; ties go to the proposal of whoever
+// joined the lobby first. Daily challenges can't be rematched, since every
+// attempt at them counts, and votes for mazes that aren't in the library are
+// ignored.
+func (l *Lobby) VoteRematch(user Participant, rematch Rematch) {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	if l.Game == nil || !l.Game.Over() || l.Daily != "" {
+		return
+	}
+	if maze := rematch.Rules.Maze; maze != "" {
+		if l.Mazes == nil {
+			return
+		}
+		if _, found := l.Mazes.Get(maze); !found {
+			return
+		}
+	}
+	l.Rematches[user] = rematch
+	if len(l.Rematches) >= l.humans() {
+		l.rematch(l.chosenRematch())
+	}
+	l.broadcast()
+}
+
+// chosenRematch assumes the mutex is already locked.
+func (l *Lobby) chosenRematch() Rematch {
+	votes := map[Rematch]int{}
+	var chosen Rematch
+	for _, user := range l.Users {
+		rematch, voted := l.Rematches[user]
+		if !voted {
+			continue
+		}
+		votes[rematch]++
+		if votes[rematch] > votes[chosen] {
+			chosen = rematch
+		}
+	}
+	return chosen
+}
+
+// rematch starts a new match in place of the one that's over, with the same
+// users. Ratings are brought up to date so teams are dealt fairly. This
+// assumes the mutex is already locked.
+func (l *Lobby) rematch(rematch Rematch) {
+	if !rematch.SameSeed {
+		l.Spec.Seed = time.Now().UnixNano()
+	}
+	l.Spec.Items = rematch.Rules.Items
+	l.Rules = rematch.Rules
+	if l.Results != nil {
+		for _, user := range l.Users {
+			l.Ratings[user] = l.Results.Rating(user.Name())
+		}
+	}
+	l.Votes = map[Participant]bool{}
+	l.Rematches = map[Participant]Rematch{}
+	l.startGame()
+}
@@ -0,0 +1,155 @@
+package main
+
+import "testing"
+
+func TestVoteRematch(t *testing.T) {
+	type vote struct {
+		user int // by the order they joined
+		msg  string
+	}
+	for _, testCase := range []struct {
+		name    string
+		users   int
+		playing bool // the match isn't over yet
+		daily   bool
+		votes   []vote
+
+		// rematch is whether a rematch is played, on the same board if
+		// same, by rules
+		rematch bool
+		same    bool
+		rules   Rules
+	}{{
+		name:  "waits-for-everyone",
+		users: 2,
+		votes: []vote{{0, "rematch same"}},
+	}, {
+		name:    "unanimous",
+		users:   2,
+		votes:   []vote{{0, "rematch same"}, {1, "rematch same"}},
+		rematch: true,
+		same:    true,
+	}, {
+		name:  "majority",
+		users: 3,
+		votes: []vote{
+			{0, "rematch same"},
+			{1, "rematch new collisions=push"},
+			{2, "rematch new collisions=push"},
+		},
+		rematch: true,
+		rules:   Rules{Collisions: CollisionPush},
+	}, {
+		name:    "tie-goes-to-first-joined",
+		users:   2,
+		votes:   []vote{{1, "rematch new"}, {0, "rematch same"}},
+		rematch: true,
+		same:    true,
+	}, {
+		name:  "changed-vote",
+		users: 2,
+		votes: []vote{
+			{0, "rematch same"},
+			{0, "rematch new items=true"},
+			{1, "rematch new items=true"},
+		},
+		rematch: true,
+		rules:   Rules{Items: true},
+	}, {
+		name:    "not-over",
+		users:   2,
+		playing: true,
+		votes:   []vote{{0, "rematch same"}, {1, "rematch same"}},
+	}, {
+		name:  "daily",
+		users: 1,
+		daily: true,
+		votes: []vote{{0, "rematch same"}},
+	}, {
+		name:  "unknown-maze",
+		users: 1,
+		votes: []vote{{0, "rematch maze=nope"}},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			l := &Lobby{
+				MaxSize:   testCase.users,
+				Votes:     map[Participant]bool{},
+				Ratings:   map[Participant]float64{},
+				Spec:      DefaultBoardSpec(1),
+				Rematches: map[Participant]Rematch{},
+			}
+			users := make([]Participant, testCase.users)
+			for i := range users {
+				users[i] = &testParticipant{name: string('a' + rune(i))}
+				if !l.Add(users[i], initialRating) {
+					t.Fatalf("Couldn't add user %d", i)
+				}
+			}
+			if testCase.daily {
+				l.Daily = "2006-01-02"
+			}
+			first := l.Game
+			if !testCase.playing {
+				first.Mutex.Lock()
+				first.Game = first.Game.End()
+				first.Mutex.Unlock()
+			}
+
+			for _, vote := range testCase.votes {
+				rematch, ok := parseRematch(vote.msg, l.CurrentRules())
+				if !ok {
+					t.Fatalf("Invalid vote: %s", vote.msg)
+				}
+				l.VoteRematch(users[vote.user], rematch)
+			}
+
+			rematched := l.Game != first
+			if rematched != testCase.rematch {
+				t.Fatalf(
+					"Wanted rematch %t; got %t",
+					testCase.rematch,
+					rematched,
+				)
+			}
+			if !testCase.rematch {
+				return
+			}
+			if same := l.Spec.Seed == 1; same != testCase.same {
+				t.Errorf("Wanted same board %t; got %t", testCase.same, same)
+			}
+			if l.Rules != testCase.rules {
+				t.Errorf("Wanted rules %#v; got %#v", testCase.rules, l.Rules)
+			}
+			if len(l.Rematches) > 0 {
+				t.Errorf("Wanted votes cleared; got %v", l.Rematches)
+			}
+		})
+	}
+}
+
+func TestVoteRematchAfterDrop(t *testing.T) {
+	// Whoever is left may all have voted already when somebody leaves.
+	l := &Lobby{
+		MaxSize:   2,
+		Votes:     map[Participant]bool{},
+		Ratings:   map[Participant]float64{},
+		Spec:      DefaultBoardSpec(1),
+		Rematches: map[Participant]Rematch{},
+	}
+	alice, bob := &testParticipant{"alice"}, &testParticipant{"bob"}
+	l.Add(alice, initialRating)
+	l.Add(bob, initialRating)
+	first := l.Game
+	first.Mutex.Lock()
+	first.Game = first.Game.End()
+	first.Mutex.Unlock()
+
+	l.VoteRematch(alice, Rematch{SameSeed: true})
+	l.Drop(bob)
+	if l.Game == first || l.Game.Over() {
+		t.Fatal("Wanted a rematch once bob left")
+	}
+	if len(l.Users) != 1 || l.Users[0] != alice {
+		t.Fatalf("Wanted a match for alice alone; got %v", l.Users)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Bounds on how many pixels a tile is drawn with
+const (
+	defaultRenderScale = 8
+	minRenderScale     = 1
+	maxRenderScale     = 32
+)
+
+// Replays are animated at a fixed frame rate; long matches are sped up so
+// they fit in maxReplayFrames frames. The last frame lingers so the ending
+// can be seen.
+const (
+	replayFrameDelay = 100 * time.Millisecond
+	replayLastDelay  = 2 * time.Second
+	maxReplayFrames  = 300
+)
+
+// RenderFormat is a kind of image boards and replays can be rendered to.
+type RenderFormat int
+
+const (
+	RenderSVG RenderFormat = iota
+	RenderPNG
+	RenderGIF
+)
+
+var renderFormatNames = map[RenderFormat]string{
+	RenderSVG: "svg",
+	RenderPNG: "png",
+	RenderGIF: "gif",
+}
+
+var renderFormatContentTypes = map[RenderFormat]string{
+	RenderSVG: "image/svg+xml",
+	RenderPNG: "image/png",
+	RenderGIF: "image/gif",
+}
+
+func (f RenderFormat) String() string {
+	if name, found := renderFormatNames[f]; found {
+		return name
+	}
+	return fmt.Sprintf("RenderFormat(%d)", int(f))
+}
+
+// ContentType is the media type of images rendered in the format.
+func (f RenderFormat) ContentType() string {
+	return renderFormatContentTypes[f]
+}
+
+func ParseRenderFormat(s string) (RenderFormat, error) {
+	for format, name := range renderFormatNames {
+		if name == s {
+			return format, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown render format: %s", s)
+}
+
+// playerColors are the colours players' paths and tokens are drawn in, by
+// their place in the replay.
+var playerColors = []color.NRGBA{
+	{0x1f, 0x77, 0xb4, 0xff},
+	{0xff, 0x7f, 0x0e, 0xff},
+	{0x2c, 0xa0, 0x2c, 0xff},
+	{0xd6, 0x27, 0x28, 0xff},
+	{0x94, 0x67, 0xbd, 0xff},
+	{0x8c, 0x56, 0x4b, 0xff},
+	{0xe3, 0x77, 0xc2, 0xff},
+	{0x17, 0xbe, 0xcf, 0xff},
+}
+
+func playerColor(i int) color.NRGBA {
+	return playerColors[i%len(playerColors)]
+}
+
+// Render draws the replay. Still images show the board as the match started
+// with every player's path over it; GIFs animate the match, shifts and all.
+// `scale` is how many pixels each tile is drawn with.
+func (rp Replay) Render(w io.Writer, format RenderFormat, scale int) error {
+	switch format {
+	case RenderSVG:
+		return rp.renderSVG(w, scale)
+	case RenderPNG:
+		return png.Encode(w, rp.renderImage(scale))
+	case RenderGIF:
+		return rp.renderGIF(w, scale)
+	default:
+		return fmt.Errorf("Unknown render format: %v", format)
+	}
+}
+
+// segments splits the player's path into runs of moves that went in a
+// straight line, so jumps (teleports) aren't drawn as paths through walls.
+func (player ReplayPlayer) segments(start Point) [][]Point {
+	segments := [][]Point{{start}}
+	at := start
+	for _, move := range player.Path {
+		last := &segments[len(segments)-1]
+		if straight(at, move.To, move.Dir) {
+			*last = append(*last, move.To)
+		} else {
+			segments = append(segments, []Point{move.To})
+		}
+		at = move.To
+	}
+	return segments
+}
+
+// straight reports whether `to` is a few tiles from `from` in direction `dir`,
+// as far as a single move can go.
+func straight(from, to Point, dir Dir) bool {
+	for i := 0; i < 4; i++ {
+		if from = from.Translate(dir); from == to {
+			return true
+		}
+	}
+	return false
+}
+
+// tileAt returns the tile to draw at `p`: the item there, if any, or else the
+// board's own tile.
+func tileAt(b Board, items map[Point]Item, p Point) rune {
+	if item, found := items[p]; found {
+		return rune(item)
+	}
+	return b.Rows[p.Y][p.X]
+}
+
+// renderSVG draws the board with a square per tile (walls are merged into
+// runs to keep the file small) and each path as a polyline. Coordinates are
+// in tiles, scaled by the viewBox.
+func (rp Replay) renderSVG(w io.Writer, scale int) error {
+	b := rp.Board
+	buf := bufio.NewWriter(w)
+	fmt.Fprintf(
+		buf,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" `+
+			`viewBox="0 0 %d %d" shape-rendering="crispEdges">`+"\n",
+		b.Width()*scale,
+		b.Height()*scale,
+		b.Width(),
+		b.Height(),
+	)
+	fmt.Fprintf(
+		buf,
+		`<rect width="%d" height="%d" fill="%s"/>`+"\n",
+		b.Width(),
+		b.Height(),
+		hexColor(tileColors[tileSpace]),
+	)
+	for y, row := range b.Rows {
+		for x := 0; x < len(row); x++ {
+			tile := tileAt(b, b.Items, Point{X: x, Y: y})
+			if tile == tileSpace {
+				continue
+			}
+			run := 1
+			for tile == tileWall && x+run < len(row) &&
+				tileAt(b, b.Items, Point{X: x + run, Y: y}) == tileWall {
+				run++
+			}
+			fmt.Fprintf(
+				buf,
+				`<rect x="%d" y="%d" width="%d" height="1" fill="%s"/>`+"\n",
+				x,
+				y,
+				run,
+				hexColor(tileColors[tile]),
+			)
+			x += run - 1
+		}
+	}
+	for i, player := range rp.Players {
+		buf.WriteString("<g>\n<title>")
+		xml.EscapeText(buf, []byte(player.Name))
+		buf.WriteString("</title>\n")
+		for _, segment := range player.segments(b.Start) {
+			points := make([]string, len(segment))
+			for j, p := range segment {
+				points[j] = fmt.Sprintf("%d.5,%d.5", p.X, p.Y)
+			}
+			fmt.Fprintf(
+				buf,
+				`<polyline points="%s" fill="none" stroke="%s" `+
+					`stroke-width="0.3" stroke-opacity="0.8" `+
+					`stroke-linecap="round" stroke-linejoin="round"/>`+"\n",
+				strings.Join(points, " "),
+				hexColor(playerColor(i)),
+			)
+		}
+		buf.WriteString("</g>\n")
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Flush()
+}
+
+func hexColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// renderImage draws the board with every player's path over it.
+func (rp Replay) renderImage(scale int) *image.NRGBA {
+	b := rp.Board
+	img := image.NewNRGBA(image.Rect(0, 0, b.Width()*scale, b.Height()*scale))
+	drawBoard(img, b, b.Items, scale)
+	thickness := scale / 4
+	if thickness < 1 {
+		thickness = 1
+	}
+	for i, player := range rp.Players {
+		c := playerColor(i)
+		c.A = 0xcc
+		for _, segment := range player.segments(b.Start) {
+			for j, p := range segment {
+				from := p
+				if j > 0 {
+					from = segment[j-1]
+				}
+				draw.Draw(
+					img,
+					lineRect(from, p, scale, thickness),
+					&image.Uniform{c},
+					image.ZP,
+					draw.Over,
+				)
+			}
+		}
+	}
+	return img
+}
+
+// drawBoard draws each tile as a `scale`-pixel square.
+func drawBoard(img draw.Image, b Board, items map[Point]Item, scale int) {
+	for y, row := range b.Rows {
+		for x := range row {
+			tile := tileAt(b, items, Point{X: x, Y: y})
+			draw.Draw(
+				img,
+				image.Rect(x*scale, y*scale, (x+1)*scale, (y+1)*scale),
+				&image.Uniform{tileColors[tile]},
+				image.ZP,
+				draw.Src,
+			)
+		}
+	}
+}
+
+// lineRect is the rectangle covering a `thickness`-pixel line between the
+// centres of two tiles in the same row or column.
+func lineRect(from, to Point, scale, thickness int) image.Rectangle {
+	centre := func(p Point) image.Point {
+		return image.Pt(p.X*scale+scale/2, p.Y*scale+scale/2)
+	}
+	r := image.Rectangle{Min: centre(from), Max: centre(to)}.Canon()
+	r.Min = r.Min.Sub(image.Pt(thickness/2, thickness/2))
+	r.Max = r.Max.Add(image.Pt(thickness-thickness/2, thickness-thickness/2))
+	return r
+}
+
+// renderGIF animates the match at replayFrameDelay a frame, speeding long
+// matches up to fit maxReplayFrames. Each frame shows the board as it was
+// then, with every player's token where they were.
+func (rp Replay) renderGIF(w io.Writer, scale int) error {
+	// The palette is built in a fixed order so the same replay always
+	// renders the same GIF.
+	var tiles []rune
+	for tile := range tileColors {
+		tiles = append(tiles, tile)
+	}
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i] < tiles[j] })
+	var palette color.Palette
+	for _, tile := range tiles {
+		palette = append(palette, tileColors[tile])
+	}
+	for i := range rp.Players {
+		palette = append(palette, playerColor(i))
+	}
+
+	step := replayFrameDelay
+	duration := rp.Duration()
+	if frames := time.Duration(maxReplayFrames - 1); duration/frames > step {
+		step = (duration + frames - 1) / frames
+	}
+
+	var out gif.GIF
+	b := rp.Board
+	bounds := image.Rect(0, 0, b.Width()*scale, b.Height()*scale)
+	for t := time.Duration(0); ; t += step {
+		frame := rp.at(t)
+		img := image.NewPaletted(bounds, palette)
+		drawBoard(img, frame.board, frame.items, scale)
+		for i, p := range frame.positions {
+			inset := scale / 8
+			draw.Draw(
+				img,
+				image.Rect(
+					p.X*scale+inset,
+					p.Y*scale+inset,
+					(p.X+1)*scale-inset,
+					(p.Y+1)*scale-inset,
+				),
+				&image.Uniform{playerColor(i)},
+				image.ZP,
+				draw.Src,
+			)
+		}
+		out.Image = append(out.Image, img)
+		out.Delay = append(out.Delay, int(replayFrameDelay/centisecond))
+		if t >= duration {
+			break
+		}
+	}
+	out.Delay[len(out.Delay)-1] = int(replayLastDelay / centisecond)
+	return gif.EncodeAll(w, &out)
+}
+
+// centisecond is the unit of GIF frame delays.
+const centisecond = 10 * time.Millisecond
+
+// replayFrame is the state of a replay at some point in the match.
+type replayFrame struct {
+	board     Board
+	items     map[Point]Item
+	positions []Point // by player, in replay order
+}
+
+// at works out where everybody was `t` into the match, which shifts had been
+// made and which items had been picked up. Items are taken to be picked up
+// when a player's move ends on them; teleporters are never used up.
+func (rp Replay) at(t time.Duration) replayFrame {
+	frame := replayFrame{
+		board:     rp.Board,
+		items:     map[Point]Item{},
+		positions: make([]Point, len(rp.Players)),
+	}
+	for p, item := range rp.Board.Items {
+		frame.items[p] = item
+	}
+	for _, shift := range rp.Shifts {
+		if shift.Elapsed > t {
+			break
+		}
+		for _, change := range shift.Changes {
+			tile := tileSpace
+			if change.Wall {
+				tile = tileWall
+			}
+			frame.board = frame.board.SetTile(change.At, tile)
+		}
+	}
+	for i, player := range rp.Players {
+		frame.positions[i] = rp.Board.Start
+		for _, move := range player.Path {
+			if move.Elapsed > t {
+				break
+			}
+			frame.positions[i] = move.To
+			if item := frame.items[move.To]; !item.IsTeleporter() {
+				delete(frame.items, move.To)
+			}
+		}
+	}
+	return frame
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"image/gif"
+	"image/png"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testReplay is a match on cellBoard in which one player picks up the key on
+// the way to the end, and the wall above the key opens halfway through.
+func testReplay(t *testing.T) Replay {
+	board, err := ParseBoard(strings.NewReader(cellBoard))
+	if err != nil {
+		t.Fatalf("Invalid test board: %v", err)
+	}
+	var path []Move
+	for x := 1; x <= 4; x++ {
+		path = append(path, Move{
+			Player:  '@',
+			Dir:     Right,
+			To:      Point{x, 1},
+			Elapsed: time.Duration(x) * time.Second,
+		})
+	}
+	return Replay{
+		MatchID: "match",
+		Board:   board,
+		Shifts: []Shift{{
+			Elapsed: 1500 * time.Millisecond,
+			Changes: []TileChange{{At: Point{1, 0}}},
+		}},
+		Players: []ReplayPlayer{{
+			Token:    '@',
+			Name:     "<alice>",
+			Duration: 4 * time.Second,
+			Path:     path,
+		}},
+	}
+}
+
+func TestNewReplay(t *testing.T) {
+	if _, err := NewReplay(nil, nil); err != ErrMatchNotFound {
+		t.Fatalf("Wanted ErrMatchNotFound; got %v", err)
+	}
+	if _, err := NewReplay([]Solve{{Maze: "gone"}}, nil); err == nil {
+		t.Fatal("Wanted an error for an unknown maze")
+	}
+
+	shifts := []Shift{{Elapsed: time.Second}, {Elapsed: 2 * time.Second}}
+	solves := []Solve{{
+		MatchID:   "match",
+		Player:    "bob",
+		Token:     "@",
+		Seed:      1,
+		Width:     9,
+		Height:    9,
+		Generator: "binary-tree",
+		Duration:  3 * time.Second,
+		Shifts:    shifts,
+	}, {
+		MatchID:   "match",
+		Player:    "alice",
+		Token:     "$",
+		Seed:      1,
+		Width:     9,
+		Height:    9,
+		Generator: "binary-tree",
+		Duration:  time.Second,
+		Shifts:    shifts[:1],
+	}}
+	replay, err := NewReplay(solves, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := GenerateBinaryTreeBoard(1, 4, 4); !reflect.DeepEqual(
+		replay.Board,
+		want,
+	) {
+		t.Fatalf("Wanted the board regenerated from its seed")
+	}
+	if !reflect.DeepEqual(replay.Shifts, shifts) {
+		t.Fatalf("Wanted every shift; got %v", replay.Shifts)
+	}
+	var names []string
+	for _, player := range replay.Players {
+		names = append(names, player.Name)
+	}
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Wanted players in token order %v; got %v", want, names)
+	}
+}
+
+func TestReplayAt(t *testing.T) {
+	replay := testReplay(t)
+	for _, testCase := range []struct {
+		at      time.Duration
+		pos     Point
+		key     bool
+		shifted bool
+	}{
+		{0, Point{0, 1}, true, false},
+		{time.Second, Point{1, 1}, false, false},
+		{1500 * time.Millisecond, Point{1, 1}, false, true},
+		{time.Minute, Point{4, 1}, false, true},
+	} {
+		frame := replay.at(testCase.at)
+		if frame.positions[0] != testCase.pos {
+			t.Errorf(
+				"Wanted the player at %v after %v; got %v",
+				testCase.pos,
+				testCase.at,
+				frame.positions[0],
+			)
+		}
+		if _, key := frame.items[Point{1, 1}]; key != testCase.key {
+			t.Errorf("Wanted key %t after %v", testCase.key, testCase.at)
+		}
+		if shifted := frame.board.IsPath(Point{1, 0}); shifted !=
+			testCase.shifted {
+			t.Errorf(
+				"Wanted shifted %t after %v",
+				testCase.shifted,
+				testCase.at,
+			)
+		}
+	}
+}
+
+func TestRenderPNG(t *testing.T) {
+	// Drawn a pixel per tile without any players, a board is a PNG board.
+	replay := testReplay(t)
+	replay.Players = nil
+	var buf bytes.Buffer
+	if err := replay.Render(&buf, RenderPNG, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	board, err := DecodePNG(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding: %v", err)
+	}
+	if board.Text() != cellBoard {
+		t.Fatalf("Wanted:\n%s\nGot:\n%s", cellBoard, board.Text())
+	}
+
+	buf.Reset()
+	if err := testReplay(t).Render(&buf, RenderPNG, 3); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	config, err := png.DecodeConfig(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding: %v", err)
+	}
+	if config.Width != 15 || config.Height != 9 {
+		t.Fatalf("Wanted a 15x9 image; got %dx%d", config.Width, config.Height)
+	}
+}
+
+func TestRenderSVG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReplay(t).Render(&buf, RenderSVG, 8); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`width="40" height="24" viewBox="0 0 5 3"`,
+		"<title>&lt;alice&gt;</title>",
+		`points="0.5,1.5 1.5,1.5 2.5,1.5 3.5,1.5 4.5,1.5"`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Wanted %s in:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRenderGIF(t *testing.T) {
+	var buf bytes.Buffer
+	if err := testReplay(t).Render(&buf, RenderGIF, 2); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	out, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("Unexpected error decoding: %v", err)
+	}
+	// A frame every replayFrameDelay, from the start to the end
+	if want := int(4*time.Second/replayFrameDelay) + 1; len(out.Image) !=
+		want {
+		t.Fatalf("Wanted %d frames; got %d", want, len(out.Image))
+	}
+	if bounds := out.Image[0].Bounds(); bounds.Dx() != 10 ||
+		bounds.Dy() != 6 {
+		t.Fatalf("Wanted 10x6 frames; got %v", bounds)
+	}
+	if last := out.Delay[len(out.Delay)-1]; last !=
+		int(replayLastDelay/centisecond) {
+		t.Fatalf("Wanted the last frame held; got delay %d", last)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrMatchNotFound = errors.New("Match not found")
+
+// Replay is a match as it was recorded: the board it started on, the shifts
+// made to it and the path of each player who finished. Players who didn't
+// finish left no record, so they aren't in it.
+type Replay struct {
+	MatchID string
+	Board   Board
+	Shifts  []Shift
+	Players []ReplayPlayer // in token order
+}
+
+// ReplayPlayer is a player's part in a replay.
+type ReplayPlayer struct {
+	Token    rune
+	Name     string
+	Duration time.Duration
+	Path     []Move
+}
+
+// Match returns the solves recorded for the match, in the order they were
+// recorded.
+func (r *Results) Match(matchID string) []Solve {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	var solves []Solve
+	for _, solve := range r.Solves {
+		if solve.MatchID == matchID {
+			solves = append(solves, solve)
+		}
+	}
+	return solves
+}
+
+// NewReplay builds the replay of a match from its solves (see Results.Match),
+// looking up the maze it was played on in `mazes` if it was a stored one.
+func NewReplay(solves []Solve, mazes *MazeLibrary) (Replay, error) {
+	if len(solves) < 1 {
+		return Replay{}, ErrMatchNotFound
+	}
+	board, err := solveBoard(solves[0], mazes)
+	if err != nil {
+		return Replay{}, err
+	}
+	replay := Replay{MatchID: solves[0].MatchID, Board: board}
+	for _, solve := range solves {
+		// Every solve carries every shift up to when it finished, so the
+		// latest has them all.
+		if len(solve.Shifts) > len(replay.Shifts) {
+			replay.Shifts = solve.Shifts
+		}
+		token, _ := utf8.DecodeRuneInString(solve.Token)
+		replay.Players = append(replay.Players, ReplayPlayer{
+			Token:    token,
+			Name:     solve.Player,
+			Duration: solve.Duration,
+			Path:     solve.Path,
+		})
+	}
+	sort.Slice(replay.Players, func(i, j int) bool {
+		return replay.Players[i].Token < replay.Players[j].Token
+	})
+	return replay, nil
+}
+
+// solveBoard rebuilds the board a solve was played on: the stored maze, or
+// the board generated from its seed. Solves recorded before generators were
+// recorded are assumed to be of the default generator.
+func solveBoard(solve Solve, mazes *MazeLibrary) (Board, error) {
+	if solve.Maze != "" {
+		if mazes == nil {
+			return Board{}, fmt.Errorf("Unknown maze: %s", solve.Maze)
+		}
+		maze, found := mazes.Get(solve.Maze)
+		if !found {
+			return Board{}, fmt.Errorf("Unknown maze: %s", solve.Maze)
+		}
+		return maze.Board(), nil
+	}
+	spec := BoardSpec{
+		Seed:      solve.Seed,
+		Width:     solve.Width / 2,
+		Height:    solve.Height / 2,
+		Generator: solve.Generator,
+		Items:     solve.Items,
+	}
+	if spec.Generator == "" {
+		spec.Generator = defaultGenerator
+	}
+	if err := spec.Validate(); err != nil {
+		return Board{}, err
+	}
+	board := spec.Generate()
+	if board.Width() != solve.Width || board.Height() != solve.Height {
+		return Board{}, fmt.Errorf(
+			"Regenerated board is %dx%d; the solve was of a %dx%d board",
+			board.Width(),
+			board.Height(),
+			solve.Width,
+			solve.Height,
+		)
+	}
+	return board, nil
+}
+
+// Only returns the replay with just the named player in it; the `bool` is
+// false if they aren't in it.
+func (rp Replay) Only(name string) (Replay, bool) {
+	for _, player := range rp.Players {
+		if player.Name == name {
+			rp.Players = []ReplayPlayer{player}
+			return rp, true
+		}
+	}
+	return rp, false
+}
+
+// Duration is how long the replay runs: until the last player finished.
+func (rp Replay) Duration() time.Duration {
+	var duration time.Duration
+	for _, player := range rp.Players {
+		if player.Duration > duration {
+			duration = player.Duration
+		}
+		if n := len(player.Path); n > 0 && player.Path[n-1].Elapsed > duration {
+			duration = player.Path[n-1].Elapsed
+		}
+	}
+	return duration
+}
+
+// ReplayStep is a recorded move, replayed by Replay.Play.
+type ReplayStep struct {
+	Move Move
+	Game Game // the game once the move was made
+
+	// Landed is where the move took the player in the replay, which is
+	// only different from where it was recorded going (Move.To) if the
+	// replay has diverged from the match
+	Landed Point
+}
+
+// Diverged reports whether the move went somewhere else in the replay than it
+// did in the match. That happens when players who didn't finish, and so left
+// no record, got in the way or picked up items the others then missed.
+func (step ReplayStep) Diverged() bool {
+	return step.Landed != step.Move.To
+}
+
+// Play replays the recorded moves through the game's rules, in the order
+// they were made, calling `f` after each one. Each player moves in the
+// direction they were recorded moving; pushes are taken as recorded, since
+// they were made by other players' moves. Shifts are made between the moves
+// they came between. If a move diverges from the match, the player is put
+// back where they were recorded going so one divergence doesn't spoil the
+// rest of the replay.
+func (rp Replay) Play(f func(step ReplayStep)) {
+	g := Game{
+		Board:       rp.Board,
+		Items:       rp.Board.Items,
+		Players:     make([]Player, 0, len(rp.Players)),
+		WindowSize:  Point{2*rp.Board.Width() + 1, 2*rp.Board.Height() + 1},
+		SolvedTimes: map[rune]time.Duration{},
+		Start:       time.Now(),
+	}
+	var moves []Move
+	for _, player := range rp.Players {
+		g = g.AddPlayer(player.Token)
+		moves = append(moves, player.Path...)
+	}
+	sort.SliceStable(moves, func(i, j int) bool {
+		return moves[i].Elapsed < moves[j].Elapsed
+	})
+
+	shifts := rp.Shifts
+	for _, move := range moves {
+		for len(shifts) > 0 && shifts[0].Elapsed < move.Elapsed {
+			for _, change := range shifts[0].Changes {
+				tile := tileSpace
+				if change.Wall {
+					tile = tileWall
+				}
+				g.Board = g.Board.SetTile(change.At, tile)
+			}
+			shifts = shifts[1:]
+		}
+
+		step := ReplayStep{Move: move, Landed: move.To}
+		if !move.Pushed {
+			g = g.PlayerMove(move.Player, move.Dir)
+			p, _ := g.Player(move.Player)
+			step.Landed = p.Pos
+		}
+		if move.Pushed || step.Diverged() {
+			g = g.MapPlayer(move.Player, func(p Player) Player {
+				p.Pos = move.To
+				return p
+			})
+		}
+		step.Game = g
+		f(step)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplayOnly(t *testing.T) {
+	replay := testReplay(t)
+	replay.Players = append(replay.Players, ReplayPlayer{
+		Token:    '$',
+		Name:     "bob",
+		Duration: 9 * time.Second,
+	})
+	if d := replay.Duration(); d != 9*time.Second {
+		t.Fatalf("Wanted the replay to last 9s; got %v", d)
+	}
+	only, found := replay.Only("<alice>")
+	if !found || len(only.Players) != 1 || only.Players[0].Token != '@' {
+		t.Fatalf("Wanted just '@' in the replay; got %v", only.Players)
+	}
+	if d := only.Duration(); d != 4*time.Second {
+		t.Fatalf("Wanted the replay to last 4s; got %v", d)
+	}
+	if _, found := replay.Only("carol"); found {
+		t.Fatal("Wanted carol not found")
+	}
+}
+
+func TestReplayPlay(t *testing.T) {
+	for _, testCase := range []struct {
+		name     string
+		shifts   []Shift
+		diverged []Point
+	}{{
+		name: "as-recorded",
+	}, {
+		// The wall closes in front of the player, who is put back on
+		// their recorded path.
+		name: "diverged",
+		shifts: []Shift{{
+			Elapsed: 1500 * time.Millisecond,
+			Changes: []TileChange{{At: Point{2, 1}, Wall: true}},
+		}},
+		diverged: []Point{{1, 1}},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			replay := testReplay(t)
+			replay.Shifts = testCase.shifts
+			var steps []ReplayStep
+			var diverged []Point
+			replay.Play(func(step ReplayStep) {
+				steps = append(steps, step)
+				if step.Diverged() {
+					diverged = append(diverged, step.Landed)
+				}
+			})
+			if len(steps) != 4 {
+				t.Fatalf("Wanted 4 steps; got %d", len(steps))
+			}
+			if !reflect.DeepEqual(diverged, testCase.diverged) {
+				t.Fatalf(
+					"Wanted divergences at %v; got %v",
+					testCase.diverged,
+					diverged,
+				)
+			}
+			g := steps[len(steps)-1].Game
+			if g.Winner != '@' {
+				t.Fatalf("Wanted '@' to finish; got %q", g.Winner)
+			}
+			if _, found := g.Items[Point{1, 1}]; found {
+				t.Fatal("Wanted the key picked up")
+			}
+		})
+	}
+}
+
+func TestNewSolution(t *testing.T) {
+	got := newSolution([]Point{{0, 1}, {1, 1}, {1, 2}, {0, 2}, {0, 1}})
+	want := Solution{
+		Length: 4,
+		Path:   []Point{{0, 1}, {1, 1}, {1, 2}, {0, 2}, {0, 1}},
+		Moves:  []string{"right", "down", "left", "up"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Wanted %#v; got %#v", want, got)
+	}
+}
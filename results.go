@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Solve is the record of a single player reaching the end of a board.
+type Solve struct {
+	MatchID    string        `json:"match_id"`
+	Player     string        `json:"player"`
+	Token      string        `json:"token"`
+	Seed       int64         `json:"seed"`
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	Duration   time.Duration `json:"duration"`
+	Moves      int           `json:"moves"`
+	Shortest   int           `json:"shortest"`
+	Efficiency float64       `json:"efficiency"`
+	Won        bool          `json:"won"`
+	Solo       bool          `json:"solo,omitempty"`
+	Daily      string        `json:"daily,omitempty"`
+	Maze       string        `json:"maze,omitempty"` // stored mazes only
+	Generator  string        `json:"generator,omitempty"`
+	Items      bool          `json:"items,omitempty"` // any items at all
+	Bot        bool          `json:"bot,omitempty"`
+	Finished   time.Time     `json:"finished"`
+	Path       []Move        `json:"path,omitempty"`
+	Shifts     []Shift       `json:"shifts,omitempty"` // shifting mazes only
+
+	// TickInterval is set for solves of tick-based games, and Shift for
+	// solves of shifting mazes
+	TickInterval time.Duration `json:"tick_interval,omitempty"`
+	Shift        time.Duration `json:"shift,omitempty"`
+
+	// Objective is set for games won some other way than by racing to the
+	// end (see ObjectiveRule)
+	Objective string `json:"objective,omitempty"`
+
+	// Beat lists the players who were still on the board when this solve
+	// finished; it drives the rating updates.
+	Beat []string `json:"beat,omitempty"`
+}
+
+// BoardKey identifies the board a solve was played on and how it was timed, so
+// that only solves of the same board are compared: the same seed of the same
+// stored maze or generated spec, played real-time or at the same tick
+// interval, shifting at the same interval or not at all. Sizes are in tiles.
+type BoardKey struct {
+	Seed         int64
+	Width        int
+	Height       int
+	Generator    string // generated boards only
+	Items        bool   // generated boards only
+	Maze         string // stored mazes only
+	TickInterval time.Duration
+	Shift        time.Duration
+}
+
+// BoardKey identifies the board the solve was played on. Solves recorded
+// before generators were fall back to the default generator.
+func (s Solve) BoardKey() BoardKey {
+	key := BoardKey{
+		Seed:         s.Seed,
+		Width:        s.Width,
+		Height:       s.Height,
+		Generator:    s.Generator,
+		Maze:         s.Maze,
+		TickInterval: s.TickInterval,
+		Shift:        s.Shift,
+	}
+	if key.Maze == "" {
+		key.Items = s.Items
+		if key.Generator == "" {
+			key.Generator = defaultGenerator
+		}
+	}
+	return key
+}
+
+// efficiency is the ratio of the shortest possible path to the path the player
+// actually took, so a perfect run scores 1.
+func efficiency(shortest, moves int) float64 {
+	if moves < 1 || shortest < 0 {
+		return 0
+	}
+	return float64(shortest) / float64(moves)
+}
+
+// Results is the store of every solve the server has seen. Solves are kept in
+// memory for querying and appended (as JSON lines) to a file so they survive
+// restarts. Ratings are derived from the solves, so they are rebuilt on load.
+type Results struct {
+	Mutex       sync.RWMutex
+	Solves      []Solve
+	ratings     map[string]float64
+	file        *os.File
+	subscribers map[chan Record]struct{}
+}
+
+// maxLineSize bounds a single stored JSON line; solves carry their full path,
+// so they can be much longer than bufio's default.
+const maxLineSize = 16 * 1024 * 1024
+
+// openJSONLines calls `load` with each line of the file at `path` and then
+// returns the file opened for appending. The file is created if it doesn't
+// exist.
+func openJSONLines(path string, load func(data []byte) error) (
+	*os.File,
+	error,
+) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := scanJSONLines(file, load); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// readJSONLines calls `load` with each line of the file at `path`, which is
+// only read, so it must already exist.
+func readJSONLines(path string, load func(data []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return scanJSONLines(file, load)
+}
+
+func scanJSONLines(file *os.File, load func(data []byte) error) error {
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		if err := load(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// appendJSONLine writes `v` to `file` as a single JSON line.
+func appendJSONLine(file *os.File, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// OpenResults loads any previously stored solves from `path` and opens it for
+// appending.
+func OpenResults(path string) (*Results, error) {
+	results := &Results{}
+	file, err := openJSONLines(path, results.load)
+	if err != nil {
+		return nil, err
+	}
+	results.file = file
+	return results, nil
+}
+
+// ReadResults loads the solves stored at `path` without opening it for
+// appending, for tools that only look at past results. Solves recorded in the
+// returned Results are kept in memory only.
+func ReadResults(path string) (*Results, error) {
+	results := &Results{}
+	if err := readJSONLines(path, results.load); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// load adds a stored solve while the results are being loaded.
+func (r *Results) load(data []byte) error {
+	var solve Solve
+	if err := json.Unmarshal(data, &solve); err != nil {
+		return err
+	}
+	r.Solves = append(r.Solves, solve)
+	r.rate(solve)
+	return nil
+}
+
+// Record stores a solve and notifies subscribers of any leaderboard records
+// it breaks. The solve is kept in memory even if persisting it fails.
+func (r *Results) Record(solve Solve) error {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	r.Solves = append(r.Solves, solve)
+	r.rate(solve)
+
+	for _, board := range solveBoards(solve) {
+		if leader := r.leader(board); brokeRecord(solve, leader) {
+			r.publish(Record{Board: board, Entry: *leader})
+		}
+	}
+
+	if r.file == nil {
+		return nil
+	}
+	return appendJSONLine(r.file, solve)
+}
+
+// Subscribe returns a channel on which broken records will be published. The
+// channel must be released with Unsubscribe.
+func (r *Results) Subscribe() chan Record {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	if r.subscribers == nil {
+		r.subscribers = map[chan Record]struct{}{}
+	}
+	c := make(chan Record, 16)
+	r.subscribers[c] = struct{}{}
+	return c
+}
+
+func (r *Results) Unsubscribe(c chan Record) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	delete(r.subscribers, c)
+}
+
+// publish assumes the mutex is already locked. Slow subscribers miss records
+// rather than stalling the game that produced them.
+func (r *Results) publish(record Record) {
+	for c := range r.subscribers {
+		select {
+		case c <- record:
+		default:
+		}
+	}
+}
+
+// PersonalBest returns the player's fastest solve of the board identified by
+// `board`. Only races to the end count. The `bool` is false if the player has
+// never solved it.
+func (r *Results) PersonalBest(player string, board BoardKey) (Solve, bool) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	var best *Solve
+	for i, solve := range r.Solves {
+		if solve.Player == player && solve.Objective == "" &&
+			solve.BoardKey() == board &&
+			(best == nil || fasterSolve(solve, *best)) {
+			best = &r.Solves[i]
+		}
+	}
+	if best == nil {
+		return Solve{}, false
+	}
+	return *best, true
+}
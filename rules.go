@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Rules are the options a lobby's games are played with. Players are only
+// matched with others who asked for the same rules.
+type Rules struct {
+	// TickInterval is non-zero for tick-based games
+	TickInterval time.Duration `json:"tick_interval,omitempty"`
+	Collisions   CollisionRule `json:"collisions"`
+	Items        bool          `json:"items,omitempty"`
+	Objective    ObjectiveRule `json:"objective"`
+
+	// Shift is non-zero for shifting mazes
+	Shift time.Duration `json:"shift,omitempty"`
+
+	// Teams is non-zero for team games, which are scored by Scoring
+	Teams   int         `json:"teams,omitempty"`
+	Scoring TeamScoring `json:"scoring"`
+
+	// TimeLimit and Grace end matches (see Game.Over) when non-zero
+	TimeLimit time.Duration `json:"time_limit,omitempty"`
+	Grace     time.Duration `json:"grace,omitempty"`
+
+	// Maze names a maze from the library to play instead of a generated one
+	Maze string `json:"maze,omitempty"`
+
+	// Bots names the level of the bots lobbies are filled with when too few
+	// people join; empty means defaultBotLevel (see BotLevel)
+	Bots string `json:"bots,omitempty"`
+}
+
+// defaultBotLevel is what lobbies are filled with unless the rules say
+// otherwise: one that people can beat.
+const defaultBotLevel = BotWallFollower
+
+// BotLevel returns the level of bots the rules ask for.
+func (rules Rules) BotLevel() BotLevel {
+	level, err := ParseBotLevel(rules.Bots)
+	if err != nil {
+		return defaultBotLevel
+	}
+	return level
+}
+
+// Bounds on the tick interval players can ask for
+const (
+	minTickInterval = 50 * time.Millisecond
+	maxTickInterval = 5 * time.Second
+)
+
+// queryRules returns the rules the player asked for with the `tick` (e.g.
+// `?tick=200ms`), `collisions` (e.g. `?collisions=push`) and `items` (e.g.
+// `?items=true`), `shift` (e.g. `?shift=10s`), `teams` (e.g. `?teams=2`),
+// `scoring` (e.g. `?scoring=total`), `limit` (e.g. `?limit=5m`), `grace`
+// (e.g. `?grace=30s`), `maze` (e.g. `?maze=spiral`) and `bots` (e.g.
+// `?bots=tremaux`) query parameters, along with the objective (see
+// queryObjectiveRule). By default games are real-time, free-for-all races to
+// the end on generated boards, players pass through each other, boards have
+// no items and they never change, matches only end once everybody has
+// finished, and lobbies are filled with wall followers. Whether the maze
+// exists is up to the caller to check.
+func queryRules(r *http.Request) (Rules, error) {
+	return parseRules(r.URL.Query())
+}
+
+// parseRules parses rules from query parameters (see queryRules).
+func parseRules(query url.Values) (Rules, error) {
+	var rules Rules
+	if value := query.Get("tick"); value != "" {
+		tickInterval, err := time.ParseDuration(value)
+		if err != nil {
+			return Rules{}, err
+		}
+		if tickInterval < minTickInterval || tickInterval > maxTickInterval {
+			return Rules{}, fmt.Errorf(
+				"Wanted tick interval between %v and %v; got %v",
+				minTickInterval,
+				maxTickInterval,
+				tickInterval,
+			)
+		}
+		rules.TickInterval = tickInterval
+	}
+	if value := query.Get("collisions"); value != "" {
+		collisions, err := ParseCollisionRule(value)
+		if err != nil {
+			return Rules{}, err
+		}
+		rules.Collisions = collisions
+	}
+	if value := query.Get("items"); value != "" {
+		items, err := strconv.ParseBool(value)
+		if err != nil {
+			return Rules{}, err
+		}
+		rules.Items = items
+	}
+	if value := query.Get("shift"); value != "" {
+		shift, err := time.ParseDuration(value)
+		if err != nil {
+			return Rules{}, err
+		}
+		if shift < minShiftInterval || shift > maxShiftInterval {
+			return Rules{}, fmt.Errorf(
+				"Wanted shift interval between %v and %v; got %v",
+				minShiftInterval,
+				maxShiftInterval,
+				shift,
+			)
+		}
+		rules.Shift = shift
+	}
+	if value := query.Get("teams"); value != "" {
+		teams, err := strconv.Atoi(value)
+		if err != nil {
+			return Rules{}, err
+		}
+		if teams < minTeams || teams > maxTeams() {
+			return Rules{}, fmt.Errorf(
+				"Wanted between %d and %d teams; got %d",
+				minTeams,
+				maxTeams(),
+				teams,
+			)
+		}
+		rules.Teams = teams
+	}
+	if value := query.Get("scoring"); value != "" {
+		scoring, err := ParseTeamScoring(value)
+		if err != nil {
+			return Rules{}, err
+		}
+		rules.Scoring = scoring
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := time.ParseDuration(value)
+		if err != nil {
+			return Rules{}, err
+		}
+		if limit < minTimeLimit || limit > maxTimeLimit {
+			return Rules{}, fmt.Errorf(
+				"Wanted time limit between %v and %v; got %v",
+				minTimeLimit,
+				maxTimeLimit,
+				limit,
+			)
+		}
+		rules.TimeLimit = limit
+	}
+	if value := query.Get("grace"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err != nil {
+			return Rules{}, err
+		}
+		if grace < minGrace || grace > maxGrace {
+			return Rules{}, fmt.Errorf(
+				"Wanted grace period between %v and %v; got %v",
+				minGrace,
+				maxGrace,
+				grace,
+			)
+		}
+		rules.Grace = grace
+	}
+	rules.Maze = query.Get("maze")
+	if value := query.Get("bots"); value != "" {
+		if _, err := ParseBotLevel(value); err != nil {
+			return Rules{}, err
+		}
+		rules.Bots = value
+	}
+	objective, err := queryObjectiveRule(query)
+	if err != nil {
+		return Rules{}, err
+	}
+	rules.Objective = objective
+	return rules, nil
+}
+
+// CollisionRule decides what happens when a player moves onto a tile another
+// player is standing on.
+type CollisionRule int
+
+const (
+	// CollisionNone lets players pass through each other
+	CollisionNone CollisionRule = iota
+
+	// CollisionBlock makes occupied tiles impassable
+	CollisionBlock
+
+	// CollisionPush shoves the other player one tile along, provided there
+	// is room for them; otherwise the move is blocked
+	CollisionPush
+)
+
+var collisionRuleNames = map[CollisionRule]string{
+	CollisionNone:  "none",
+	CollisionBlock: "block",
+	CollisionPush:  "push",
+}
+
+func (c CollisionRule) String() string {
+	if name, found := collisionRuleNames[c]; found {
+		return name
+	}
+	return fmt.Sprintf("CollisionRule(%d)", int(c))
+}
+
+func (c CollisionRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+func ParseCollisionRule(s string) (CollisionRule, error) {
+	for c, name := range collisionRuleNames {
+		if name == s {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown collision rule: %s", s)
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
@@ -12,8 +20,24 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+const (
+	defaultLeaderboardLimit = 25
+	maxLeaderboardLimit     = 100
+)
+
 type Server struct {
-	GameManager GameManager
+	GameManager    GameManager
+	BotAccounts    *Accounts
+	PlayerAccounts *Accounts
+	Mazes          *MazeLibrary
+	Events         SSESessions
+}
+
+// StatsState is pushed over the stats socket. Lobbies are pushed once a second
+// and records are pushed as soon as they are broken.
+type StatsState struct {
+	Lobbies []LobbyState `json:"lobbies,omitempty"`
+	Record  *Record      `json:"record,omitempty"`
 }
 
 func (s *Server) Stats(
@@ -28,20 +52,115 @@ func (s *Server) Stats(
 		return
 	}
 	defer conn.Close()
+	defer metrics.Connect("stats-socket")()
 
 	t := time.NewTicker(time.Second)
 	defer t.Stop()
 
+	records := s.GameManager.Results.Subscribe()
+	defer s.GameManager.Results.Unsubscribe(records)
+
 	for {
-		<-t.C
-		if err := conn.WriteJSON(s.GameManager.State()); err != nil {
+		var state StatsState
+		select {
+		case <-t.C:
+			state.Lobbies = s.GameManager.State()
+		case record := <-records:
+			state.Record = &record
+		}
+		if err := conn.WriteJSON(state); err != nil {
+			metrics.WebsocketWriteErrors.With("stats").Inc()
 			logger.Logf("Error writing to websocket: %v", err)
 			return
 		}
 	}
 }
 
+// queryInt returns the integer value of the query parameter `key`, or `def` if
+// the parameter is absent.
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func (s *Server) Leaderboard(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	vars := mux.Vars(r)
+	board := vars["kind"]
+	if key := vars["key"]; key != "" {
+		board += "/" + key
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		logger.Logf("Invalid offset: %s", r.URL.Query().Get("offset"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultLeaderboardLimit)
+	if err != nil || limit < 1 || limit > maxLeaderboardLimit {
+		logger.Logf("Invalid limit: %s", r.URL.Query().Get("limit"))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, err := s.GameManager.Results.Leaderboard(board, offset, limit)
+	if _, ok := err.(InvalidLeaderboardError); ok {
+		logger.Logf("Error fetching leaderboard: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger.Logf("Error fetching leaderboard: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, logger, page)
+}
+
+// rules returns the rules the player asked for (see queryRules), making sure
+// the maze they asked for, if any, is in the library.
+func (s *Server) rules(r *http.Request) (Rules, error) {
+	return s.checkRules(queryRules(r))
+}
+
+// checkRules makes sure the maze the rules ask for, if any, is in the library.
+// It passes along any error from parsing the rules.
+func (s *Server) checkRules(rules Rules, err error) (Rules, error) {
+	if err != nil || rules.Maze == "" {
+		return rules, err
+	}
+	if _, found := s.Mazes.Get(rules.Maze); !found {
+		return Rules{}, fmt.Errorf("Unknown maze: %s", rules.Maze)
+	}
+	return rules, nil
+}
+
 func (s *Server) User(w http.ResponseWriter, r *http.Request, logger *Logger) {
+	name, authenticated, err := s.player(r)
+	if err != nil {
+		logger.Logf("Invalid player: %v", err)
+		if err == ErrUnknownKey {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+	rules, err := s.rules(r)
+	if err != nil {
+		logger.Logf("Invalid rules: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.Logf("Error upgrading to websocket connection: %v", err)
@@ -49,7 +168,474 @@ func (s *Server) User(w http.ResponseWriter, r *http.Request, logger *Logger) {
 		return
 	}
 	defer conn.Close()
+	defer metrics.Connect("user-socket")()
 
-	userSession := NewUserSession(&s.GameManager, conn, logger)
+	userSession := NewUserSession(
+		&s.GameManager,
+		WebsocketTransport{conn},
+		name,
+		authenticated,
+		rules,
+		logger,
+	)
 	userSession.Run()
 }
+
+// UserEvents plays as the user socket does, for browsers that can't open
+// one: states are streamed as server-sent events and commands are posted to
+// UserCommand with the session token sent as the first event.
+func (s *Server) UserEvents(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	name, authenticated, err := s.player(r)
+	if err != nil {
+		logger.Logf("Invalid player: %v", err)
+		if err == ErrUnknownKey {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+	rules, err := s.rules(r)
+	if err != nil {
+		logger.Logf("Invalid rules: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	transport, err := NewSSETransport(w, r.Context().Done())
+	if err != nil {
+		logger.Logf("Error starting event stream: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer metrics.Connect("user-events")()
+	token := s.Events.Add(transport)
+	defer s.Events.Remove(token)
+	if err := transport.SendSession(token); err != nil {
+		logger.Logf("Error sending session token: %v", err)
+		return
+	}
+
+	NewUserSession(
+		&s.GameManager,
+		transport,
+		name,
+		authenticated,
+		rules,
+		logger,
+	).Run()
+}
+
+// UserCommand passes a command, posted as the request body, to the event
+// stream session with the token in the path.
+func (s *Server) UserCommand(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	transport, found := s.Events.Get(mux.Vars(r)["token"])
+	if !found {
+		logger.Logf("Unknown session token")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	command, err := ioutil.ReadAll(
+		http.MaxBytesReader(w, r.Body, maxCommandBytes),
+	)
+	if err != nil {
+		logger.Logf("Error reading command: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := transport.Post(string(command)); err != nil {
+		logger.Logf("Error posting command: %v", err)
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Metrics serves the server's metrics in Prometheus's text format.
+func (s *Server) Metrics(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := metrics.Write(w, s.GameManager.State()); err != nil {
+		logger.Logf("Error writing to HTTP response writer: %v", err)
+	}
+}
+
+// Bot serves the bot socket. Bots must authenticate with an API key from
+// RegisterBot.
+func (s *Server) Bot(w http.ResponseWriter, r *http.Request, logger *Logger) {
+	account, found := s.BotAccounts.Authenticate(apiKey(r))
+	if !found {
+		logger.Logf("Invalid bot API key")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	rules, err := s.rules(r)
+	if err != nil {
+		logger.Logf("Invalid rules: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Logf("Error upgrading to websocket connection: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	defer metrics.Connect("bot-socket")()
+
+	NewBotSession(
+		&s.GameManager,
+		WebsocketTransport{Conn: conn},
+		account,
+		rules,
+		logger,
+	).Run()
+}
+
+// Registration is the response to registering a bot or player. The key is
+// only ever shown once.
+type Registration struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+func (s *Server) RegisterBot(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	name := r.URL.Query().Get("name")
+	key, err := s.BotAccounts.Register(name)
+	if err != nil {
+		logger.Logf("Error registering bot '%s': %v", name, err)
+		switch err {
+		case ErrInvalidBotName:
+			w.WriteHeader(http.StatusBadRequest)
+		case ErrBotNameTaken:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, logger, Registration{Name: "bot:" + name, Key: key})
+}
+
+// RegisterPlayer registers a player so that they can play as themselves with
+// the key they're given (see Server.player), which is what their daily
+// challenge attempts are counted and ranked by.
+func (s *Server) RegisterPlayer(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	name := r.URL.Query().Get("name")
+	key, err := s.PlayerAccounts.Register(name)
+	if err != nil {
+		logger.Logf("Error registering player '%s': %v", name, err)
+		switch err {
+		case ErrInvalidName:
+			w.WriteHeader(http.StatusBadRequest)
+		case ErrNameTaken:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, logger, Registration{Name: name, Key: key})
+}
+
+// UploadMaze adds the maze in the request body to the library under the name
+// given by the `name` query parameter, credited to the `author` one. The maze
+// is text unless the `format` query parameter says otherwise.
+func (s *Server) UploadMaze(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	format, err := queryBoardFormat(r)
+	if err != nil {
+		logger.Logf("Error uploading maze: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// One byte past the limit is read to tell mazes that are too big from
+	// errors reading the body.
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxMazeBytes+1))
+	if err != nil {
+		logger.Logf("Error reading maze: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxMazeBytes {
+		logger.Logf("Maze larger than %d bytes", maxMazeBytes)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	maze, err := s.Mazes.Add(
+		name,
+		r.URL.Query().Get("author"),
+		format,
+		data,
+	)
+	if err != nil {
+		logger.Logf("Error uploading maze '%s': %v", name, err)
+		if invalid, ok := err.(InvalidMazeError); ok {
+			errs, _ := invalid.Err.(ParseErrors)
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, logger, MazeError{Error: err.Error(), Errors: errs})
+			return
+		}
+		switch err {
+		case ErrInvalidMazeName:
+			w.WriteHeader(http.StatusBadRequest)
+		case ErrMazeNameTaken:
+			w.WriteHeader(http.StatusConflict)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, logger, maze)
+}
+
+// MazeError explains why an uploaded maze was rejected. Errors lists the
+// problems with its layout, if that's what's wrong with it.
+type MazeError struct {
+	Error  string      `json:"error"`
+	Errors ParseErrors `json:"errors,omitempty"`
+}
+
+// MazeList lists the mazes in the library.
+func (s *Server) MazeList(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	writeJSON(w, logger, s.Mazes.List())
+}
+
+// Maze returns a maze from the library, including the maze itself. Given a
+// `format` query parameter, it returns just the maze, written in that format.
+func (s *Server) Maze(w http.ResponseWriter, r *http.Request, logger *Logger) {
+	maze, found := s.Mazes.Get(mux.Vars(r)["name"])
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.URL.Query().Get("format") == "" {
+		writeJSON(w, logger, maze)
+		return
+	}
+	format, err := queryBoardFormat(r)
+	if err != nil {
+		logger.Logf("Error exporting maze '%s': %v", maze.Name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	err = EncodeBoard(&buf, maze.Board(), format, map[string]string{
+		"name":     maze.Name,
+		"author":   maze.Author,
+		"uploaded": maze.Uploaded.Format(time.RFC3339),
+	})
+	if err != nil {
+		logger.Logf("Error exporting maze '%s': %v", maze.Name, err)
+		if err == ErrNotCellBoard {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	if _, err := buf.WriteTo(w); err != nil {
+		logger.Logf("Error writing maze '%s': %v", maze.Name, err)
+	}
+}
+
+// queryBoardFormat returns the board format named by the `format` query
+// parameter, which defaults to text.
+func queryBoardFormat(r *http.Request) (BoardFormat, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		return ParseBoardFormat(name)
+	}
+	return FormatText, nil
+}
+
+// Render draws a recorded match: a still image of the board with each
+// finisher's path (`?format=png`, the default, or `svg`) or an animated
+// replay (`gif`). `player` narrows it down to one player and `scale` sets how
+// many pixels each tile is drawn with.
+func (s *Server) Render(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	query := r.URL.Query()
+	format, scale, err := renderOptions(query.Get("format"), query.Get("scale"))
+	if err != nil {
+		logger.Logf("Error rendering match: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := mux.Vars(r)["matchID"]
+	replay, err := NewReplay(s.GameManager.Results.Match(id), s.Mazes)
+	if err != nil {
+		logger.Logf("Error replaying match '%s': %v", id, err)
+		if err == ErrMatchNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if player := query.Get("player"); player != "" {
+		var found bool
+		if replay, found = replay.Only(player); !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := replay.Render(&buf, format, scale); err != nil {
+		logger.Logf("Error rendering match '%s': %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	if _, err := buf.WriteTo(w); err != nil {
+		logger.Logf("Error writing render of match '%s': %v", id, err)
+	}
+}
+
+// renderOptions parses a render format and scale, either of which may be
+// empty to get the default: a PNG with defaultRenderScale pixels a tile.
+func renderOptions(format, scale string) (RenderFormat, int, error) {
+	f := RenderPNG
+	if format != "" {
+		var err error
+		if f, err = ParseRenderFormat(format); err != nil {
+			return 0, 0, err
+		}
+	}
+	n := defaultRenderScale
+	if scale != "" {
+		var err error
+		if n, err = strconv.Atoi(scale); err != nil {
+			return 0, 0, fmt.Errorf("Invalid scale: %s", scale)
+		}
+		if n < minRenderScale || n > maxRenderScale {
+			return 0, 0, fmt.Errorf(
+				"Scale must be between %d and %d; got %d",
+				minRenderScale,
+				maxRenderScale,
+				n,
+			)
+		}
+	}
+	return f, n, nil
+}
+
+// player returns who the person is playing as and whether they proved it
+// (see identify), going by their API key (see apiKey) and the `name` query
+// parameter.
+func (s *Server) player(r *http.Request) (string, bool, error) {
+	return s.identify(apiKey(r), r.URL.Query().Get("name"))
+}
+
+// identify returns who a person is playing as and whether they proved it: the
+// player account `key` belongs to if they gave one, or else `name` (see
+// playerName). Registered names can only be played with their key.
+func (s *Server) identify(key, name string) (string, bool, error) {
+	if key != "" {
+		if s.PlayerAccounts == nil {
+			return "", false, ErrUnknownKey
+		}
+		account, found := s.PlayerAccounts.Authenticate(key)
+		if !found {
+			return "", false, ErrUnknownKey
+		}
+		return account.Name, true, nil
+	}
+	name, err := playerName(name)
+	if err == nil && s.PlayerAccounts != nil &&
+		s.PlayerAccounts.Registered(name) {
+		err = ErrNameTaken
+	}
+	return name, false, err
+}
+
+// apiKey returns the API key from either the `key` query parameter or a
+// bearer token.
+func apiKey(r *http.Request) string {
+	if key := r.URL.Query().Get("key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// anonymousName is who people who don't give a name play as.
+const anonymousName = "anonymous"
+
+// playerName checks the name a person asked to play as, falling back to
+// anonymousName when none was given. Names are checked as bot names are, so
+// nobody can play under a bot's name.
+func playerName(name string) (string, error) {
+	if name == "" {
+		return anonymousName, nil
+	}
+	if !validName(name) {
+		return "", ErrInvalidName
+	}
+	return name, nil
+}
+
+// DailyState describes today's daily challenge.
+type DailyState struct {
+	Day  string    `json:"day"`
+	Spec BoardSpec `json:"spec"`
+}
+
+func (s *Server) Daily(w http.ResponseWriter, r *http.Request, logger *Logger) {
+	day, spec := s.GameManager.Daily.Today()
+	writeJSON(w, logger, DailyState{Day: day, Spec: spec})
+}
+
+func (s *Server) DailyArchive(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *Logger,
+) {
+	writeJSON(
+		w,
+		logger,
+		s.GameManager.Daily.Archive(s.GameManager.Results),
+	)
+}
+
+func writeJSON(w http.ResponseWriter, logger *Logger, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Logf("Error writing to HTTP response writer: %v", err)
+	}
+}
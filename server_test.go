@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// errReader fails every read, as a dropped upload would.
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestUploadMaze(t *testing.T) {
+	corridor := "#####\nS   E\n#####\n"
+	for _, testCase := range []struct {
+		name   string
+		query  string
+		body   io.Reader
+		status int
+	}{{
+		name:   "valid",
+		query:  "?name=corridor",
+		body:   strings.NewReader(corridor),
+		status: http.StatusCreated,
+	}, {
+		name:   "name-taken",
+		query:  "?name=taken",
+		body:   strings.NewReader(corridor),
+		status: http.StatusConflict,
+	}, {
+		name:   "invalid-name",
+		query:  "?name=a/b",
+		body:   strings.NewReader(corridor),
+		status: http.StatusBadRequest,
+	}, {
+		name:   "invalid-maze",
+		query:  "?name=illegal",
+		body:   strings.NewReader("#####\nS x E\n#####\n"),
+		status: http.StatusBadRequest,
+	}, {
+		name:   "unknown-format",
+		query:  "?name=corridor&format=doc",
+		body:   strings.NewReader(corridor),
+		status: http.StatusBadRequest,
+	}, {
+		name:   "too-large",
+		query:  "?name=huge",
+		body:   strings.NewReader(strings.Repeat("#", maxMazeBytes+1)),
+		status: http.StatusRequestEntityTooLarge,
+	}, {
+		name:   "read-error",
+		query:  "?name=corridor",
+		body:   errReader{},
+		status: http.StatusBadRequest,
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			s := Server{Mazes: &MazeLibrary{Mazes: map[string]StoredMaze{
+				"taken": {Name: "taken"},
+			}}}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(
+				"POST",
+				"/mazes/"+testCase.query,
+				testCase.body,
+			)
+			s.UploadMaze(w, r, &Logger{})
+			if w.Code != testCase.status {
+				t.Fatalf(
+					"Wanted status %d; got %d (%s)",
+					testCase.status,
+					w.Code,
+					w.Body,
+				)
+			}
+		})
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	accounts := &Accounts{Accounts: map[string]Account{}}
+	key, err := accounts.Register("alice")
+	if err != nil {
+		t.Fatalf("Unexpected error registering: %v", err)
+	}
+	s := Server{PlayerAccounts: accounts}
+	for _, testCase := range []struct {
+		name              string
+		key               string
+		player            string
+		wantName          string
+		wantAuthenticated bool
+		wantErr           error
+	}{{
+		name:              "key",
+		key:               key,
+		player:            "bob",
+		wantName:          "alice",
+		wantAuthenticated: true,
+	}, {
+		name:    "unknown-key",
+		key:     "not-a-key",
+		wantErr: ErrUnknownKey,
+	}, {
+		name:    "registered-name",
+		player:  "alice",
+		wantErr: ErrNameTaken,
+	}, {
+		name:     "name",
+		player:   "bob",
+		wantName: "bob",
+	}, {
+		name:     "anonymous",
+		wantName: anonymousName,
+	}, {
+		name:    "invalid-name",
+		player:  "bot:optimal",
+		wantErr: ErrInvalidName,
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			name, authenticated, err := s.identify(
+				testCase.key,
+				testCase.player,
+			)
+			if err != testCase.wantErr {
+				t.Fatalf("Wanted error %v; got %v", testCase.wantErr, err)
+			}
+			if err == nil && (name != testCase.wantName ||
+				authenticated != testCase.wantAuthenticated) {
+				t.Fatalf(
+					"Wanted %s, %t; got %s, %t",
+					testCase.wantName,
+					testCase.wantAuthenticated,
+					name,
+					authenticated,
+				)
+			}
+		})
+	}
+}
+
+func TestLeaderboardStatus(t *testing.T) {
+	for _, testCase := range []struct {
+		name   string
+		path   string
+		status int
+	}{{
+		name:   "valid",
+		path:   "/leaderboards/seed/1,9x9,default/",
+		status: http.StatusOK,
+	}, {
+		name:   "invalid-seed",
+		path:   "/leaderboards/seed/abc,9x9,default/",
+		status: http.StatusBadRequest,
+	}, {
+		name:   "invalid-size",
+		path:   "/leaderboards/size/big/",
+		status: http.StatusBadRequest,
+	}, {
+		name:   "unknown",
+		path:   "/leaderboards/fastest/",
+		status: http.StatusNotFound,
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			s := Server{GameManager: GameManager{Results: &Results{}}}
+			router := mux.NewRouter()
+			handle := func(w http.ResponseWriter, r *http.Request) {
+				s.Leaderboard(w, r, &Logger{})
+			}
+			router.Path("/leaderboards/{kind}/").HandlerFunc(handle)
+			router.Path("/leaderboards/{kind}/{key:.+}/").HandlerFunc(handle)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", testCase.path, nil))
+			if w.Code != testCase.status {
+				t.Fatalf(
+					"Wanted status %d; got %d (%s)",
+					testCase.status,
+					w.Code,
+					w.Body,
+				)
+			}
+		})
+	}
+}
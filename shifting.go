@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// shiftTiles is how many walls each shift of the board opens, and how many it
+// closes (if it can).
+const shiftTiles = 3
+
+// Bounds on the shift interval players can ask for
+const (
+	minShiftInterval = time.Second
+	maxShiftInterval = time.Minute
+)
+
+// TileChange is a single tile of the board turning into a wall or a space.
+type TileChange struct {
+	At   Point `json:"at"`
+	Wall bool  `json:"wall"`
+}
+
+// Shift is a set of changes made to the board at once, recorded so games can
+// be replayed.
+type Shift struct {
+	Elapsed time.Duration `json:"elapsed"`
+	Tick    int           `json:"tick,omitempty"` // tick-based games only
+	Changes []TileChange  `json:"changes"`
+}
+
+// Shift opens and closes a few walls at random. A wall is only closed if
+// every player still playing, every item and every goal stays connected to the
+// end, so the board is always solvable. The changes are appended to Shifts
+// and kept in Changes until the next move or tick.
+func (g Game) Shift() Game {
+	// Shifts are numbered into the seed so replays shift identically.
+	rng := rand.New(rand.NewSource(g.Seed + int64(len(g.Shifts)) + 1))
+
+	// Walls between cells sit where exactly one coordinate is odd; the
+	// border is left alone.
+	var walls, spaces []Point
+	for y := 1; y < g.Board.Height()-1; y++ {
+		for x := 1; x < g.Board.Width()-1; x++ {
+			p := Point{x, y}
+			if (x+y)%2 == 0 {
+				continue
+			}
+			if g.Board.Rows[y][x] == tileWall {
+				walls = append(walls, p)
+			} else if g.shiftable(p) {
+				spaces = append(spaces, p)
+			}
+		}
+	}
+
+	var changes []TileChange
+	opened := shiftTiles
+	if opened > len(walls) {
+		opened = len(walls)
+	}
+	for _, i := range rng.Perm(len(walls))[:opened] {
+		g.Board = g.Board.SetTile(walls[i], tileSpace)
+		changes = append(changes, TileChange{At: walls[i]})
+	}
+	closed := 0
+	for _, i := range rng.Perm(len(spaces)) {
+		if closed >= shiftTiles {
+			break
+		}
+		if board := g.Board.SetTile(spaces[i], tileWall); g.connected(board) {
+			g.Board = board
+			changes = append(changes, TileChange{At: spaces[i], Wall: true})
+			closed++
+		}
+	}
+
+	g.Changes = changes
+	shifts := make([]Shift, len(g.Shifts), len(g.Shifts)+1)
+	copy(shifts, g.Shifts)
+	g.Shifts = append(shifts, Shift{
+		Elapsed: g.Elapsed(),
+		Tick:    g.Tick,
+		Changes: changes,
+	})
+	return g
+}
+
+// lastShift returns how far into the game the board last shifted.
+func (g Game) lastShift() time.Duration {
+	if len(g.Shifts) < 1 {
+		return 0
+	}
+	return g.Shifts[len(g.Shifts)-1].Elapsed
+}
+
+// shiftable reports whether a wall could be put on the open tile `p`: nothing
+// may be standing or lying on it.
+func (g Game) shiftable(p Point) bool {
+	if tile := g.Board.Rows[p.Y][p.X]; tile != tileSpace {
+		return false
+	}
+	if _, found := g.Items[p]; found {
+		return false
+	}
+	for _, goal := range g.Goals {
+		if goal == p {
+			return false
+		}
+	}
+	for _, player := range g.Players {
+		if player.Pos == p {
+			return false
+		}
+	}
+	return true
+}
+
+// connected reports whether every active player, item and goal can reach the
+// end of `board`. Doors count as walls, since there may be no key left for
+// them.
+func (g Game) connected(board Board) bool {
+	reached := map[Point]bool{board.End: true}
+	queue := []Point{board.End}
+	for i := 0; i < len(queue); i++ {
+		for _, d := range dirs {
+			next := queue[i].Translate(d)
+			if !reached[next] && board.IsPath(next) &&
+				g.Items[next] != ItemDoor {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	for _, player := range g.Players {
+		if g.active(player) && !reached[player.Pos] {
+			return false
+		}
+	}
+	for p, item := range g.Items {
+		if item != ItemDoor && !reached[p] {
+			return false
+		}
+	}
+	for _, goal := range g.Goals {
+		if !reached[goal] {
+			return false
+		}
+	}
+	return true
+}
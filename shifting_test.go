@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newShiftingGame starts a real-time game on a generated board with a player
+// at the start.
+func newShiftingGame(seed int64) Game {
+	return Game{
+		Seed:          seed,
+		Board:         GenerateBinaryTreeBoard(seed, 8, 8),
+		SolvedTimes:   map[rune]time.Duration{},
+		ShiftInterval: time.Second,
+		Start:         time.Now(),
+	}.AddPlayer('@')
+}
+
+func TestShift(t *testing.T) {
+	g := newShiftingGame(1)
+	before := g.Board
+	for i := 1; i <= 5; i++ {
+		g = g.Shift()
+		if len(g.Shifts) != i {
+			t.Fatalf("Wanted %d shifts recorded; got %d", i, len(g.Shifts))
+		}
+		if !reflect.DeepEqual(g.Changes, g.Shifts[i-1].Changes) {
+			t.Fatalf("Wanted the latest changes kept; got %v", g.Changes)
+		}
+		opened, closed := 0, 0
+		for _, change := range g.Changes {
+			p := change.At
+			if p.X < 1 || p.Y < 1 || p.X >= g.Board.Width()-1 ||
+				p.Y >= g.Board.Height()-1 {
+				t.Fatalf("Wanted the border left alone; got %v", p)
+			}
+			if change.Wall {
+				closed++
+			} else {
+				opened++
+			}
+			if isWall := g.Board.Rows[p.Y][p.X] == tileWall; isWall !=
+				change.Wall {
+				t.Fatalf("Wanted %v applied to the board", change)
+			}
+		}
+		if opened != shiftTiles || closed > shiftTiles {
+			t.Fatalf(
+				"Wanted %d walls opened and at most as many closed; "+
+					"got %d and %d",
+				shiftTiles,
+				opened,
+				closed,
+			)
+		}
+		if !g.connected(g.Board) {
+			t.Fatal("Wanted the end still reachable")
+		}
+	}
+	if !reflect.DeepEqual(before, newShiftingGame(1).Board) {
+		t.Fatal("Wanted shifting to leave the original board alone")
+	}
+}
+
+func TestShiftReplays(t *testing.T) {
+	// The same seed shifts the same way, so recorded games can be replayed.
+	a, b := newShiftingGame(7), newShiftingGame(7)
+	for i := 0; i < 3; i++ {
+		a, b = a.Shift(), b.Shift()
+	}
+	if !reflect.DeepEqual(a.Board, b.Board) {
+		t.Fatal("Wanted the same board after the same shifts")
+	}
+	for i := range a.Shifts {
+		if !reflect.DeepEqual(a.Shifts[i].Changes, b.Shifts[i].Changes) {
+			t.Fatalf(
+				"Wanted shift %d to match; got %v and %v",
+				i,
+				a.Shifts[i].Changes,
+				b.Shifts[i].Changes,
+			)
+		}
+	}
+}
+
+func TestStepShifts(t *testing.T) {
+	// Tick-based games shift once the interval has passed in ticks.
+	g := newShiftingGame(3)
+	g.TickInterval = 100 * time.Millisecond
+	g.ShiftInterval = 200 * time.Millisecond
+	for tick, want := range []int{0, 1, 1, 2} {
+		g = g.Step(map[rune]Dir{})
+		if len(g.Shifts) != want {
+			t.Fatalf(
+				"Wanted %d shifts after tick %d; got %d",
+				want,
+				tick+1,
+				len(g.Shifts),
+			)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGhostPos(t *testing.T) {
+	g := Game{
+		Board: Board{Start: Point{0, 1}},
+		Ghost: []Move{
+			{Dir: Right, To: Point{1, 1}, Elapsed: time.Second},
+			{Dir: Right, To: Point{2, 1}, Elapsed: 2 * time.Second},
+		},
+	}
+	for _, testCase := range []struct {
+		elapsed time.Duration
+		want    Point
+	}{
+		{0, Point{0, 1}},
+		{time.Second, Point{1, 1}},
+		{1500 * time.Millisecond, Point{1, 1}},
+		{time.Minute, Point{2, 1}},
+	} {
+		if pos, ok := g.GhostPos(testCase.elapsed); !ok ||
+			pos != testCase.want {
+			t.Errorf(
+				"Wanted the ghost at %v after %v; got %v (%t)",
+				testCase.want,
+				testCase.elapsed,
+				pos,
+				ok,
+			)
+		}
+	}
+
+	if _, ok := (Game{}).GhostPos(time.Second); ok {
+		t.Error("Wanted no ghost without a previous run")
+	}
+}
+
+func TestPersonalBest(t *testing.T) {
+	r := Results{Solves: []Solve{
+		{Player: "alice", Seed: 1, Width: 9, Duration: 3 * time.Second},
+		{Player: "alice", Seed: 1, Width: 9, Duration: 2 * time.Second},
+		{Player: "alice", Seed: 2, Width: 9, Duration: time.Second},
+		{Player: "alice", Seed: 1, Width: 7, Duration: time.Second},
+		{
+			Player:       "alice",
+			Seed:         1,
+			Width:        9,
+			TickInterval: time.Second,
+			Duration:     time.Second,
+		},
+		{Player: "bob", Seed: 1, Width: 9, Duration: time.Second},
+	}}
+	board := func(seed int64) BoardKey {
+		return Solve{Seed: seed, Width: 9}.BoardKey()
+	}
+	for _, testCase := range []struct {
+		name   string
+		player string
+		board  BoardKey
+		want   time.Duration
+		found  bool
+	}{{
+		name:   "fastest",
+		player: "alice",
+		board:  board(1),
+		want:   2 * time.Second,
+		found:  true,
+	}, {
+		name:   "other-seed",
+		player: "alice",
+		board:  board(2),
+		want:   time.Second,
+		found:  true,
+	}, {
+		name:   "never-solved",
+		player: "bob",
+		board:  board(2),
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			best, found := r.PersonalBest(testCase.player, testCase.board)
+			if found != testCase.found || best.Duration != testCase.want {
+				t.Fatalf(
+					"Wanted %v, %t; got %v, %t",
+					testCase.want,
+					testCase.found,
+					best.Duration,
+					found,
+				)
+			}
+		})
+	}
+}
+
+func TestSoloLobby(t *testing.T) {
+	// A solo game starts as soon as its player joins, with their best run
+	// as the ghost.
+	spec := DefaultBoardSpec(42)
+	board := spec.Generate()
+	path := []Move{{Dir: Right, To: Point{1, 1}, Elapsed: time.Nanosecond}}
+	results := &Results{Solves: []Solve{{
+		Player:   "alice",
+		Seed:     42,
+		Width:    board.Width(),
+		Height:   board.Height(),
+		Duration: time.Second,
+		Path:     path,
+	}}}
+	l := NewSoloLobby(spec, true, results, Rules{})
+	if !l.Add(&testParticipant{name: "alice"}, initialRating) {
+		t.Fatal("Couldn't join the solo lobby")
+	}
+	if l.Game == nil {
+		t.Fatal("Wanted the game to start straight away")
+	}
+	if l.Game.Game.Seed != 42 {
+		t.Fatalf("Wanted seed 42; got %d", l.Game.Game.Seed)
+	}
+	if !reflect.DeepEqual(l.Game.Game.Ghost, path) {
+		t.Fatalf("Wanted ghost %v; got %v", path, l.Game.Game.Ghost)
+	}
+	if best := l.Game.PersonalBests['@']; best != time.Second {
+		t.Fatalf("Wanted a personal best of 1s; got %v", best)
+	}
+}
+
+func TestDailySeed(t *testing.T) {
+	morning := time.Date(2017, 10, 1, 1, 0, 0, 0, time.UTC)
+	evening := time.Date(2017, 10, 1, 23, 0, 0, 0, time.UTC)
+	tomorrow := morning.AddDate(0, 0, 1)
+	if DailySeed(morning) != DailySeed(evening) {
+		t.Error("Wanted the same seed all day")
+	}
+	if DailySeed(morning) == DailySeed(tomorrow) {
+		t.Error("Wanted a new seed the next day")
+	}
+}
+
+func TestJoinDaily(t *testing.T) {
+	gm := GameManager{Daily: &Daily{Config: DailyConfig{
+		Width:     boardWidth,
+		Height:    boardHeight,
+		Generator: defaultGenerator,
+	}}}
+	for _, testCase := range []struct {
+		name          string
+		authenticated bool
+		attempt       int
+	}{
+		{name: "practice"},
+		{name: "first", authenticated: true, attempt: 1},
+		{name: "practice-again"},
+		{name: "second", authenticated: true, attempt: 2},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			user := &testParticipant{name: "alice"}
+			l := gm.JoinDaily(user, testCase.authenticated, false)
+			defer gm.Drop(user)
+			if (l.Daily != "") != testCase.authenticated {
+				t.Fatalf("Wanted counted %t", testCase.authenticated)
+			}
+			if l.Attempt != testCase.attempt {
+				t.Fatalf(
+					"Wanted attempt %d; got %d",
+					testCase.attempt,
+					l.Attempt,
+				)
+			}
+			_, spec := gm.Daily.Today()
+			if l.Spec != spec {
+				t.Fatalf("Wanted today's board %v; got %v", spec, l.Spec)
+			}
+		})
+	}
+	if n := len(gm.Daily.Attempts); n != 2 {
+		t.Fatalf("Wanted 2 attempts recorded; got %d", n)
+	}
+}
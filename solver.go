@@ -0,0 +1,109 @@
+package main
+
+var dirs = []Dir{Left, Right, Up, Down}
+
+// ShortestPath returns the points along a shortest path from `from` to `to`
+// (inclusive of both endpoints) or nil if `to` is unreachable from `from`.
+// This is a plain breadth-first search, which is plenty fast for boards of
+// the size we generate.
+func (b *Board) ShortestPath(from, to Point) []Point {
+	prev := map[Point]Point{from: from}
+	queue := []Point{from}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if p == to {
+			var path []Point
+			for ; p != from; p = prev[p] {
+				path = append(path, p)
+			}
+			path = append(path, from)
+
+			// reverse so the path runs from `from` to `to`
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path
+		}
+		for _, d := range dirs {
+			next := p.Translate(d)
+			if _, seen := prev[next]; !seen && b.IsPath(next) {
+				prev[next] = p
+				queue = append(queue, next)
+			}
+		}
+	}
+	return nil
+}
+
+// ShortestPathLength returns the number of moves along the shortest path from
+// the board's start to its end, or -1 if the end is unreachable.
+func (b *Board) ShortestPathLength() int {
+	return len(b.ShortestPath(b.Start, b.End)) - 1
+}
+
+// Solvable reports whether the end can be reached from the start, taking the
+// board's items into account: each key opens a single door, and teleporters
+// link their two ends. Doors are unlocked in the order they're found, so a
+// board whose keys must be saved for particular doors may be reported as
+// unsolvable, but an unsolvable board never passes.
+func (b *Board) Solvable() bool {
+	opened := map[Point]bool{}
+	for {
+		reached := b.unlocked(opened)
+		if reached[b.End] {
+			return true
+		}
+
+		keys := -len(opened)
+		var door *Point
+		for p := range reached {
+			if b.Items[p] == ItemKey {
+				keys++
+			}
+			for _, d := range dirs {
+				next := p.Translate(d)
+				if b.Items[next] != ItemDoor || opened[next] {
+					continue
+				}
+				if door == nil || next.Y < door.Y ||
+					(next.Y == door.Y && next.X < door.X) {
+					next := next
+					door = &next
+				}
+			}
+		}
+		if keys < 1 || door == nil {
+			return false
+		}
+		opened[*door] = true
+	}
+}
+
+// unlocked returns every tile that can be reached from the start without
+// passing through a door that hasn't been `opened`.
+func (b *Board) unlocked(opened map[Point]bool) map[Point]bool {
+	reached := map[Point]bool{b.Start: true}
+	queue := []Point{b.Start}
+	for i := 0; i < len(queue); i++ {
+		p := queue[i]
+		var next []Point
+		for _, d := range dirs {
+			next = append(next, p.Translate(d))
+		}
+		if b.Items[p].IsTeleporter() {
+			if other, found := otherTeleporter(b.Items, p); found {
+				next = append(next, other)
+			}
+		}
+		for _, n := range next {
+			if reached[n] || !b.IsPath(n) ||
+				(b.Items[n] == ItemDoor && !opened[n]) {
+				continue
+			}
+			reached[n] = true
+			queue = append(queue, n)
+		}
+	}
+	return reached
+}
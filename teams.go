@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Lobby sizes: free-for-all lobbies hold freeForAllSize players, while team
+// lobbies hold teamSize players for each team.
+const (
+	freeForAllSize = 2
+	teamSize       = 2
+	minTeams       = 2
+)
+
+// maxTeams is the most teams there are tokens for.
+func maxTeams() int { return len(playerTokens) / teamSize }
+
+// lobbySize returns how many players a lobby played by these rules holds.
+func (rules Rules) lobbySize() int {
+	if rules.Teams > 0 {
+		return rules.Teams * teamSize
+	}
+	return freeForAllSize
+}
+
+// TeamScoring decides which team wins a team game.
+type TeamScoring int
+
+const (
+	// ScoringFirstOut gives the win to the first team to get every one of
+	// its players to the finish
+	ScoringFirstOut TeamScoring = iota
+
+	// ScoringTotal gives the win to the team with the lowest total of its
+	// players' solve times, once every team has finished
+	ScoringTotal
+)
+
+var teamScoringNames = map[TeamScoring]string{
+	ScoringFirstOut: "first-out",
+	ScoringTotal:    "total",
+}
+
+func (s TeamScoring) String() string {
+	if name, found := teamScoringNames[s]; found {
+		return name
+	}
+	return fmt.Sprintf("TeamScoring(%d)", int(s))
+}
+
+func (s TeamScoring) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func ParseTeamScoring(s string) (TeamScoring, error) {
+	for scoring, name := range teamScoringNames {
+		if name == s {
+			return scoring, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown team scoring: %s", s)
+}
+
+// TeamResult is how a team is doing in a team game.
+type TeamResult struct {
+	Team     int           `json:"team"`
+	Players  []string      `json:"players"`
+	Finished int           `json:"finished"` // players who have finished
+	Total    time.Duration `json:"total"`    // sum of their solve times
+	Time     time.Duration `json:"time"`     // solve time of the latest
+	Done     bool          `json:"done"`     // everybody has finished
+	Won      bool          `json:"won"`
+}
+
+// teamGame reports whether the players are split into teams.
+func (g Game) teamGame() bool {
+	for _, p := range g.Players {
+		if p.Team > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TeamResults sums up each team's players, in team order.
+func (g Game) TeamResults() []TeamResult {
+	byTeam := map[int]*TeamResult{}
+	var teams []int
+	for _, p := range g.Players {
+		if p.Team < 1 {
+			continue
+		}
+		result, found := byTeam[p.Team]
+		if !found {
+			result = &TeamResult{Team: p.Team, Won: p.Team == g.WinningTeam}
+			byTeam[p.Team] = result
+			teams = append(teams, p.Team)
+		}
+		result.Players = append(result.Players, string(p.ID))
+		if duration, solved := g.SolvedTimes[p.ID]; solved {
+			result.Finished++
+			result.Total += duration
+			if duration > result.Time {
+				result.Time = duration
+			}
+		}
+	}
+	sort.Ints(teams)
+
+	results := make([]TeamResult, len(teams))
+	for i, team := range teams {
+		results[i] = *byTeam[team]
+		results[i].Done = results[i].Finished == len(results[i].Players)
+	}
+	return results
+}
+
+// decideTeams picks the winning team as soon as the scoring allows, or out of
+// the teams that have finished once the game is over. Ties go to the
+// lower-numbered team.
+func (g Game) decideTeams() Game {
+	if g.WinningTeam != 0 {
+		return g
+	}
+	var best *TeamResult
+	results := g.TeamResults()
+	for i, result := range results {
+		if !result.Done {
+			if g.Scoring == ScoringTotal && !g.Over {
+				return g
+			}
+			continue
+		}
+		if best == nil ||
+			(g.Scoring == ScoringFirstOut && result.Time < best.Time) ||
+			(g.Scoring == ScoringTotal && result.Total < best.Total) {
+			best = &results[i]
+		}
+	}
+	if best != nil {
+		g.WinningTeam = best.Team
+	}
+	return g
+}
+
+// won reports whether the player won the game or, in a team game, whether
+// their team did.
+func (g Game) won(pid rune) bool {
+	if p, _ := g.Player(pid); p.Team > 0 {
+		return p.Team == g.WinningTeam
+	}
+	return g.Winner == pid
+}
+
+// teammates returns where the player's teammates are, wherever they are on
+// the board.
+func (g Game) teammates(pid rune) map[string]Point {
+	teammates := map[string]Point{}
+	p, _ := g.Player(pid)
+	for _, other := range g.Players {
+		if p.Team > 0 && other.Team == p.Team && other.ID != pid {
+			teammates[string(other.ID)] = other.Pos
+		}
+	}
+	return teammates
+}
+
+// assignTeams splits the users into `teams` teams of similar strength by
+// dealing them out in order of rating, snaking back and forth (1, 2, 2, 1,
+// ...), so the best player's team gets the weakest player of the next round.
+// Teams are numbered from 1.
+func assignTeams(
+	users []Participant,
+	ratings map[Participant]float64,
+	teams int,
+) map[Participant]int {
+	sorted := make([]Participant, len(users))
+	copy(sorted, users)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return ratings[sorted[i]] > ratings[sorted[j]]
+	})
+
+	assigned := make(map[Participant]int, len(users))
+	for i, user := range sorted {
+		round, seat := i/teams, i%teams
+		if round%2 == 1 {
+			seat = teams - 1 - seat
+		}
+		assigned[user] = seat + 1
+	}
+	return assigned
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAssignTeams(t *testing.T) {
+	a, b := &testParticipant{"a"}, &testParticipant{"b"}
+	c, d := &testParticipant{"c"}, &testParticipant{"d"}
+	got := assignTeams(
+		[]Participant{d, c, b, a},
+		map[Participant]float64{a: 1600, b: 1500, c: 1400, d: 1300},
+		2,
+	)
+	want := map[Participant]int{a: 1, b: 2, c: 2, d: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Wanted %v; got %v", want, got)
+	}
+}
+
+func TestDecideTeams(t *testing.T) {
+	for _, testCase := range []struct {
+		name    string
+		scoring TeamScoring
+		over    bool
+		solved  map[rune]time.Duration
+		want    int
+	}{{
+		name:    "first-out",
+		scoring: ScoringFirstOut,
+		solved: map[rune]time.Duration{
+			'@': 3 * time.Second,
+			'&': 4 * time.Second,
+			'%': 5 * time.Second,
+		},
+		want: 2,
+	}, {
+		name:    "first-out-nobody-done",
+		scoring: ScoringFirstOut,
+		solved:  map[rune]time.Duration{'@': 3 * time.Second},
+	}, {
+		name:    "total-waits-for-everyone",
+		scoring: ScoringTotal,
+		solved: map[rune]time.Duration{
+			'@': 3 * time.Second,
+			'$': 4 * time.Second,
+			'&': 2 * time.Second,
+		},
+	}, {
+		name:    "total",
+		scoring: ScoringTotal,
+		solved: map[rune]time.Duration{
+			'@': 3 * time.Second,
+			'$': 9 * time.Second,
+			'&': 5 * time.Second,
+			'%': 6 * time.Second,
+		},
+		want: 2,
+	}, {
+		name:    "total-tie",
+		scoring: ScoringTotal,
+		solved: map[rune]time.Duration{
+			'@': 5 * time.Second,
+			'$': 6 * time.Second,
+			'&': 4 * time.Second,
+			'%': 7 * time.Second,
+		},
+		want: 1,
+	}, {
+		name:    "total-out-of-time",
+		scoring: ScoringTotal,
+		over:    true,
+		solved: map[rune]time.Duration{
+			'@': 3 * time.Second,
+			'$': 4 * time.Second,
+			'&': 2 * time.Second,
+		},
+		want: 1,
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			g := Game{
+				Players: []Player{
+					{ID: '@', Team: 1},
+					{ID: '$', Team: 1},
+					{ID: '&', Team: 2},
+					{ID: '%', Team: 2},
+				},
+				SolvedTimes: testCase.solved,
+				Scoring:     testCase.scoring,
+				Over:        testCase.over,
+			}
+			if g = g.decideTeams(); g.WinningTeam != testCase.want {
+				t.Fatalf(
+					"Wanted team %d to win; got %d",
+					testCase.want,
+					g.WinningTeam,
+				)
+			}
+		})
+	}
+}
+
+func TestTeamResults(t *testing.T) {
+	g := Game{
+		Players: []Player{
+			{ID: '@', Team: 2, Pos: Point{1, 1}},
+			{ID: '$', Team: 1, Pos: Point{2, 1}},
+			{ID: '&', Team: 2, Pos: Point{3, 1}},
+			{ID: '%', Team: 1, Pos: Point{4, 1}},
+		},
+		SolvedTimes: map[rune]time.Duration{
+			'@': 3 * time.Second,
+			'&': 5 * time.Second,
+			'%': 4 * time.Second,
+		},
+		WinningTeam: 2,
+	}
+	want := []TeamResult{{
+		Team:     1,
+		Players:  []string{"$", "%"},
+		Finished: 1,
+		Total:    4 * time.Second,
+		Time:     4 * time.Second,
+	}, {
+		Team:     2,
+		Players:  []string{"@", "&"},
+		Finished: 2,
+		Total:    8 * time.Second,
+		Time:     5 * time.Second,
+		Done:     true,
+		Won:      true,
+	}}
+	if got := g.TeamResults(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Wanted %#v; got %#v", want, got)
+	}
+	if !g.won('&') || g.won('$') {
+		t.Fatal("Wanted everybody on team 2 to have won")
+	}
+	teammates := g.teammates('@')
+	if want := map[string]Point{"&": {3, 1}}; !reflect.DeepEqual(
+		teammates,
+		want,
+	) {
+		t.Fatalf("Wanted teammates %v; got %v", want, teammates)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Telnet commands and options (RFC 854 and friends)
+const (
+	telnetIAC  = 255 // interpret as command
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250 // subnegotiation, up to IAC SE
+	telnetIP   = 244 // interrupt process, which telnet sends for ctrl-c
+	telnetSE   = 240
+
+	telnetEcho     = 1
+	telnetSGA      = 3 // suppress go-ahead
+	telnetLinemode = 34
+)
+
+// Limits on telnet connections
+const (
+	maxTelnetLine      = 256
+	telnetWriteTimeout = 10 * time.Second
+	telnetLoginTimeout = time.Minute
+)
+
+// telnetCharMode asks telnet clients to send keys as they're pressed rather
+// than a line at a time, and not to echo them, since the server says it will.
+// (It never does; the screen is redrawn instead.)
+var telnetCharMode = []byte{
+	telnetIAC, telnetWILL, telnetEcho,
+	telnetIAC, telnetWILL, telnetSGA,
+	telnetIAC, telnetDONT, telnetLinemode,
+}
+
+// ServeTelnet plays the game over raw TCP connections accepted from `l`, so
+// it can be played with telnet or nc. Each connection is logged to `out` once
+// it closes.
+func (s *Server) ServeTelnet(l net.Listener, out io.Writer) error {
+	serializer := logSerializer(out)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if err, ok := err.(net.Error); ok && err.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go func() {
+			logger := Logger{}
+			start := time.Now()
+			s.Telnet(conn, &logger)
+			logger.Log(map[string]map[string]string{
+				"telnet": map[string]string{
+					"id":          uuid.New(),
+					"remote_addr": conn.RemoteAddr().String(),
+					"timestamp":   start.Format(time.RFC3339Nano),
+					"duration":    time.Since(start).String(),
+				},
+			})
+			serializer <- logger.data
+		}()
+	}
+}
+
+// Telnet plays over a single connection. The player is asked for their name
+// and, optionally, the rules they want to play by (as query parameters, which
+// can include their API key as `key`) a line at a time, and then plays as
+// they would over the user socket.
+func (s *Server) Telnet(conn net.Conn, logger *Logger) {
+	tc := NewTelnetTransport(conn)
+	defer tc.Close("")
+	defer metrics.Connect("telnet")()
+
+	// Connections that never log in aren't held open; once playing, players
+	// can idle as long as they like, as they can on the user socket.
+	conn.SetReadDeadline(time.Now().Add(telnetLoginTimeout))
+	name, authenticated, rules, err := s.telnetLogin(tc)
+	if err != nil {
+		logger.Logf("Error logging in over telnet: %v", err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if err := tc.write(telnetCharMode, []byte(ansiHideCursor)); err != nil {
+		logger.Logf("Error negotiating character mode: %v", err)
+		return
+	}
+	NewUserSession(
+		&s.GameManager,
+		tc,
+		name,
+		authenticated,
+		rules,
+		logger,
+	).Run()
+}
+
+// telnetLogin asks for the player's name and rules until it gets a name that
+// can be played (see Server.identify) and rules that parse. It returns the
+// name, whether the player proved it with their key, and the rules.
+func (s *Server) telnetLogin(
+	tc *TelnetTransport,
+) (
+	string,
+	bool,
+	Rules,
+	error,
+) {
+	welcome := "Welcome to maze! Enter your name, optionally followed by " +
+		"the rules to play by\r\n(e.g. `alice tick=200ms&items=true`). " +
+		"Add `key=<key>` to the rules to play\r\nas your account.\r\n"
+	if err := tc.write([]byte(welcome)); err != nil {
+		return "", false, Rules{}, err
+	}
+	for {
+		if err := tc.write([]byte("Name: ")); err != nil {
+			return "", false, Rules{}, err
+		}
+		line, err := tc.readLine()
+		if err != nil {
+			return "", false, Rules{}, err
+		}
+		fields := strings.Fields(line)
+		var name, query string
+		switch len(fields) {
+		case 2:
+			query = fields[1]
+			fallthrough
+		case 1:
+			name = fields[0]
+		}
+		var rules Rules
+		var authenticated bool
+		values, err := url.ParseQuery(query)
+		if err == nil && len(fields) > 2 {
+			err = fmt.Errorf("Wanted a name and rules; got %q", line)
+		}
+		if err == nil {
+			name, authenticated, err = s.identify(values.Get("key"), name)
+		}
+		if err == nil {
+			rules, err = s.checkRules(parseRules(values))
+		}
+		if err == nil {
+			return name, authenticated, rules, nil
+		}
+		if err := tc.write(
+			[]byte(fmt.Sprintf("Invalid login: %v\r\n", err)),
+		); err != nil {
+			return "", false, Rules{}, err
+		}
+	}
+}
+
+// TelnetTransport plays over a telnet connection: states are drawn on the
+// screen as they're sent, and keys are received as the commands the terminal
+// client sends for them.
+type TelnetTransport struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	screen Screen
+
+	// cr is set after reading a CR, so that the LF or NUL after it isn't
+	// read as another enter
+	cr bool
+}
+
+func NewTelnetTransport(conn net.Conn) *TelnetTransport {
+	return &TelnetTransport{
+		conn:   conn,
+		r:      bufio.NewReader(conn),
+		screen: Screen{Out: conn, Color: true},
+	}
+}
+
+// write writes to the connection, giving up if the client stops reading.
+func (tc *TelnetTransport) write(data ...[]byte) error {
+	tc.conn.SetWriteDeadline(time.Now().Add(telnetWriteTimeout))
+	for _, d := range data {
+		if _, err := tc.conn.Write(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send redraws the screen with the state.
+func (tc *TelnetTransport) Send(state UserState) error {
+	tc.conn.SetWriteDeadline(time.Now().Add(telnetWriteTimeout))
+	return tc.screen.Draw(state.LobbyState, state.GameState)
+}
+
+// Receive reads keys until one that means something (see clientMoves and
+// clientCommands) and returns the command it stands for. Quitting is an
+// error, so the session ends.
+func (tc *TelnetTransport) Receive() (string, error) {
+	for {
+		key, err := tc.readKey()
+		if err != nil {
+			return "", err
+		}
+		if key == 'q' || key == keyCtrlC {
+			return "", errClientQuit
+		}
+		command, found := clientMoves[key]
+		if !found {
+			command, found = clientCommands[key]
+		}
+		if found {
+			return command, nil
+		}
+	}
+}
+
+// Close restores the terminal, shows the reason and closes the connection.
+func (tc *TelnetTransport) Close(reason string) error {
+	if reason != "" {
+		reason += "\r\n"
+	}
+	tc.write([]byte(ansiReset + ansiShowCursor + "\r\n" + reason))
+	return tc.conn.Close()
+}
+
+// readLine reads a line, up to maxTelnetLine bytes long, without its line
+// ending.
+func (tc *TelnetTransport) readLine() (string, error) {
+	var line []byte
+	for {
+		key, err := tc.readKey()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case key == keyEnter:
+			return string(line), nil
+		case key == keyCtrlC:
+			return "", errClientQuit
+		case key < 0x100 && len(line) < maxTelnetLine:
+			line = append(line, byte(key))
+		}
+	}
+}
+
+// readKey reads a key, turning line endings into keyEnter and the escape
+// sequences sent for the arrow keys into single keys.
+func (tc *TelnetTransport) readKey() (rune, error) {
+	b, err := tc.readByte()
+	if err != nil {
+		return 0, err
+	}
+	cr := tc.cr
+	tc.cr = b == '\r'
+	switch b {
+	case '\r':
+		return keyEnter, nil
+	case '\n':
+		if cr {
+			return tc.readKey()
+		}
+		return keyEnter, nil
+	case 0:
+		return tc.readKey()
+	case 0x1b:
+		// The rest of the sequence comes along with the escape, so a lone
+		// escape doesn't wait for more
+		if tc.r.Buffered() < 2 {
+			break
+		}
+		next, _ := tc.r.Peek(2)
+		if key, found := arrowKeys[next[1]]; found && next[0] == '[' {
+			tc.r.Discard(2)
+			return key, nil
+		}
+	}
+	return rune(b), nil
+}
+
+// readByte reads the next byte of data, skipping any telnet commands and
+// option negotiation. An interrupt reads as ctrl-c.
+func (tc *TelnetTransport) readByte() (byte, error) {
+	for {
+		b, err := tc.r.ReadByte()
+		if err != nil || b != telnetIAC {
+			return b, err
+		}
+		command, err := tc.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch command {
+		case telnetIAC:
+			return telnetIAC, nil
+		case telnetIP:
+			return keyCtrlC, nil
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			if _, err := tc.r.ReadByte(); err != nil {
+				return 0, err
+			}
+		case telnetSB:
+			// The option comes first and may be any byte, even SE. After
+			// that, IAC IAC is a data byte and IAC SE ends it.
+			if _, err := tc.r.ReadByte(); err != nil {
+				return 0, err
+			}
+			for prev := byte(0); ; prev = b {
+				if b, err = tc.r.ReadByte(); err != nil {
+					return 0, err
+				}
+				if prev == telnetIAC && b == telnetSE {
+					break
+				}
+				if prev == telnetIAC && b == telnetIAC {
+					b = 0 // an escaped data byte, not the start of IAC SE
+				}
+			}
+		}
+	}
+}
+
+// telnetCommand connects to a server's telnet listener and passes keys and
+// the screen straight through, for trying it out without a telnet client.
+func telnetCommand(args []string) error {
+	flags := flag.NewFlagSet("telnet", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: maze telnet [flags]")
+		fmt.Fprintln(os.Stderr, "Plays over a server's telnet listener. "+
+			"Move with the arrow keys, WASD or hjkl; q quits.")
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:2323", "telnet listener to play on")
+	name := flags.String("name", os.Getenv("USER"), "name to play as")
+	rules := flags.String(
+		"rules",
+		"",
+		"rules to play by, as query parameters (e.g. tick=200ms&items=true)",
+	)
+	flags.Parse(args)
+
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	login := strings.TrimSpace(*name + " " + *rules)
+	if _, err := io.WriteString(conn, login+"\r\n"); err != nil {
+		return err
+	}
+
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		old, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer terminal.Restore(fd, old)
+	}
+	go io.Copy(conn, os.Stdin)
+
+	// The server's option negotiation is left out of what's shown; keys are
+	// sent a byte at a time regardless.
+	tc := NewTelnetTransport(conn)
+	out := bufio.NewWriter(os.Stdout)
+	for {
+		b, err := tc.readByte()
+		if err == io.EOF {
+			return out.Flush()
+		} else if err != nil {
+			return err
+		}
+		out.WriteByte(b)
+		if tc.r.Buffered() == 0 {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTelnetReadKey(t *testing.T) {
+	for _, testCase := range []struct {
+		name  string
+		input string
+		want  []rune
+	}{{
+		name:  "plain",
+		input: "ab",
+		want:  []rune{'a', 'b'},
+	}, {
+		name:  "line-endings",
+		input: "a\r\nb\r\x00c\nd\r",
+		want: []rune{
+			'a', keyEnter, 'b', keyEnter, 'c', keyEnter, 'd', keyEnter,
+		},
+	}, {
+		name:  "arrows",
+		input: "\x1b[A\x1b[D\x1b",
+		want:  []rune{keyUp, keyLeft, 0x1b},
+	}, {
+		name:  "escaped-iac",
+		input: "\xff\xffa",
+		want:  []rune{0xff, 'a'},
+	}, {
+		name:  "negotiation",
+		input: "\xff\xfb\x01a\xff\xfe\x22b\xff\xfd\x03\xff\xfc\x01c",
+		want:  []rune{'a', 'b', 'c'},
+	}, {
+		name:  "subnegotiation",
+		input: "a\xff\xfa\x1f\x00\x50\x00\x18\xff\xf0b",
+		want:  []rune{'a', 'b'},
+	}, {
+		name:  "subnegotiation-option-se",
+		input: "a\xff\xfa\xf0\x01\xff\xf0b",
+		want:  []rune{'a', 'b'},
+	}, {
+		name:  "subnegotiation-escaped-iac",
+		input: "a\xff\xfa\x18\xff\xff\xf0\xff\xf0b",
+		want:  []rune{'a', 'b'},
+	}, {
+		name:  "subnegotiation-data-se",
+		input: "a\xff\xfa\x18\xf0\xff\xf0b",
+		want:  []rune{'a', 'b'},
+	}, {
+		name:  "interrupt",
+		input: "a\xff\xf4",
+		want:  []rune{'a', keyCtrlC},
+	}, {
+		name:  "other-commands",
+		input: "\xff\xf1a\xff\xf9b",
+		want:  []rune{'a', 'b'},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			tc := TelnetTransport{
+				r: bufio.NewReader(strings.NewReader(testCase.input)),
+			}
+			var keys []rune
+			for {
+				key, err := tc.readKey()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				keys = append(keys, key)
+			}
+			if !reflect.DeepEqual(keys, testCase.want) {
+				t.Fatalf("Wanted keys %q; got %q", testCase.want, keys)
+			}
+		})
+	}
+}
+
+func TestTelnetReceive(t *testing.T) {
+	tc := TelnetTransport{r: bufio.NewReader(strings.NewReader("xw\x1b[Cq"))}
+	for _, want := range []string{"up", "right"} {
+		command, err := tc.Receive()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if command != want {
+			t.Fatalf("Wanted command %q; got %q", want, command)
+		}
+	}
+	if _, err := tc.Receive(); err != errClientQuit {
+		t.Fatalf("Wanted errClientQuit; got %v", err)
+	}
+}
+
+func TestTelnetReadLine(t *testing.T) {
+	input := "alice\xff\xfb\x01 tick=1s\r\n" + strings.Repeat("a", 300) + "\n"
+	tc := TelnetTransport{r: bufio.NewReader(strings.NewReader(input))}
+	for _, want := range []string{
+		"alice tick=1s",
+		strings.Repeat("a", maxTelnetLine),
+	} {
+		line, err := tc.readLine()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if line != want {
+			t.Fatalf("Wanted line %q; got %q", want, line)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pborman/uuid"
+)
+
+// ErrTransportClosed is returned by transports used after they're closed.
+var ErrTransportClosed = errors.New("Transport closed")
+
+// Transport is how a UserSession talks to its user: states are sent as the
+// lobby or game changes, commands (moves, votes and so on) are received as
+// text, and the connection is closed with a reason the user can be shown.
+type Transport interface {
+	Send(state UserState) error
+	Receive() (string, error)
+	Close(reason string) error
+}
+
+// BotTransport is how a BotSession talks to its bot. It works like a
+// Transport, but the bot is sent BotMessages rather than whole states.
+type BotTransport interface {
+	SendBot(msg BotMessage) error
+	Receive() (string, error)
+	Close(reason string) error
+}
+
+// Bounds on closing websockets
+const (
+	maxCloseReason        = 123 // bytes, as much as a close frame holds
+	websocketCloseTimeout = time.Second
+)
+
+// Event streams send a comment every sseKeepAlive so proxies don't give up on
+// them while nothing's happening. Commands posted to them are limited to
+// maxCommandBytes.
+const (
+	sseKeepAlive    = 15 * time.Second
+	maxCommandBytes = 1024
+)
+
+// WebsocketTransport sends states as JSON messages on a websocket and
+// receives commands as text messages.
+type WebsocketTransport struct {
+	Conn *websocket.Conn
+}
+
+func (t WebsocketTransport) Send(state UserState) error {
+	err := t.Conn.WriteJSON(state)
+	if err != nil {
+		metrics.WebsocketWriteErrors.With("user").Inc()
+	}
+	return err
+}
+
+func (t WebsocketTransport) SendBot(msg BotMessage) error {
+	err := t.Conn.WriteJSON(msg)
+	if err != nil {
+		metrics.WebsocketWriteErrors.With("bot").Inc()
+	}
+	return err
+}
+
+func (t WebsocketTransport) Receive() (string, error) {
+	_, data, err := t.Conn.ReadMessage()
+	return string(data), err
+}
+
+// Close sends a close frame with the reason, cut short if it's too long to
+// fit, before closing the connection.
+func (t WebsocketTransport) Close(reason string) error {
+	if len(reason) > maxCloseReason {
+		reason = reason[:maxCloseReason]
+	}
+	t.Conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason),
+		time.Now().Add(websocketCloseTimeout),
+	)
+	return t.Conn.Close()
+}
+
+// MemoryTransport connects a session to code in the same process, such as
+// tests. States sent are delivered on States (or BotMessages, for bots) and
+// commands are received from Commands; closing Commands ends the session as a
+// lost connection would. Sends block until the state is read, as they would
+// on a stalled socket.
+type MemoryTransport struct {
+	States      chan UserState
+	BotMessages chan BotMessage
+	Commands    chan string
+
+	// Reason is why the transport was closed, once Closed is
+	Reason string
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		States:      make(chan UserState),
+		BotMessages: make(chan BotMessage),
+		Commands:    make(chan string),
+		closed:      make(chan struct{}),
+	}
+}
+
+func (t *MemoryTransport) Send(state UserState) error {
+	select {
+	case t.States <- state:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+func (t *MemoryTransport) SendBot(msg BotMessage) error {
+	select {
+	case t.BotMessages <- msg:
+		return nil
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+func (t *MemoryTransport) Receive() (string, error) {
+	select {
+	case command, ok := <-t.Commands:
+		if !ok {
+			return "", io.EOF
+		}
+		return command, nil
+	case <-t.closed:
+		return "", ErrTransportClosed
+	}
+}
+
+func (t *MemoryTransport) Close(reason string) error {
+	t.closeOnce.Do(func() {
+		t.Reason = reason
+		close(t.closed)
+	})
+	return nil
+}
+
+// Closed is closed once the transport is.
+func (t *MemoryTransport) Closed() <-chan struct{} {
+	return t.closed
+}
+
+// SSETransport streams states to a browser as Server-Sent Events, each a
+// `data:` line of JSON, and receives the commands posted to it separately
+// (see Post). Closing it sends a `close` event with the reason. Whoever
+// serves the stream has to keep it open until Closed is closed.
+type SSETransport struct {
+	w        io.Writer
+	flusher  http.Flusher
+	commands chan string
+	gone     <-chan struct{}
+
+	lock   sync.Mutex
+	closed chan struct{}
+}
+
+// NewSSETransport starts an event stream on `w`. `gone` is closed when the
+// browser goes away, usually the request context's Done.
+func NewSSETransport(
+	w http.ResponseWriter,
+	gone <-chan struct{},
+) (
+	*SSETransport,
+	error,
+) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("Response writer can't stream events")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	t := &SSETransport{
+		w:        w,
+		flusher:  flusher,
+		commands: make(chan string),
+		gone:     gone,
+		closed:   make(chan struct{}),
+	}
+	go t.keepAlive()
+	return t, nil
+}
+
+func (t *SSETransport) keepAlive() {
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.write(":\n\n"); err != nil {
+				return
+			}
+		case <-t.gone:
+			return
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+// SendSession sends the session's token as a `session` event, so the browser
+// knows where to post commands.
+func (t *SSETransport) SendSession(token string) error {
+	data, _ := json.Marshal(token)
+	return t.event("session", string(data))
+}
+
+func (t *SSETransport) Send(state UserState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return t.event("", string(data))
+}
+
+// event writes an event, of the default type if `kind` is empty.
+func (t *SSETransport) event(kind, data string) error {
+	if kind == "" {
+		return t.write(fmt.Sprintf("data: %s\n\n", data))
+	}
+	return t.write(fmt.Sprintf("event: %s\ndata: %s\n\n", kind, data))
+}
+
+// write writes to the stream and flushes it to the browser.
+func (t *SSETransport) write(s string) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-t.closed:
+		return ErrTransportClosed
+	default:
+	}
+	if _, err := io.WriteString(t.w, s); err != nil {
+		return err
+	}
+	t.flusher.Flush()
+	return nil
+}
+
+func (t *SSETransport) Receive() (string, error) {
+	select {
+	case command := <-t.commands:
+		return command, nil
+	case <-t.gone:
+		return "", io.EOF
+	case <-t.closed:
+		return "", ErrTransportClosed
+	}
+}
+
+// Post hands a command posted by the browser to the session, waiting until
+// it's received.
+func (t *SSETransport) Post(command string) error {
+	select {
+	case t.commands <- command:
+		return nil
+	case <-t.gone:
+		return io.EOF
+	case <-t.closed:
+		return ErrTransportClosed
+	}
+}
+
+// Close sends the reason as a `close` event (newlines would end the event
+// early, so it's sent as JSON) and ends the stream.
+func (t *SSETransport) Close(reason string) error {
+	data, _ := json.Marshal(reason)
+	err := t.event("close", string(data))
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-t.closed:
+	default:
+		close(t.closed)
+	}
+	if err == ErrTransportClosed {
+		return nil
+	}
+	return err
+}
+
+// Closed is closed once the transport is.
+func (t *SSETransport) Closed() <-chan struct{} {
+	return t.closed
+}
+
+// SSESessions keeps track of the event streams being served by session token,
+// so commands posted with a token get to the right session.
+type SSESessions struct {
+	lock       sync.Mutex
+	transports map[string]*SSETransport
+}
+
+// Add registers the transport under a new, unguessable token.
+func (ss *SSESessions) Add(t *SSETransport) string {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.transports == nil {
+		ss.transports = map[string]*SSETransport{}
+	}
+	token := uuid.New()
+	ss.transports[token] = t
+	return token
+}
+
+func (ss *SSESessions) Get(token string) (*SSETransport, bool) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	t, found := ss.transports[token]
+	return t, found
+}
+
+func (ss *SSESessions) Remove(token string) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	delete(ss.transports, token)
+}
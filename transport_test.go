@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSETransport(t *testing.T) {
+	w := httptest.NewRecorder()
+	gone := make(chan struct{})
+	transport, err := NewSSETransport(w, gone)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wanted status 200; got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType !=
+		"text/event-stream" {
+		t.Fatalf("Wanted an event stream; got %s", contentType)
+	}
+
+	if err := transport.SendSession("token"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := transport.Send(UserState{Mode: ModeMatchMaking}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	posted := make(chan error, 1)
+	go func() { posted <- transport.Post("up") }()
+	if command, err := transport.Receive(); err != nil || command != "up" {
+		t.Fatalf("Wanted command up; got %q, %v", command, err)
+	}
+	if err := <-posted; err != nil {
+		t.Fatalf("Unexpected error posting: %v", err)
+	}
+
+	if err := transport.Close("Lobby closed\nbye"); err != nil {
+		t.Fatalf("Unexpected error closing: %v", err)
+	}
+	select {
+	case <-transport.Closed():
+	default:
+		t.Fatal("Wanted the transport closed")
+	}
+	if err := transport.Close(""); err != nil {
+		t.Fatalf("Wanted closing twice to be fine; got %v", err)
+	}
+	if err := transport.Send(UserState{}); err != ErrTransportClosed {
+		t.Fatalf("Wanted ErrTransportClosed sending; got %v", err)
+	}
+	if err := transport.Post("up"); err != ErrTransportClosed {
+		t.Fatalf("Wanted ErrTransportClosed posting; got %v", err)
+	}
+
+	events := strings.Split(w.Body.String(), "\n\n")
+	for i, want := range []string{
+		"event: session\ndata: \"token\"",
+		"data: {\"mode\":",
+		"event: close\ndata: \"Lobby closed\\nbye\"",
+	} {
+		if i >= len(events) || !strings.HasPrefix(events[i], want) {
+			t.Fatalf("Wanted event %d to be %q; got %q", i, want, events)
+		}
+	}
+}
+
+func TestSSETransportGone(t *testing.T) {
+	gone := make(chan struct{})
+	transport, err := NewSSETransport(httptest.NewRecorder(), gone)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	close(gone)
+	if _, err := transport.Receive(); err != io.EOF {
+		t.Fatalf("Wanted io.EOF receiving; got %v", err)
+	}
+	if err := transport.Post("up"); err != io.EOF {
+		t.Fatalf("Wanted io.EOF posting; got %v", err)
+	}
+}
+
+func TestSSESessions(t *testing.T) {
+	var sessions SSESessions
+	a, b := &SSETransport{}, &SSETransport{}
+	tokenA, tokenB := sessions.Add(a), sessions.Add(b)
+	if tokenA == tokenB {
+		t.Fatalf("Wanted distinct tokens; got %s twice", tokenA)
+	}
+	if got, found := sessions.Get(tokenB); !found || got != b {
+		t.Fatal("Wanted the transport added under its token")
+	}
+	sessions.Remove(tokenB)
+	if _, found := sessions.Get(tokenB); found {
+		t.Fatal("Wanted the transport removed")
+	}
+	if got, found := sessions.Get(tokenA); !found || got != a {
+		t.Fatal("Wanted the other transport kept")
+	}
+}
+
+func TestUserCommandUnknownSession(t *testing.T) {
+	var s Server
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(
+		"POST",
+		"/user/events/nope",
+		strings.NewReader("up"),
+	)
+	s.UserCommand(w, r, &Logger{})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Wanted status 404; got %d", w.Code)
+	}
+}
@@ -3,10 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
-
-	"github.com/gorilla/websocket"
 )
 
 type PlayerSession struct {
@@ -14,8 +14,9 @@ type PlayerSession struct {
 	GameSession *GameSession
 }
 
-func (ps *PlayerSession) Move(dir Dir) {
-	ps.GameSession.PlayerMove(ps.Token, dir)
+// Move returns whether the player actually moved.
+func (ps *PlayerSession) Move(dir Dir) bool {
+	return ps.GameSession.PlayerMove(ps.Token, dir)
 }
 
 type Mode int
@@ -42,9 +43,20 @@ func (m Mode) MarshalJSON() ([]byte, error) {
 }
 
 type LobbyState struct {
-	Players    int  `json:"players"`
-	Total      int  `json:"total"`
-	InProgress bool `json:"in_progress"`
+	Players       int           `json:"players"`
+	Bots          int           `json:"bots"`
+	Total         int           `json:"total"`
+	InProgress    bool          `json:"in_progress"`
+	Over          bool          `json:"over,omitempty"`
+	Standings     []Standing    `json:"standings,omitempty"`
+	Match         string        `json:"match,omitempty"` // once it's over
+	Rematches     int           `json:"rematches,omitempty"`
+	AverageRating int           `json:"average_rating"`
+	Ratings       []int         `json:"ratings"`
+	Waited        time.Duration `json:"waited"`
+	ETA           time.Duration `json:"eta"`
+	Votes         int           `json:"votes"`
+	Rules         Rules         `json:"rules"`
 }
 
 type GameState struct {
@@ -53,7 +65,61 @@ type GameState struct {
 	Players     []rune                   `json:"players"`
 	Winner      string                   `json:"winner,omitempty"`
 	SolvedTimes map[string]time.Duration `json:"solved_times,omitempty"`
+	Ratings     map[string]int           `json:"ratings"`
 	GameStart   time.Time                `json:"game_start"`
+	Position    Point                    `json:"position"`
+
+	// Tick counts the ticks taken so far in a tick-based game, which moves
+	// every TickInterval
+	Tick         int           `json:"tick,omitempty"`
+	TickInterval time.Duration `json:"tick_interval,omitempty"`
+
+	// Conflicts lists the collisions between players caused by the latest
+	// move or tick
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+
+	// Keys counts the keys the player holds and Effects counts the moves
+	// left on each of their effects
+	Keys    int     `json:"keys,omitempty"`
+	Effects Effects `json:"effects"`
+
+	// Objective is what the players have to do to finish, and Progress is
+	// how far along the player is
+	Objective ObjectiveRule `json:"objective"`
+	Progress  Progress      `json:"progress"`
+
+	// Shift is non-zero for shifting mazes, which shift every Shift; Changes
+	// lists the tiles changed by the latest shift
+	Shift   time.Duration `json:"shift,omitempty"`
+	Changes []TileChange  `json:"changes,omitempty"`
+
+	// In team games, Team is the player's team, Teammates are where their
+	// teammates are (in view or not) and Teams is how every team is doing
+	Team      int              `json:"team,omitempty"`
+	Teammates map[string]Point `json:"teammates,omitempty"`
+	Teams     []TeamResult     `json:"teams,omitempty"`
+
+	// The match ends TimeLimit into the game or Grace after the first
+	// player finishes, whichever comes first; Ends is when that will be, as
+	// far as is known. Once it's Over, Standings holds the final standings.
+	TimeLimit time.Duration `json:"time_limit,omitempty"`
+	Grace     time.Duration `json:"grace,omitempty"`
+	Ends      time.Duration `json:"ends,omitempty"`
+	Over      bool          `json:"over,omitempty"`
+	Standings []Standing    `json:"standings,omitempty"`
+
+	// PersonalBest is the player's fastest previous solve of this board
+	PersonalBest time.Duration `json:"personal_best,omitempty"`
+
+	// Daily is the day of the daily challenge being played, if any, and
+	// Attempt counts the player's attempts at it
+	Daily   string `json:"daily,omitempty"`
+	Attempt int    `json:"attempt,omitempty"`
+
+	// Tiles is the window tile by tile and Origin is the board position of
+	// its top-left tile, for bots, which work in board coordinates
+	Tiles  [][]rune `json:"-"`
+	Origin Point    `json:"-"`
 }
 
 type UserState struct {
@@ -62,21 +128,50 @@ type UserState struct {
 	GameState  *GameState  `json:"game_state,omitempty"`
 }
 
+// Participant is anything that can take a seat in a lobby and play: a user
+// connected over a socket or a server-side bot.
+type Participant interface {
+	Name() string
+	GameStart(playerSession PlayerSession)
+	NotifyUserState(userState UserState)
+	ClearGame()
+	Logf(format string, v ...interface{})
+}
+
 type UserSession struct {
+	name          string
+	authenticated bool // whether the player proved who they are
 	writeLock     sync.Mutex
-	conn          *websocket.Conn
+	transport     Transport
 	lock          sync.Mutex
 	playerSession *PlayerSession
 	gameManager   *GameManager
 	logger        *Logger
+	rules         Rules
 }
 
 func NewUserSession(
 	gm *GameManager,
-	conn *websocket.Conn,
+	transport Transport,
+	name string,
+	authenticated bool,
+	rules Rules,
 	logger *Logger,
 ) *UserSession {
-	return &UserSession{conn: conn, gameManager: gm, logger: logger}
+	return &UserSession{
+		name:          name,
+		authenticated: authenticated,
+		transport:     transport,
+		gameManager:   gm,
+		logger:        logger,
+		rules:         rules,
+	}
+}
+
+func (user *UserSession) Name() string { return user.name }
+
+func (user *UserSession) Logf(format string, v ...interface{}) {
+	user.logger.Logf(format, v...)
 }
 
 func (user *UserSession) GameStart(playerSession PlayerSession) {
@@ -88,22 +183,27 @@ func (user *UserSession) GameStart(playerSession PlayerSession) {
 func (user *UserSession) NotifyUserState(userState UserState) {
 	user.writeLock.Lock()
 	defer user.writeLock.Unlock()
-	if err := user.conn.WriteJSON(userState); err != nil {
+	if err := user.transport.Send(userState); err != nil {
 		user.logger.Logf(
-			"Error writing UserState to websocket (terminating): %v",
+			"Error sending UserState (terminating): %v",
 			err,
 		)
 		// There shouldn't be any errors marshaling json, so any errors must be
-		// I/O errors; if there is an I/O error, we should probably just quit
-		user.quit()
+		// I/O errors; if there is an I/O error, we should probably just quit.
+		// This is called with the lobby or game locked, so the user can't be
+		// dropped here; closing the transport ends the receive loop, which
+		// drops them.
+		user.transport.Close("Error sending state")
 	}
 }
 
-func (user *UserSession) quit() {
+// quit leaves the game and closes the transport, telling the user why, if
+// they didn't leave of their own accord.
+func (user *UserSession) quit(reason string) {
 	user.gameManager.Drop(user)
 	user.writeLock.Lock()
 	defer user.writeLock.Unlock()
-	user.conn.Close()
+	user.transport.Close(reason)
 }
 
 func (user *UserSession) ClearGame() {
@@ -114,35 +214,135 @@ func (user *UserSession) ClearGame() {
 
 func (user *UserSession) returnToMatchMaking(lobby *Lobby) error {
 	user.gameManager.Drop(user)
-	return user.lobbyMode(user.gameManager.Join(user))
+	return user.lobbyMode(user.gameManager.Join(user, user.rules))
+}
+
+type soloRequest struct {
+	Seed  int64
+	Daily bool
+	Ghost bool
+}
+
+// parseSolo parses a request for a single-player game, which looks like
+// `solo [daily|<seed>] [ghost]`. The daily challenge is played if no seed is
+// given.
+func parseSolo(msg string) (soloRequest, bool) {
+	fields := strings.Fields(msg)
+	if len(fields) < 1 || fields[0] != "solo" {
+		return soloRequest{}, false
+	}
+	req := soloRequest{Daily: true}
+	for _, field := range fields[1:] {
+		switch field {
+		case "daily":
+			req.Daily = true
+		case "ghost":
+			req.Ghost = true
+		default:
+			seed, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return soloRequest{}, false
+			}
+			req.Seed, req.Daily = seed, false
+		}
+	}
+	return req, true
+}
+
+// parseBots parses a request to add bots to the lobby, which looks like
+// `bot [level]` to add a single bot or `bots [level]` to fill the lobby. The
+// returned count is zero when filling. Bots play optimally by default.
+func parseBots(msg string) (BotLevel, int, bool) {
+	fields := strings.Fields(msg)
+	if len(fields) < 1 || len(fields) > 2 {
+		return 0, 0, false
+	}
+	var count int
+	switch fields[0] {
+	case "bot":
+		count = 1
+	case "bots":
+	default:
+		return 0, 0, false
+	}
+	level := BotOptimal
+	if len(fields) > 1 {
+		var err error
+		if level, err = ParseBotLevel(fields[1]); err != nil {
+			return 0, 0, false
+		}
+	}
+	return level, count, true
+}
+
+func (user *UserSession) soloMode(req soloRequest) error {
+	user.gameManager.Drop(user)
+	if req.Daily {
+		return user.lobbyMode(user.gameManager.JoinDaily(
+			user,
+			user.authenticated,
+			req.Ghost,
+		))
+	}
+	return user.lobbyMode(user.gameManager.JoinSolo(
+		user,
+		DefaultBoardSpec(req.Seed),
+		req.Ghost,
+		user.rules,
+	))
 }
 
 func (user *UserSession) isGameMode() bool {
+	return user.session() != nil
+}
+
+// session returns the user's part in the game being played, or nil between
+// games.
+func (user *UserSession) session() *PlayerSession {
 	user.lock.Lock()
 	defer user.lock.Unlock()
-	return user.playerSession != nil
+	return user.playerSession
 }
 
-func (user *UserSession) gameMode(lobby *Lobby) error {
-	for {
-		_, data, err := user.conn.ReadMessage()
-		if err != nil {
-			user.logger.Logf("Error reading message: %v", err)
-			user.quit()
-			return err
+// gameMode plays the game, starting with `command` if it isn't empty.
+func (user *UserSession) gameMode(lobby *Lobby, command string) error {
+	for ; ; command = "" {
+		if command == "" {
+			var err error
+			if command, err = user.transport.Receive(); err != nil {
+				user.logger.Logf("Error receiving command: %v", err)
+				user.quit("")
+				return err
+			}
 		}
 
-		switch ev := string(data); ev {
+		if req, ok := parseSolo(command); ok {
+			return user.soloMode(req)
+		}
+
+		if rematch, ok := parseRematch(command, lobby.CurrentRules()); ok {
+			lobby.VoteRematch(user, rematch)
+			continue
+		}
+
+		var dir Dir
+		switch command {
 		case "rtmm":
 			return user.returnToMatchMaking(lobby)
 		case "left":
-			user.playerSession.Move(Left)
+			dir = Left
 		case "right":
-			user.playerSession.Move(Right)
+			dir = Right
 		case "up":
-			user.playerSession.Move(Up)
+			dir = Up
 		case "down":
-			user.playerSession.Move(Down)
+			dir = Down
+		default:
+			continue
+		}
+		// The game may be cleared at any time, e.g. when a rematch starts.
+		if playerSession := user.session(); playerSession != nil {
+			playerSession.Move(dir)
 		}
 	}
 }
@@ -151,21 +351,39 @@ func (user *UserSession) lobbyMode(lobby *Lobby) error {
 	lobby.Broadcast()
 	for {
 		if user.isGameMode() {
-			return user.gameMode(lobby)
+			return user.gameMode(lobby, "")
 		}
 
-		_, data, err := user.conn.ReadMessage()
+		command, err := user.transport.Receive()
 		if err != nil {
-			user.quit()
+			user.quit("")
 			return err
 		}
 
-		if string(data) == "rtmm" {
+		// The game may have started while waiting, in which case the
+		// command is meant for it.
+		if user.isGameMode() {
+			return user.gameMode(lobby, command)
+		}
+
+		if req, ok := parseSolo(command); ok {
+			return user.soloMode(req)
+		}
+
+		if level, count, ok := parseBots(command); ok {
+			lobby.AddBots(level, count)
+			continue
+		}
+
+		switch command {
+		case "rtmm":
 			return user.returnToMatchMaking(lobby)
+		case "start":
+			lobby.Vote(user)
 		}
 	}
 }
 
 func (user *UserSession) Run() error {
-	return user.lobbyMode(user.gameManager.Join(user))
+	return user.lobbyMode(user.gameManager.Join(user, user.rules))
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testTimeout is how long tests wait for a session to do something.
+const testTimeout = 5 * time.Second
+
+// testUser plays as a UserSession over a MemoryTransport. States are
+// collected as they're sent, so the session never blocks on them.
+type testUser struct {
+	t         *testing.T
+	gm        *GameManager
+	transport *MemoryTransport
+	states    chan UserState
+	done      chan error
+}
+
+func newTestUser(t *testing.T, gm *GameManager, name string) *testUser {
+	user := &testUser{
+		t:         t,
+		gm:        gm,
+		transport: NewMemoryTransport(),
+		states:    make(chan UserState, 1024),
+		done:      make(chan error, 1),
+	}
+	go func() {
+		for {
+			select {
+			case state := <-user.transport.States:
+				user.states <- state
+			case <-user.transport.Closed():
+				return
+			}
+		}
+	}()
+	session := NewUserSession(
+		gm,
+		user.transport,
+		name,
+		false,
+		Rules{},
+		&Logger{},
+	)
+	go func() { user.done <- session.Run() }()
+	return user
+}
+
+func (user *testUser) send(command string) {
+	user.t.Helper()
+	select {
+	case user.transport.Commands <- command:
+	case <-time.After(testTimeout):
+		user.t.Fatalf("Timed out sending %q", command)
+	}
+}
+
+// await skips states until one that `match`es, which it returns.
+func (user *testUser) await(
+	description string,
+	match func(UserState) bool,
+) UserState {
+	user.t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case state := <-user.states:
+			if match(state) {
+				return state
+			}
+		case <-timeout:
+			user.t.Fatalf("Timed out waiting for %s", description)
+		}
+	}
+}
+
+func (user *testUser) awaitMode(mode Mode) UserState {
+	user.t.Helper()
+	return user.await(
+		fmt.Sprintf("mode %d", mode),
+		func(state UserState) bool { return state.Mode == mode },
+	)
+}
+
+// disconnect ends the session as a lost connection would and waits for it to
+// finish.
+func (user *testUser) disconnect() {
+	user.t.Helper()
+	close(user.transport.Commands)
+	select {
+	case <-user.done:
+	case <-time.After(testTimeout):
+		user.t.Fatal("Timed out waiting for the session to end")
+	}
+}
+
+func (user *testUser) lobbies() int {
+	user.gm.Mutex.RLock()
+	defer user.gm.Mutex.RUnlock()
+	return len(user.gm.Lobbies)
+}
+
+// board returns the board of the game the user is playing.
+func (user *testUser) board() Board {
+	user.gm.Mutex.RLock()
+	gs := user.gm.Lobbies[0].Game
+	user.gm.Mutex.RUnlock()
+	gs.Mutex.Lock()
+	defer gs.Mutex.Unlock()
+	return gs.Game.Board
+}
+
+func TestUserSession(t *testing.T) {
+	for _, testCase := range []struct {
+		name string
+		play func(t *testing.T, user *testUser)
+	}{{
+		name: "join",
+		play: func(t *testing.T, user *testUser) {
+			state := user.awaitMode(ModeMatchMaking)
+			if state.LobbyState == nil || state.LobbyState.Players != 1 {
+				t.Fatalf("Wanted a lobby with 1 player; got %#v", state)
+			}
+			if lobbies := user.lobbies(); lobbies != 1 {
+				t.Fatalf("Wanted 1 lobby; got %d", lobbies)
+			}
+		},
+	}, {
+		name: "vote-to-start",
+		play: func(t *testing.T, user *testUser) {
+			user.awaitMode(ModeMatchMaking)
+			user.send("start")
+			state := user.awaitMode(ModeGame)
+			if state.GameState == nil || state.GameState.Token != '@' {
+				t.Fatalf("Wanted to play as '@'; got %#v", state.GameState)
+			}
+		},
+	}, {
+		name: "move",
+		play: func(t *testing.T, user *testUser) {
+			user.send("start")
+			start := user.awaitMode(ModeGame).GameState.Position
+			board := user.board()
+			for _, dir := range []Dir{Left, Right, Up, Down} {
+				to := start.Translate(dir)
+				if !board.IsPath(to) {
+					continue
+				}
+				user.send(dir.String())
+				user.await(
+					"a move "+dir.String(),
+					func(state UserState) bool {
+						return state.GameState != nil &&
+							state.GameState.Position == to
+					},
+				)
+				return
+			}
+			t.Fatalf("No way out of %v", start)
+		},
+	}, {
+		name: "return-to-matchmaking",
+		play: func(t *testing.T, user *testUser) {
+			user.send("start")
+			user.awaitMode(ModeGame)
+			user.send("rtmm")
+			state := user.awaitMode(ModeMatchMaking)
+			if state.LobbyState.InProgress {
+				t.Fatalf("Wanted a new lobby; got %#v", state.LobbyState)
+			}
+			if lobbies := user.lobbies(); lobbies != 1 {
+				t.Fatalf("Wanted only the new lobby; got %d", lobbies)
+			}
+		},
+	}, {
+		name: "disconnect-while-queued",
+		play: func(t *testing.T, user *testUser) {
+			user.awaitMode(ModeMatchMaking)
+			user.disconnect()
+			if lobbies := user.lobbies(); lobbies != 0 {
+				t.Fatalf("Wanted the lobby dropped; got %d lobbies", lobbies)
+			}
+		},
+	}, {
+		name: "disconnect-while-playing",
+		play: func(t *testing.T, user *testUser) {
+			user.send("start")
+			user.awaitMode(ModeGame)
+			user.disconnect()
+			if lobbies := user.lobbies(); lobbies != 0 {
+				t.Fatalf("Wanted the lobby dropped; got %d lobbies", lobbies)
+			}
+		},
+	}} {
+		t.Run(testCase.name, func(t *testing.T) {
+			user := newTestUser(t, &GameManager{}, "alice")
+			testCase.play(t, user)
+		})
+	}
+}
+
+func TestUserSessionSendError(t *testing.T) {
+	// A user whose transport fails mid-broadcast is dropped once their
+	// session notices, without the broadcast deadlocking on the lobby's lock.
+	gm := &GameManager{}
+	alice := newTestUser(t, gm, "alice")
+	alice.awaitMode(ModeMatchMaking)
+	bob := newTestUser(t, gm, "bob")
+	alice.awaitMode(ModeGame)
+	bob.awaitMode(ModeGame)
+
+	alice.transport.Close("")
+	bob.send("down")
+	select {
+	case <-alice.done:
+	case <-time.After(testTimeout):
+		t.Fatal("Timed out waiting for alice's session to end")
+	}
+	bob.send("rtmm")
+	bob.awaitMode(ModeMatchMaking)
+}